@@ -0,0 +1,398 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/novaru/golem/config"
+	"github.com/novaru/golem/internal/auth"
+	"github.com/novaru/golem/internal/balancer"
+)
+
+// adminTokensEnv is the environment variable holding admin API tokens, in
+// addition to the tokens file.
+const adminTokensEnv = "GOLEM_ADMIN_TOKENS"
+
+// maxAdminBodyBytes bounds the request bodies the admin API reads.
+const maxAdminBodyBytes = 1 << 20
+
+var (
+	errNotFound = errors.New("not found")
+	errConflict = errors.New("already exists")
+)
+
+// adminAPI serves JSON endpoints that list the running pools and change
+// their backends, each open to a minimum role:
+//
+//	GET    /pools                          read-only  all pools and their backends
+//	GET    /pools/{pool}                   read-only  one pool
+//	PATCH  /pools/{pool}/backends?url=URL  operator   set a backend's state or weight
+//	POST   /pools/{pool}/backends          admin      add a backend
+//	DELETE /pools/{pool}/backends?url=URL  admin      remove a backend, draining it
+//	POST   /reload                         admin      reload the config file
+//
+// Changes to backends are validated like the config file and last until the
+// file is next reloaded. Every change is recorded in the audit log.
+type adminAPI struct {
+	rl    *reloader
+	audit *auditLog
+}
+
+// newAdminHandler returns the admin API. Callers must present one of tokens
+// unless tokens is nil.
+func newAdminHandler(rl *reloader, tokens *auth.Tokens, audit *auditLog) http.Handler {
+	a := &adminAPI{rl: rl, audit: audit}
+	mux := http.NewServeMux()
+	handle := func(pattern string, role auth.Role, h http.HandlerFunc) {
+		if tokens != nil {
+			mux.Handle(pattern, tokens.Require(role, h))
+			return
+		}
+		mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+			h(w, r.WithContext(auth.NewContext(r.Context(), anonymous)))
+		})
+	}
+	handle("GET /pools", auth.RoleReadOnly, a.listPools)
+	handle("GET /pools/{pool}", auth.RoleReadOnly, a.getPool)
+	handle("PATCH /pools/{pool}/backends", auth.RoleOperator, a.mutate(a.updateBackend))
+	handle("POST /pools/{pool}/backends", auth.RoleAdmin, a.mutate(a.addBackend))
+	handle("DELETE /pools/{pool}/backends", auth.RoleAdmin, a.mutate(a.removeBackend))
+	handle("POST /reload", auth.RoleAdmin, a.mutate(a.reload))
+	return mux
+}
+
+// anonymous is the caller of an admin API without tokens.
+var anonymous = auth.Caller{Name: "anonymous", Role: auth.RoleAdmin}
+
+// mutation changes the running pools. It returns the status to answer with
+// and the changed values before and after the call, for the audit log; after
+// is also the response body. Errors are answered with the status
+// adminErrorStatus picks.
+type mutation func(w http.ResponseWriter, r *http.Request) (status int, before, after any, err error)
+
+// mutate serves m and records the call in the audit log.
+func (a *adminAPI) mutate(m mutation) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status, before, after, err := m(w, r)
+		if err != nil {
+			status = adminErrorStatus(err)
+		}
+		caller, _ := auth.FromContext(r.Context())
+		entry := auditEntry{
+			Time:       time.Now().UTC(),
+			Caller:     caller.Name,
+			Role:       caller.Role.String(),
+			RemoteAddr: r.RemoteAddr,
+			Method:     r.Method,
+			Path:       r.URL.RequestURI(),
+			Status:     status,
+			Before:     before,
+			After:      after,
+		}
+		if err != nil {
+			entry.Error = err.Error()
+		}
+		a.audit.record(entry)
+
+		switch {
+		case err != nil:
+			writeJSON(w, status, map[string]string{"error": err.Error()})
+		case after == nil:
+			w.WriteHeader(status)
+		default:
+			writeJSON(w, status, after)
+		}
+	}
+}
+
+type poolStatus struct {
+	Name     string          `json:"name"`
+	Method   string          `json:"method"`
+	Backends []backendStatus `json:"backends"`
+}
+
+type backendStatus struct {
+	URL         string `json:"url"`
+	Healthy     bool   `json:"healthy"`
+	State       string `json:"state"`
+	Weight      int    `json:"weight"`
+	Connections int    `json:"connections"`
+	Streams     int    `json:"streams"`
+	Requests    uint64 `json:"requests"`
+	// ResponseTimeMs is the smoothed time to the response headers.
+	ResponseTimeMs float64 `json:"response_time_ms"`
+}
+
+// backendChange is the body of a PATCH; fields left out stay unchanged.
+type backendChange struct {
+	State  *string `json:"state,omitempty"`
+	Weight *int    `json:"weight,omitempty"`
+}
+
+func newBackendStatus(b *balancer.Backend) backendStatus {
+	return backendStatus{
+		URL:            b.URL,
+		Healthy:        b.IsHealthy(),
+		State:          string(b.State()),
+		Weight:         b.Weight(),
+		Connections:    b.GetConnections(),
+		Streams:        b.GetStreams(),
+		Requests:       b.Requests(),
+		ResponseTimeMs: float64(b.ResponseTime()) / float64(time.Millisecond),
+	}
+}
+
+func (a *adminAPI) poolStatus(name string) (poolStatus, error) {
+	pool, ok := a.rl.pools[name]
+	if !ok {
+		return poolStatus{}, fmt.Errorf("pool %s: %w", name, errNotFound)
+	}
+	poolCfg, _ := a.rl.poolConfig(name)
+	status := poolStatus{Name: name, Method: poolCfg.Method, Backends: []backendStatus{}}
+	for _, b := range pool.Backends() {
+		status.Backends = append(status.Backends, newBackendStatus(b))
+	}
+	return status, nil
+}
+
+// pools returns the status of every pool, by name.
+func (a *adminAPI) pools() ([]poolStatus, error) {
+	names := make([]string, 0, len(a.rl.pools))
+	for name := range a.rl.pools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pools := make([]poolStatus, 0, len(names))
+	for _, name := range names {
+		status, err := a.poolStatus(name)
+		if err != nil {
+			return nil, err
+		}
+		pools = append(pools, status)
+	}
+	return pools, nil
+}
+
+func (a *adminAPI) listPools(w http.ResponseWriter, r *http.Request) {
+	pools, err := a.pools()
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string][]poolStatus{"pools": pools})
+}
+
+func (a *adminAPI) getPool(w http.ResponseWriter, r *http.Request) {
+	status, err := a.poolStatus(r.PathValue("pool"))
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (a *adminAPI) addBackend(w http.ResponseWriter, r *http.Request) (int, any, any, error) {
+	name := r.PathValue("pool")
+	var add config.BackendConfig
+	if err := readJSON(w, r, &add); err != nil {
+		return 0, nil, nil, err
+	}
+	if add.URL == "" {
+		return 0, nil, nil, errors.New("url must not be empty")
+	}
+	if add.Weight < 0 {
+		return 0, nil, nil, errors.New("weight must not be negative")
+	}
+	err := a.rl.changePool(name, func(poolCfg *config.PoolConfig) error {
+		if slices.ContainsFunc(poolCfg.Backends, func(b config.BackendConfig) bool { return b.URL == add.URL }) {
+			return fmt.Errorf("backend %s: %w", add.URL, errConflict)
+		}
+		poolCfg.Backends = append(poolCfg.Backends, add)
+		return nil
+	})
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	log.Printf("[INFO] Added backend %s to pool %s through the admin API", add.URL, name)
+	return http.StatusCreated, nil, newBackendStatus(a.rl.pools[name].Backend(add.URL)), nil
+}
+
+func (a *adminAPI) updateBackend(w http.ResponseWriter, r *http.Request) (int, any, any, error) {
+	name, url := r.PathValue("pool"), r.URL.Query().Get("url")
+	var change backendChange
+	if err := readJSON(w, r, &change); err != nil {
+		return 0, nil, nil, err
+	}
+	var state balancer.State
+	if change.State != nil {
+		var err error
+		if state, err = balancer.ParseState(*change.State); err != nil {
+			return 0, nil, nil, err
+		}
+	}
+	if change.Weight != nil && *change.Weight < 1 {
+		return 0, nil, nil, errors.New("weight must be at least 1")
+	}
+
+	backend, err := a.backend(name, url)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	before := newBackendStatus(backend)
+	if change.Weight != nil {
+		err := a.rl.changePool(name, func(poolCfg *config.PoolConfig) error {
+			i, err := backendIndex(poolCfg, url)
+			if err != nil {
+				return err
+			}
+			poolCfg.Backends[i].Weight = *change.Weight
+			return nil
+		})
+		if err != nil {
+			return 0, before, nil, err
+		}
+		log.Printf("[INFO] Set weight of backend %s in pool %s to %d through the admin API", url, name, *change.Weight)
+	}
+	if change.State != nil {
+		backend.SetState(state)
+		log.Printf("[INFO] Set backend %s in pool %s %s through the admin API", url, name, state)
+	}
+	return http.StatusOK, before, newBackendStatus(backend), nil
+}
+
+func (a *adminAPI) removeBackend(w http.ResponseWriter, r *http.Request) (int, any, any, error) {
+	name, url := r.PathValue("pool"), r.URL.Query().Get("url")
+	backend, err := a.backend(name, url)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	before := newBackendStatus(backend)
+	err = a.rl.changePool(name, func(poolCfg *config.PoolConfig) error {
+		i, err := backendIndex(poolCfg, url)
+		if err != nil {
+			return err
+		}
+		poolCfg.Backends = slices.Delete(poolCfg.Backends, i, i+1)
+		return nil
+	})
+	if err != nil {
+		return 0, before, nil, err
+	}
+	log.Printf("[INFO] Removed backend %s from pool %s through the admin API", url, name)
+	return http.StatusNoContent, before, nil, nil
+}
+
+func (a *adminAPI) reload(w http.ResponseWriter, r *http.Request) (int, any, any, error) {
+	if a.rl.path == "" {
+		return 0, nil, nil, errors.New("golem was started without a config file")
+	}
+	before, err := a.pools()
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	if err := a.rl.Reload(); err != nil {
+		return 0, before, nil, err
+	}
+	after, err := a.pools()
+	return http.StatusOK, before, after, err
+}
+
+// backend returns the backend at url in the named pool.
+func (a *adminAPI) backend(name, url string) (*balancer.Backend, error) {
+	pool, ok := a.rl.pools[name]
+	if !ok {
+		return nil, fmt.Errorf("pool %s: %w", name, errNotFound)
+	}
+	if b := pool.Backend(url); b != nil {
+		return b, nil
+	}
+	return nil, fmt.Errorf("backend %q: %w", url, errNotFound)
+}
+
+// backendIndex returns the position of the backend at url in poolCfg.
+func backendIndex(poolCfg *config.PoolConfig, url string) (int, error) {
+	i := slices.IndexFunc(poolCfg.Backends, func(b config.BackendConfig) bool { return b.URL == url })
+	if i < 0 {
+		return 0, fmt.Errorf("backend %q: %w", url, errNotFound)
+	}
+	return i, nil
+}
+
+// readJSON decodes the request body into v, rejecting unknown fields.
+func readJSON(w http.ResponseWriter, r *http.Request, v any) error {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAdminBodyBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("invalid request body: %w", err)
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeAdminError reports err as JSON.
+func writeAdminError(w http.ResponseWriter, err error) {
+	writeJSON(w, adminErrorStatus(err), map[string]string{"error": err.Error()})
+}
+
+// adminErrorStatus returns 404 for unknown pools and backends, 409 for
+// duplicates and 400 for other errors.
+func adminErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errConflict):
+		return http.StatusConflict
+	}
+	return http.StatusBadRequest
+}
+
+// auditEntry records a call that changes the running pools.
+type auditEntry struct {
+	Time       time.Time `json:"time"`
+	Caller     string    `json:"caller"`
+	Role       string    `json:"role"`
+	RemoteAddr string    `json:"remote_addr"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Status     int       `json:"status"`
+	Error      string    `json:"error,omitempty"`
+	Before     any       `json:"before"`
+	After      any       `json:"after"`
+}
+
+// auditLog writes one JSON line per mutating admin API call to w, or to the
+// process log when w is nil.
+type auditLog struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *auditLog) record(e auditEntry) {
+	line, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("[ERROR] Failed to encode audit log entry: %v", err)
+		return
+	}
+	if l.w == nil {
+		log.Printf("[INFO] Audit: %s", line)
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.w.Write(append(line, '\n')); err != nil {
+		log.Printf("[ERROR] Failed to write audit log entry %s: %v", line, err)
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/novaru/golem/config"
+	"github.com/novaru/golem/internal/auth"
+	"github.com/novaru/golem/internal/balancer"
+	"github.com/novaru/golem/internal/server"
+)
+
+// adminRequest sends a request to h and returns the response status and
+// body.
+func adminRequest(t *testing.T, h http.Handler, method, target, body string) (int, string) {
+	t.Helper()
+	return adminRequestAs(t, h, "", method, target, body)
+}
+
+// adminRequestAs is adminRequest presenting a bearer token, if set.
+func adminRequestAs(t *testing.T, h http.Handler, token, method, target, body string) (int, string) {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec.Code, rec.Body.String()
+}
+
+// Tokens of the callers in the RBAC tests.
+const (
+	readerToken   = "reader-0123456789abcdef"
+	operatorToken = "operator-0123456789abcdef"
+	adminToken    = "admin-0123456789abcdef"
+)
+
+func loadTestTokens(t *testing.T) *auth.Tokens {
+	t.Helper()
+	t.Setenv(adminTokensEnv, "dash:read-only:"+readerToken+",oncall:operator:"+operatorToken+",root:admin:"+adminToken)
+	tokens, err := auth.LoadTokens("", adminTokensEnv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tokens
+}
+
+func TestAdminListsPools(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "golem.json")
+	writeFile(t, path, `{"port": 8080, "method": "leastconn",
+		"backends": [{"url": "http://a", "weight": 2}, {"url": "http://b"}],
+		"pools": {"api": {"method": "roundrobin", "backends": [{"url": "http://c"}]}}}`)
+	rl, pools := startReloader(t, path)
+	a := pools[config.DefaultPool].Backend("http://a")
+	a.AddConnections()
+	a.ObserveResponseTime(0)
+
+	code, body := adminRequest(t, newAdminHandler(rl, nil, &auditLog{w: io.Discard}), "GET", "/pools", "")
+	if code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", code, body)
+	}
+	var got struct{ Pools []poolStatus }
+	if err := json.Unmarshal([]byte(body), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Pools) != 2 || got.Pools[0].Name != "api" || got.Pools[1].Name != config.DefaultPool {
+		t.Fatalf("expected pools api and default, got %+v", got.Pools)
+	}
+	want := backendStatus{URL: "http://a", Healthy: true, State: "up", Weight: 2, Connections: 1, Requests: 1}
+	if def := got.Pools[1]; def.Method != "leastconn" || len(def.Backends) != 2 || def.Backends[0] != want {
+		t.Errorf("expected %+v first in a leastconn pool, got %+v", want, def)
+	}
+
+	if code, _ := adminRequest(t, newAdminHandler(rl, nil, &auditLog{w: io.Discard}), "GET", "/pools/missing", ""); code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown pool, got %d", code)
+	}
+}
+
+func TestAdminChangesBackends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "golem.json")
+	writeFile(t, path, `{"port": 8080, "method": "roundrobin",
+		"backends": [{"url": "http://a"}, {"url": "http://b"}]}`)
+	rl, pools := startReloader(t, path)
+	pool := pools[config.DefaultPool]
+	h := newAdminHandler(rl, nil, &auditLog{w: io.Discard})
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		code   int
+	}{
+		{"add", "POST", "/pools/default/backends", `{"url": "http://c", "weight": 4}`, http.StatusCreated},
+		{"add duplicate", "POST", "/pools/default/backends", `{"url": "http://c"}`, http.StatusConflict},
+		{"add to unknown pool", "POST", "/pools/missing/backends", `{"url": "http://d"}`, http.StatusNotFound},
+		{"unknown field", "POST", "/pools/default/backends", `{"url": "http://d", "wieght": 2}`, http.StatusBadRequest},
+		{"invalid url", "POST", "/pools/default/backends", `{"url": "not a url"}`, http.StatusBadRequest},
+		{"tcp backend in an http pool", "POST", "/pools/default/backends", `{"url": "tcp://10.0.0.5:5432"}`, http.StatusBadRequest},
+		{"drain", "PATCH", "/pools/default/backends?url=http://a", `{"state": "draining", "weight": 3}`, http.StatusOK},
+		{"unknown state", "PATCH", "/pools/default/backends?url=http://a", `{"state": "maintenance"}`, http.StatusBadRequest},
+		{"zero weight", "PATCH", "/pools/default/backends?url=http://a", `{"weight": 0}`, http.StatusBadRequest},
+		{"unknown backend", "PATCH", "/pools/default/backends?url=http://x", `{"state": "down"}`, http.StatusNotFound},
+		{"remove", "DELETE", "/pools/default/backends?url=http://b", "", http.StatusNoContent},
+	}
+	for _, tc := range tests {
+		if code, body := adminRequest(t, h, tc.method, tc.target, tc.body); code != tc.code {
+			t.Errorf("%s: expected %d, got %d: %s", tc.name, tc.code, code, body)
+		}
+	}
+
+	a, c := pool.Backend("http://a"), pool.Backend("http://c")
+	if a == nil || a.State() != balancer.StateDraining || a.Weight() != 3 {
+		t.Fatalf("expected a draining with weight 3, got %+v", a)
+	}
+	if c == nil || c.Weight() != 4 || pool.Backend("http://b") != nil {
+		t.Fatalf("expected backends a and c, got %d backends", len(pool.Backends()))
+	}
+	for range 3 {
+		if next, _ := pool.Balancer.NextBackend(); next != c {
+			t.Fatalf("expected only c while a drains, got %s", next.URL)
+		}
+	}
+
+	// The last backend of a pool cannot be removed, as in the config file.
+	adminRequest(t, h, "DELETE", "/pools/default/backends?url=http://a", "")
+	if code, _ := adminRequest(t, h, "DELETE", "/pools/default/backends?url=http://c", ""); code != http.StatusBadRequest {
+		t.Errorf("expected 400 when removing the last backend, got %d", code)
+	}
+	if pool.Backend("http://c") != c {
+		t.Error("expected the pool to keep its last backend")
+	}
+}
+
+func TestAdminWeightShiftsTraffic(t *testing.T) {
+	hits := make(map[string]int)
+	var mu sync.Mutex
+	backend := func(name string) string {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			hits[name]++
+			mu.Unlock()
+		}))
+		t.Cleanup(srv.Close)
+		return srv.URL
+	}
+	a, b := backend("a"), backend("b")
+	path := filepath.Join(t.TempDir(), "golem.json")
+	writeFile(t, path, fmt.Sprintf(`{"port": 8080, "method": "roundrobin",
+		"backends": [{"url": %q}, {"url": %q}]}`, a, b))
+	rl, pools := startReloader(t, path)
+	proxy := server.NewProxyServer(pools[config.DefaultPool].Balancer)
+	send := func(n int) map[string]int {
+		clear(hits)
+		for range n {
+			rec := httptest.NewRecorder()
+			proxy.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected 200 from the proxy, got %d", rec.Code)
+			}
+		}
+		return maps.Clone(hits)
+	}
+
+	if got := send(40); got["a"] != 20 || got["b"] != 20 {
+		t.Fatalf("expected an even split before the change, got %v", got)
+	}
+	h := newAdminHandler(rl, nil, &auditLog{w: io.Discard})
+	if code, body := adminRequest(t, h, "PATCH", "/pools/default/backends?url="+url.QueryEscape(a), `{"weight": 3}`); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", code, body)
+	}
+	if got := send(40); got["a"] != 30 || got["b"] != 10 {
+		t.Errorf("expected a 30/10 split after raising the weight of a to 3, got %v", got)
+	}
+}
+
+func TestAdminRequiresRole(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "golem.json")
+	writeFile(t, path, `{"port": 8080, "method": "roundrobin",
+		"backends": [{"url": "http://a"}, {"url": "http://b"}]}`)
+	rl, _ := startReloader(t, path)
+	h := newAdminHandler(rl, loadTestTokens(t), &auditLog{w: io.Discard})
+
+	tests := []struct {
+		name   string
+		token  string
+		method string
+		target string
+		body   string
+		code   int
+	}{
+		{"no token", "", "GET", "/pools", "", http.StatusUnauthorized},
+		{"unknown token", "guess-0123456789abcdef", "GET", "/pools", "", http.StatusUnauthorized},
+		{"reader lists", readerToken, "GET", "/pools", "", http.StatusOK},
+		{"reader drains", readerToken, "PATCH", "/pools/default/backends?url=http://a", `{"state": "draining"}`, http.StatusForbidden},
+		{"operator drains", operatorToken, "PATCH", "/pools/default/backends?url=http://a", `{"state": "draining"}`, http.StatusOK},
+		{"operator removes", operatorToken, "DELETE", "/pools/default/backends?url=http://a", "", http.StatusForbidden},
+		{"operator reloads", operatorToken, "POST", "/reload", "", http.StatusForbidden},
+		{"admin adds", adminToken, "POST", "/pools/default/backends", `{"url": "http://c"}`, http.StatusCreated},
+		{"admin reloads", adminToken, "POST", "/reload", "", http.StatusOK},
+	}
+	for _, tc := range tests {
+		if code, body := adminRequestAs(t, h, tc.token, tc.method, tc.target, tc.body); code != tc.code {
+			t.Errorf("%s: expected %d, got %d: %s", tc.name, tc.code, code, body)
+		}
+	}
+}
+
+func TestAdminAuditsChanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "golem.json")
+	writeFile(t, path, `{"port": 8080, "method": "roundrobin", "backends": [{"url": "http://a"}]}`)
+	rl, _ := startReloader(t, path)
+	var buf bytes.Buffer
+	h := newAdminHandler(rl, loadTestTokens(t), &auditLog{w: &buf})
+
+	adminRequestAs(t, h, readerToken, "GET", "/pools", "")
+	adminRequestAs(t, h, operatorToken, "PATCH", "/pools/default/backends?url=http://a", `{"weight": 7}`)
+	adminRequestAs(t, h, adminToken, "DELETE", "/pools/default/backends?url=http://a", "")
+
+	type entry struct {
+		Caller, Role, Method, Error string
+		Status                      int
+		Before, After               *backendStatus
+	}
+	var entries []entry
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var e entry
+		if err := dec.Decode(&e); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, e)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected an entry per mutating call, got %d", len(entries))
+	}
+	patch := entries[0]
+	if patch.Caller != "oncall" || patch.Role != "operator" || patch.Method != "PATCH" || patch.Status != http.StatusOK {
+		t.Errorf("unexpected entry for the weight change: %+v", patch)
+	}
+	if patch.Before == nil || patch.Before.Weight != 1 || patch.After == nil || patch.After.Weight != 7 {
+		t.Errorf("expected weight 1 before and 7 after, got %+v and %+v", patch.Before, patch.After)
+	}
+	// Removing the only backend fails validation, and is audited anyway.
+	del := entries[1]
+	if del.Caller != "root" || del.Status != http.StatusBadRequest || del.Error == "" || del.Before == nil || del.After != nil {
+		t.Errorf("unexpected entry for the failed removal: %+v", del)
+	}
+}
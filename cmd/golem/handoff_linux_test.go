@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// freePort returns a TCP port that was free a moment ago.
+func freePort(t *testing.T) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+// TestUpgradeKeepsServing builds golem, upgrades it with SIGUSR2 while
+// clients keep sending requests on new connections, and checks that none of
+// them fails.
+func TestUpgradeKeepsServing(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds and runs the golem binary")
+	}
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "golem")
+	if out, err := exec.Command("go", "build", "-o", bin, ".").CombinedOutput(); err != nil {
+		t.Fatalf("build failed: %v\n%s", err, out)
+	}
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		io.WriteString(w, "ok")
+	}))
+	defer backend.Close()
+
+	port := freePort(t)
+	writeFile(t, filepath.Join(dir, "golem.json"), fmt.Sprintf(
+		`{"port": %d, "method": "roundrobin", "backends": [{"url": %q}], "grace_period": "5s"}`, port, backend.URL))
+
+	cmd := exec.Command(bin)
+	cmd.Dir = dir
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	// A pipe of our own outlives the old process, unlike cmd.StderrPipe,
+	// so the new one can keep logging to it.
+	logs, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logs.Close()
+	cmd.Stderr = w
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The new process joins the process group of the old one.
+	t.Cleanup(func() { syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL) })
+	var tookOver atomic.Bool
+	go func() {
+		scanner := bufio.NewScanner(logs)
+		for scanner.Scan() {
+			if strings.Contains(scanner.Text(), "Took over the listening sockets") {
+				tookOver.Store(true)
+			}
+		}
+	}()
+
+	url := fmt.Sprintf("http://127.0.0.1:%d/", port)
+	client := &http.Client{
+		Timeout:   5 * time.Second,
+		Transport: &http.Transport{DisableKeepAlives: true},
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		resp, err := client.Get(url)
+		if err == nil {
+			resp.Body.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("golem did not start: %v", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	var ok, failed atomic.Int64
+	var firstErr atomic.Value
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				resp, err := client.Get(url)
+				if err == nil {
+					body, _ := io.ReadAll(resp.Body)
+					resp.Body.Close()
+					if resp.StatusCode != http.StatusOK || string(body) != "ok" {
+						err = fmt.Errorf("status %d, body %q", resp.StatusCode, body)
+					}
+				}
+				if err != nil {
+					failed.Add(1)
+					firstErr.CompareAndSwap(nil, err)
+					continue
+				}
+				ok.Add(1)
+			}
+		}()
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	if err := cmd.Process.Signal(syscall.SIGUSR2); err != nil {
+		t.Fatal(err)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	select {
+	case err := <-exited:
+		if err != nil {
+			t.Errorf("old process exited with %v", err)
+		}
+	case <-time.After(20 * time.Second):
+		t.Fatal("old process did not exit after the upgrade")
+	}
+	before := ok.Load()
+	time.Sleep(300 * time.Millisecond)
+	close(stop)
+	wg.Wait()
+
+	if n := failed.Load(); n > 0 {
+		t.Errorf("%d of %d requests failed during the upgrade, first: %v", n, n+ok.Load(), firstErr.Load())
+	}
+	if ok.Load() == before {
+		t.Error("no requests were served after the old process exited")
+	}
+	if !tookOver.Load() {
+		t.Error("new process did not report taking over the sockets")
+	}
+}
@@ -1,19 +1,336 @@
 package main
 
 import (
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"net/netip"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/novaru/golem/config"
+	"github.com/novaru/golem/internal/auth"
 	"github.com/novaru/golem/internal/balancer"
+	"github.com/novaru/golem/internal/handoff"
 	"github.com/novaru/golem/internal/metrics"
+	"github.com/novaru/golem/internal/proxyproto"
 	"github.com/novaru/golem/internal/server"
+	"github.com/novaru/golem/internal/tcpproxy"
+	"github.com/novaru/golem/internal/tlsutil"
+	"github.com/novaru/golem/internal/transport"
+	"github.com/novaru/golem/internal/udpproxy"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+const healthCheckInterval = 5 * time.Second
+
+// certWatchInterval is how often TLS certificate files are checked for
+// changes.
+const certWatchInterval = 10 * time.Second
+
+// newPool builds a named backend pool from its file configuration.
+func newPool(name string, poolCfg config.PoolConfig) (*balancer.Pool, error) {
+	backends, err := newBackends(name, poolCfg, nil)
+	if err != nil {
+		return nil, err
+	}
+	return balancer.NewPool(name, poolCfg.Method, backends, healthCheckInterval)
+}
+
+// newBackends builds the backends of a pool. Backends that keep returns for
+// their URL are reused instead; keep may be nil.
+func newBackends(name string, poolCfg config.PoolConfig, keep func(url string) *balancer.Backend) ([]*balancer.Backend, error) {
+	opts := transportOptions(poolCfg.Transport, poolCfg.Protocol)
+	opts.ProxyProtocol = proxyProtocolVersion(poolCfg.ProxyProtocol)
+	if tc := poolCfg.TLS; tc != nil {
+		tlsConfig, err := tlsutil.ClientConfig(tlsutil.ClientOptions{
+			CAFile:             tc.CAFile,
+			CertFile:           tc.CertFile,
+			KeyFile:            tc.KeyFile,
+			ServerName:         tc.ServerName,
+			InsecureSkipVerify: tc.InsecureSkipVerify,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("backend tls: %w", err)
+		}
+		if tc.InsecureSkipVerify {
+			log.Printf("[WARN] Pool %s does not verify backend certificates", name)
+		}
+		opts.TLSClientConfig = tlsConfig
+	}
+
+	backends := make([]*balancer.Backend, 0, len(poolCfg.Backends))
+	for _, b := range poolCfg.Backends {
+		if keep != nil {
+			if backend := keep(b.URL); backend != nil {
+				backends = append(backends, backend)
+				continue
+			}
+		}
+		backend := balancer.NewBackend(b.URL, backendWeight(b))
+		if !strings.HasPrefix(b.URL, balancer.TCPScheme) && !strings.HasPrefix(b.URL, balancer.UDPScheme) {
+			backend.Transport = transport.New(b.URL, opts)
+		}
+		backends = append(backends, backend)
+	}
+	return backends, nil
+}
+
+// backendWeight returns the configured weight of a backend, 1 by default.
+func backendWeight(b config.BackendConfig) int {
+	if b.Weight <= 0 {
+		return 1
+	}
+	return b.Weight
+}
+
+// defaultPoolConfig describes the implicit pool of the top-level backends.
+func defaultPoolConfig(cfg *config.Config, weights map[string]int) config.PoolConfig {
+	poolCfg := config.PoolConfig{
+		Method:    cfg.Method,
+		Transport: cfg.Transport,
+		Protocol:  cfg.BackendProtocol,
+		TLS:       cfg.BackendTLS,
+	}
+	for _, url := range cfg.Backends {
+		weight := 1
+		if w, found := weights[url]; found {
+			weight = w
+		}
+		poolCfg.Backends = append(poolCfg.Backends, config.BackendConfig{URL: url, Weight: weight})
+	}
+	return poolCfg
+}
+
+// newTCPProxy builds the proxy of a tcp mode listener. With SNI routes and no
+// pool, connections matching no route are rejected.
+func newTCPProxy(lc config.ListenerConfig, pools map[string]*balancer.Pool, poolCfgs map[string]config.PoolConfig) *tcpproxy.Proxy {
+	proxy := &tcpproxy.Proxy{
+		Name:        lc.Name,
+		IdleTimeout: time.Duration(lc.IdleTimeout),
+	}
+	if lc.Pool != "" {
+		proxy.Balancer = pools[lc.Pool].Balancer
+		proxy.ProxyProtocol = proxyProtocolVersion(poolCfgs[lc.Pool].ProxyProtocol)
+	}
+	for _, r := range lc.SNI {
+		proxy.Routes = append(proxy.Routes, tcpproxy.SNIRoute{
+			ServerName:    r.ServerName,
+			Balancer:      pools[r.Pool].Balancer,
+			ProxyProtocol: proxyProtocolVersion(poolCfgs[r.Pool].ProxyProtocol),
+		})
+	}
+	return proxy
+}
+
+// newSniffOptions builds the TCP proxies a sniff mode listener hands TLS and
+// raw TCP connections to. The proxies are also returned for draining.
+func newSniffOptions(lc config.ListenerConfig, pools map[string]*balancer.Pool, poolCfgs map[string]config.PoolConfig) (*server.SniffOptions, []*tcpproxy.Proxy) {
+	opts := &server.SniffOptions{}
+	if lc.Sniff == nil {
+		return opts, nil
+	}
+	opts.Timeout = time.Duration(lc.Sniff.Timeout)
+	var proxies []*tcpproxy.Proxy
+	newProxy := func(pool string) *tcpproxy.Proxy {
+		p := &tcpproxy.Proxy{
+			Name:          lc.Name,
+			Balancer:      pools[pool].Balancer,
+			IdleTimeout:   time.Duration(lc.IdleTimeout),
+			ProxyProtocol: proxyProtocolVersion(poolCfgs[pool].ProxyProtocol),
+		}
+		proxies = append(proxies, p)
+		return p
+	}
+	if lc.Sniff.TLSPool != "" {
+		opts.TLS = newProxy(lc.Sniff.TLSPool)
+	}
+	if lc.Sniff.TCPPool != "" {
+		opts.TCP = newProxy(lc.Sniff.TCPPool)
+	}
+	return opts, proxies
+}
+
+// listen opens the TCP sockets of a port: n sharing it through SO_REUSEPORT
+// when n is above 1, or a single one.
+func listen(sockets *handoff.Sockets, addr string, n int) ([]net.Listener, error) {
+	if n > 1 {
+		return sockets.ListenReusePort("tcp", addr, n)
+	}
+	ln, err := sockets.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return []net.Listener{ln}, nil
+}
+
+// listenPacket is listen for UDP sockets.
+func listenPacket(sockets *handoff.Sockets, addr string, n int) ([]net.PacketConn, error) {
+	if n > 1 {
+		return sockets.ListenPacketReusePort("udp", addr, n)
+	}
+	pc, err := sockets.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return []net.PacketConn{pc}, nil
+}
+
+// proxyProtocolFrom returns the peers trusted to send PROXY protocol headers.
+// The config has been validated.
+func proxyProtocolFrom(pc *config.ProxyProtocolConfig) []netip.Prefix {
+	if pc == nil {
+		return nil
+	}
+	trusted, _ := config.ParseCIDRs(pc.TrustedProxies)
+	return trusted
+}
+
+// proxyProtocolVersion converts a pool's proxy_protocol setting.
+func proxyProtocolVersion(v string) int {
+	switch v {
+	case config.ProxyProtocolV1:
+		return proxyproto.V1
+	case config.ProxyProtocolV2:
+		return proxyproto.V2
+	}
+	return 0
+}
+
+// transportOptions converts the file configuration of a backend transport.
+// A nil config yields the defaults.
+func transportOptions(tc *config.TransportConfig, protocol string) transport.Options {
+	if tc == nil {
+		return transport.Options{Protocol: protocol}
+	}
+	return transport.Options{
+		Protocol:              protocol,
+		MaxIdleConns:          tc.MaxIdleConns,
+		MaxConnsPerHost:       tc.MaxConnsPerHost,
+		IdleConnTimeout:       time.Duration(tc.IdleConnTimeout),
+		KeepAlive:             time.Duration(tc.KeepAlive),
+		DialTimeout:           time.Duration(tc.DialTimeout),
+		TLSHandshakeTimeout:   time.Duration(tc.TLSHandshakeTimeout),
+		ResponseHeaderTimeout: time.Duration(tc.ResponseHeaderTimeout),
+		ExpectContinueTimeout: time.Duration(tc.ExpectContinueTimeout),
+	}
+}
+
+// newRoutes builds the proxy routes from their file configuration. Pool names
+// must already have been validated.
+func newRoutes(routeCfgs []config.RouteConfig, pools map[string]*balancer.Pool) []*server.Route {
+	routes := make([]*server.Route, 0, len(routeCfgs))
+	for _, rc := range routeCfgs {
+		route := &server.Route{
+			Name:          rc.Name,
+			Host:          rc.Host,
+			PathPrefix:    rc.PathPrefix,
+			HostPolicy:    server.HostPolicy(rc.HostHeader),
+			Streaming:     rc.Streaming,
+			Timeout:       time.Duration(rc.Timeout),
+			FlushInterval: time.Duration(rc.FlushInterval),
+			GRPC:          rc.GRPC,
+			GRPCMethods:   rc.GRPCMethods,
+		}
+		if rc.ClientCert != nil {
+			route.ClientCert = certPolicy(rc.ClientCert)
+		}
+		if rc.Pool != "" {
+			route.Balancer = pools[rc.Pool].Balancer
+		}
+		if rc.SSE != nil {
+			route.SSE = &server.SSEOptions{
+				HeartbeatInterval: time.Duration(rc.SSE.HeartbeatInterval),
+				Sticky:            rc.SSE.Sticky,
+			}
+		}
+		if rc.Headers != nil {
+			route.Headers = &server.HeaderRules{
+				Request:  server.HeaderOps(rc.Headers.Request),
+				Response: server.HeaderOps(rc.Headers.Response),
+			}
+		}
+		routes = append(routes, route)
+	}
+	return routes
+}
+
+// newServerTLSConfig loads a listener's certificates and builds its TLS
+// configuration. The returned store can reload the certificates.
+func newServerTLSConfig(tc *config.ListenerTLSConfig) (*tls.Config, *tlsutil.CertStore, error) {
+	pairs := make([]tlsutil.CertPair, len(tc.Certificates))
+	for i, c := range tc.Certificates {
+		pairs[i] = tlsutil.CertPair{CertFile: c.CertFile, KeyFile: c.KeyFile}
+	}
+	store, err := tlsutil.NewCertStore(pairs)
+	if err != nil {
+		return nil, nil, err
+	}
+	minVersion, err := tlsutil.ParseVersion(tc.MinVersion)
+	if err != nil {
+		return nil, nil, err
+	}
+	cipherSuites, err := tlsutil.ParseCipherSuites(tc.CipherSuites)
+	if err != nil {
+		return nil, nil, err
+	}
+	opts := tlsutil.ServerOptions{
+		MinVersion:   minVersion,
+		CipherSuites: cipherSuites,
+	}
+	if ca := tc.ClientAuth; ca != nil {
+		opts.ClientCAs, err = tlsutil.LoadCertPool(ca.CAFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("client_auth: %w", err)
+		}
+		opts.ClientCertOptional = ca.Optional
+		if len(ca.Allow) > 0 || len(ca.Deny) > 0 {
+			opts.ClientPolicy = certPolicy(&ca.CertPolicyConfig)
+		}
+	}
+	return tlsutil.ServerConfig(store, opts), store, nil
+}
+
+// certPolicy converts a client certificate policy from its file configuration.
+func certPolicy(pc *config.CertPolicyConfig) *tlsutil.CertPolicy {
+	convert := func(matchers []config.CertMatcherConfig) []tlsutil.CertMatcher {
+		out := make([]tlsutil.CertMatcher, len(matchers))
+		for i, m := range matchers {
+			out[i] = tlsutil.CertMatcher(m)
+		}
+		return out
+	}
+	return &tlsutil.CertPolicy{Allow: convert(pc.Allow), Deny: convert(pc.Deny)}
+}
+
+// reloadOnSIGHUP reloads every certificate store, and the config file when
+// there is one, when the process receives SIGHUP. Stores that fail to reload
+// keep their previous certificates.
+func reloadOnSIGHUP(stores []*tlsutil.CertStore, rl *reloader) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			for _, store := range stores {
+				if err := store.Reload(); err != nil {
+					log.Printf("[ERROR] Failed to reload TLS certificates, keeping the previous ones: %v", err)
+					continue
+				}
+				log.Printf("[INFO] Reloaded TLS certificates on SIGHUP")
+			}
+			if rl.path != "" {
+				rl.Reload()
+			}
+		}
+	}()
+}
+
 func main() {
 	var cfg *config.Config
 	var backendWeights map[string]int
@@ -42,39 +359,303 @@ func main() {
 	flag.IntVar(&cfg.Port, "port", originalPort, "Port to listen on")
 	flag.Var(&cfg.Backends, "backend", "Backend server URL (comma-separated or repeated)")
 	flag.StringVar(&cfg.Method, "method", originalMethod, "Load balancing method")
+	flag.Var(&cfg.GracePeriod, "grace-period", "Time to wait for in-flight requests on shutdown (default 30s)")
+	flag.Var(&cfg.DrainDelay, "drain-delay", "Time to fail readiness while still accepting connections on shutdown")
+	flag.IntVar(&cfg.ReusePort, "reuse-port", cfg.ReusePort, "Number of SO_REUSEPORT listeners to open on the port (0 for one plain listener)")
 	flag.Parse()
 
+	// Flags keep overriding the file when it is reloaded.
+	overrides := &config.Config{}
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "port":
+			overrides.Port = cfg.Port
+		case "backend":
+			overrides.Backends = cfg.Backends
+		case "method":
+			overrides.Method = cfg.Method
+		case "grace-period":
+			overrides.GracePeriod = cfg.GracePeriod
+		case "drain-delay":
+			overrides.DrainDelay = cfg.DrainDelay
+		case "reuse-port":
+			overrides.ReusePort = cfg.ReusePort
+		}
+	})
+
 	if err := cfg.Validate(); err != nil {
 		log.Fatalf("Invalid configuration: %v", err)
 	}
 
-	backends := []*balancer.Backend{}
-	for _, url := range cfg.Backends {
-		weight := 1
-		if w, found := backendWeights[url]; found {
-			weight = w
-		}
-		backends = append(backends, balancer.NewBackend(url, weight))
-	}
+	defaultPoolCfg := defaultPoolConfig(cfg, backendWeights)
 
 	metrics.SetLoadBalancerInfo("v1.0.0", cfg.Method)
 
-	bal, err := balancer.NewBalancer(cfg.Method, backends)
+	defaultPool, err := newPool(config.DefaultPool, defaultPoolCfg)
 	if err != nil {
 		log.Fatalf("Failed to create new balancer: %v", err)
 	}
+	defaultPool.Start()
+	defer defaultPool.Stop()
+
+	pools := map[string]*balancer.Pool{config.DefaultPool: defaultPool}
+	for name, poolCfg := range cfg.Pools {
+		pool, err := newPool(name, poolCfg)
+		if err != nil {
+			log.Fatalf("Failed to create pool %s: %v", name, err)
+		}
+		pool.Start()
+		defer pool.Stop()
+		pools[name] = pool
+	}
 
-	healthChecker := balancer.NewHealthChecker(backends, 5*time.Second)
-	healthChecker.Start()
-	defer healthChecker.Stop()
+	proxy := server.NewProxyServer(defaultPool.Balancer)
+	proxy.Routes = newRoutes(cfg.Routes, pools)
+	proxy.Timeout = time.Duration(cfg.Timeout)
+	proxy.FlushInterval = time.Duration(cfg.FlushInterval)
+	if cfg.HostHeader != "" {
+		proxy.HostPolicy = server.HostPolicy(cfg.HostHeader)
+	}
+	if cfg.Upgrade != nil {
+		proxy.Upgrade = server.UpgradeOptions{
+			IdleTimeout: time.Duration(cfg.Upgrade.IdleTimeout),
+			MaxDuration: time.Duration(cfg.Upgrade.MaxDuration),
+		}
+	}
+	if cfg.Forwarded != nil {
+		trusted, err := config.ParseCIDRs(cfg.Forwarded.TrustedProxies)
+		if err != nil {
+			log.Fatalf("Invalid trusted proxies: %v", err)
+		}
+		proxy.Forwarding.TrustedProxies = trusted
+		switch cfg.Forwarded.Mode {
+		case config.ForwardedModeRFC7239:
+			proxy.Forwarding.XForwarded = false
+			proxy.Forwarding.Forwarded = true
+		case config.ForwardedModeBoth:
+			proxy.Forwarding.Forwarded = true
+		}
+	}
+	if h := cfg.ClientCertHeaders; h != nil {
+		proxy.ClientCertHeaders = &server.ClientCertHeaders{
+			Subject:     h.Subject,
+			SAN:         h.SAN,
+			Fingerprint: h.Fingerprint,
+		}
+	}
+	if cfg.Mirror != nil {
+		mirror := server.NewMirror(pools[cfg.Mirror.Pool].Balancer, cfg.Mirror.Percent)
+		mirror.Timeout = cfg.Mirror.Timeout.Or(mirror.Timeout)
+		if cfg.Mirror.MaxBodyBytes > 0 {
+			mirror.MaxBodyBytes = cfg.Mirror.MaxBodyBytes
+		}
+		proxy.Mirror = mirror
+		fmt.Printf("Mirroring %.1f%% of requests to pool %s\n", cfg.Mirror.Percent, cfg.Mirror.Pool)
+	}
 
-	proxy := server.NewProxyServer(bal)
-	addr := fmt.Sprintf(":%d", cfg.Port)
+	fe := &frontends{ready: &server.Readiness{}, proxy: proxy}
 
 	mux := http.NewServeMux()
 	mux.Handle("/", proxy)
 	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/ready", fe.ready)
+
+	listeners := []server.ListenerOptions{{
+		Name:              "main",
+		Addr:              fmt.Sprintf(":%d", cfg.Port),
+		H2C:               cfg.H2C,
+		ProxyProtocolFrom: proxyProtocolFrom(cfg.ProxyProtocol),
+		ReusePort:         cfg.ReusePort,
+	}}
+	var certStores []*tlsutil.CertStore
+	var tcpListeners, udpListeners []config.ListenerConfig
+	for _, lc := range cfg.Listeners {
+		switch lc.Mode {
+		case config.ListenerModeTCP:
+			tcpListeners = append(tcpListeners, lc)
+			continue
+		case config.ListenerModeUDP:
+			udpListeners = append(udpListeners, lc)
+			continue
+		}
+		opts := server.ListenerOptions{
+			Name:              lc.Name,
+			Addr:              fmt.Sprintf(":%d", lc.Port),
+			H2C:               lc.H2C,
+			ProxyProtocolFrom: proxyProtocolFrom(lc.ProxyProtocol),
+			ReusePort:         lc.ReusePort,
+		}
+		if lc.TLS != nil {
+			tlsConfig, store, err := newServerTLSConfig(lc.TLS)
+			if err != nil {
+				log.Fatalf("Failed to configure TLS for listener %s: %v", lc.Name, err)
+			}
+			opts.TLSConfig = tlsConfig
+			store.Watch(certWatchInterval)
+			defer store.Stop()
+			certStores = append(certStores, store)
+		}
+		if lc.Mode == config.ListenerModeSniff {
+			var tcpProxies []*tcpproxy.Proxy
+			opts.Sniff, tcpProxies = newSniffOptions(lc, pools, cfg.Pools)
+			fe.tcp = append(fe.tcp, tcpProxies...)
+		}
+		listeners = append(listeners, opts)
+	}
+
+	poolCfgs := map[string]config.PoolConfig{config.DefaultPool: defaultPoolCfg}
+	for name, poolCfg := range cfg.Pools {
+		poolCfgs[name] = poolCfg
+	}
+	rl := newReloader(configFile, cfg, overrides, pools, poolCfgs)
+	if configFile != "" {
+		rl.Watch(configWatchInterval)
+		defer rl.Stop()
+	}
+	if len(certStores) > 0 || configFile != "" {
+		reloadOnSIGHUP(certStores, rl)
+	}
+
+	sockets, err := handoff.New()
+	if err != nil {
+		log.Fatalf("Failed to take over inherited sockets: %v", err)
+	}
+
+	numSockets := 0
+	for _, opts := range listeners {
+		numSockets += max(opts.ReusePort, 1)
+	}
+	for _, lc := range tcpListeners {
+		numSockets += max(lc.ReusePort, 1)
+	}
+	for _, lc := range udpListeners {
+		numSockets += max(lc.ReusePort, 1)
+	}
+	if cfg.Admin != nil {
+		numSockets++
+	}
+	errc := make(chan error, numSockets)
+	for _, opts := range listeners {
+		srv := server.NewHTTPServer(mux, opts)
+		fe.servers = append(fe.servers, srv)
+		lns, err := listen(sockets, opts.Addr, opts.ReusePort)
+		if err != nil {
+			log.Fatalf("Failed to listen on %s: %v", opts.Addr, err)
+		}
+		for _, ln := range lns {
+			go func() {
+				errc <- server.Serve(srv, ln, opts)
+			}()
+		}
+		fmt.Printf("Listening on %s (tls=%t, h2c=%t, sniff=%t, sockets=%d)\n", opts.Addr, opts.TLS(), opts.H2C, opts.Sniff != nil, len(lns))
+	}
+	for _, lc := range tcpListeners {
+		proxy := newTCPProxy(lc, pools, cfg.Pools)
+		fe.tcp = append(fe.tcp, proxy)
+		addr := fmt.Sprintf(":%d", lc.Port)
+		lns, err := listen(sockets, addr, lc.ReusePort)
+		if err != nil {
+			log.Fatalf("Failed to listen on %s: %v", addr, err)
+		}
+		trusted := proxyProtocolFrom(lc.ProxyProtocol)
+		for _, ln := range lns {
+			if len(trusted) > 0 {
+				ln = &proxyproto.Listener{Listener: ln, Name: lc.Name, Trusted: trusted}
+			}
+			go func() {
+				errc <- proxy.Serve(ln)
+			}()
+		}
+		fmt.Printf("Proxying TCP on %s to pool %q (%d SNI routes)\n", addr, lc.Pool, len(lc.SNI))
+	}
+	for _, lc := range udpListeners {
+		proxy := &udpproxy.Proxy{
+			Name:           lc.Name,
+			Balancer:       pools[lc.Pool].Balancer,
+			Hash:           lc.Hash,
+			SessionTimeout: time.Duration(lc.IdleTimeout),
+		}
+		fe.udp = append(fe.udp, proxy)
+		addr := fmt.Sprintf(":%d", lc.Port)
+		pcs, err := listenPacket(sockets, addr, lc.ReusePort)
+		if err != nil {
+			log.Fatalf("Failed to listen on %s: %v", addr, err)
+		}
+		for _, pc := range pcs {
+			go func() {
+				errc <- proxy.Serve(pc)
+			}()
+		}
+		fmt.Printf("Proxying UDP on %s to pool %s (hash=%t)\n", addr, lc.Pool, lc.Hash)
+	}
 
-	fmt.Printf("Listening on %s, backends=%v, method=%s\n", addr, cfg.Backends, cfg.Method)
-	log.Fatal(http.ListenAndServe(addr, mux))
+	if cfg.Admin != nil {
+		opts := server.ListenerOptions{Name: "admin", Addr: cfg.Admin.Addr()}
+		tokens, err := auth.LoadTokens(cfg.Admin.TokensFile, adminTokensEnv)
+		if err != nil {
+			log.Fatalf("Failed to load admin API tokens: %v", err)
+		}
+		if tokens == nil {
+			if !cfg.Admin.Loopback() {
+				log.Fatalf("The admin API on %s requires tokens unless it listens on a loopback address", opts.Addr)
+			}
+			log.Printf("[WARN] The admin API has no tokens, so every local user can change the pools")
+		}
+		audit := &auditLog{}
+		if path := cfg.Admin.AuditLog; path != "" {
+			f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+			if err != nil {
+				log.Fatalf("Failed to open the audit log: %v", err)
+			}
+			defer f.Close()
+			audit.w = f
+		}
+		srv := server.NewHTTPServer(newAdminHandler(rl, tokens, audit), opts)
+		fe.servers = append(fe.servers, srv)
+		ln, err := sockets.Listen("tcp", opts.Addr)
+		if err != nil {
+			log.Fatalf("Failed to listen on %s: %v", opts.Addr, err)
+		}
+		go func() {
+			errc <- server.Serve(srv, ln, opts)
+		}()
+		fmt.Printf("Serving the admin API on %s\n", opts.Addr)
+	}
+
+	fmt.Printf("Backends=%v, method=%s\n", cfg.Backends, cfg.Method)
+	if err := sockets.Ready(); err != nil {
+		log.Printf("[WARN] Failed to notify the previous process: %v", err)
+	} else if sockets.Inherited() {
+		log.Printf("[INFO] Took over the listening sockets of the previous process")
+	}
+
+	// A second signal while draining kills the process.
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	// SIGUSR2 hands the sockets to a new process running the current binary.
+	upgrade := make(chan os.Signal, 1)
+	signal.Notify(upgrade, syscall.SIGUSR2)
+	grace := cfg.GracePeriod.Or(defaultGracePeriod)
+	delay := time.Duration(cfg.DrainDelay)
+	for {
+		select {
+		case err := <-errc:
+			log.Fatal(err)
+		case sig := <-stop:
+			signal.Stop(stop)
+			log.Printf("[INFO] Received %s, draining connections for up to %s", sig, grace)
+			fe.shutdown(delay, grace)
+			return
+		case <-upgrade:
+			log.Printf("[INFO] Received SIGUSR2, starting a new process")
+			if err := sockets.Upgrade(); err != nil {
+				log.Printf("[ERROR] Upgrade failed, continuing to serve: %v", err)
+				continue
+			}
+			signal.Stop(stop)
+			log.Printf("[INFO] New process is ready, draining connections for up to %s", grace)
+			fe.shutdown(delay, grace)
+			return
+		}
+	}
 }
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"reflect"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/novaru/golem/config"
+	"github.com/novaru/golem/internal/balancer"
+	"github.com/novaru/golem/internal/metrics"
+)
+
+// configWatchInterval is how often the config file is checked for changes.
+const configWatchInterval = 5 * time.Second
+
+// reloader applies changes of the config file, and of the admin API, to the
+// running pools. Their backends, weights, balancing methods and connection
+// settings are swapped in place; other settings take effect on restart.
+// Without a config file, only the admin API changes the pools.
+type reloader struct {
+	path string
+	// overrides holds the values set on the command line, which win over
+	// the file on every reload.
+	overrides *config.Config
+	pools     map[string]*balancer.Pool
+
+	mu       sync.Mutex
+	cfg      *config.Config
+	poolCfgs map[string]config.PoolConfig
+	modTime  time.Time
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func newReloader(path string, cfg, overrides *config.Config, pools map[string]*balancer.Pool, poolCfgs map[string]config.PoolConfig) *reloader {
+	r := &reloader{
+		path:      path,
+		overrides: overrides,
+		pools:     pools,
+		cfg:       cfg,
+		poolCfgs:  poolCfgs,
+		stop:      make(chan struct{}),
+	}
+	if info, err := os.Stat(path); err == nil {
+		r.modTime = info.ModTime()
+	}
+	return r
+}
+
+// Watch reloads the config whenever the file changes, checking every
+// interval, until Stop is called.
+func (r *reloader) Watch(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.reloadIfChanged()
+			case <-r.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends a Watch.
+func (r *reloader) Stop() {
+	r.stopOnce.Do(func() { close(r.stop) })
+}
+
+func (r *reloader) reloadIfChanged() {
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return
+	}
+	r.mu.Lock()
+	changed := !info.ModTime().Equal(r.modTime)
+	r.modTime = info.ModTime()
+	r.mu.Unlock()
+	if changed {
+		r.Reload()
+	}
+}
+
+// Reload reads the config file and applies it, logging the outcome. An
+// invalid file leaves the running config untouched.
+func (r *reloader) Reload() error {
+	if err := r.reload(); err != nil {
+		metrics.ConfigReloads.WithLabelValues("error").Inc()
+		log.Printf("[ERROR] Failed to reload config from %s, keeping the running config: %v", r.path, err)
+		return err
+	}
+	metrics.ConfigReloads.WithLabelValues("success").Inc()
+	log.Printf("[INFO] Reloaded config from %s", r.path)
+	return nil
+}
+
+func (r *reloader) reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	next, weights, err := config.LoadConfigFromFile(r.path)
+	if err != nil {
+		return err
+	}
+	next.Merge(r.overrides)
+
+	// Only the pools are replaced. The rest of the running config must
+	// accept them, since its routes and listeners stay as they are.
+	candidate := *r.cfg
+	candidate.Backends = next.Backends
+	candidate.Method = next.Method
+	candidate.Transport = next.Transport
+	candidate.BackendProtocol = next.BackendProtocol
+	candidate.BackendTLS = next.BackendTLS
+	candidate.Pools = next.Pools
+	if err := candidate.Validate(); err != nil {
+		return err
+	}
+
+	poolCfgs := map[string]config.PoolConfig{config.DefaultPool: defaultPoolConfig(next, weights)}
+	for name, poolCfg := range next.Pools {
+		poolCfgs[name] = poolCfg
+	}
+	if err := r.checkPools(poolCfgs); err != nil {
+		return err
+	}
+
+	// Build every backend set before touching a pool, so that a failure
+	// leaves all of them as they were.
+	backends := make(map[string][]*balancer.Backend, len(poolCfgs))
+	for name, poolCfg := range poolCfgs {
+		var keep func(string) *balancer.Backend
+		if sameConnections(r.poolCfgs[name], poolCfg) {
+			keep = r.pools[name].Backend
+		}
+		if backends[name], err = newBackends(name, poolCfg, keep); err != nil {
+			return fmt.Errorf("pool %s: %w", name, err)
+		}
+	}
+
+	var removed []*balancer.Backend
+	for name, poolCfg := range poolCfgs {
+		gone, err := r.updatePool(name, poolCfg, backends[name])
+		if err != nil {
+			return err
+		}
+		removed = append(removed, gone...)
+	}
+	if len(removed) > 0 {
+		go r.drain(removed, candidate.GracePeriod.Or(defaultGracePeriod))
+	}
+
+	if !reflect.DeepEqual(*next, candidate) {
+		log.Printf("[WARN] Config changes outside of pools and backends take effect after a restart")
+	}
+	r.cfg = &candidate
+	r.poolCfgs = poolCfgs
+	return nil
+}
+
+// changePool applies change to the config of the named pool and swaps in
+// the result, keeping the backends whose URLs remain. The changed config is
+// validated like the config file; on error the pool is left as it was.
+func (r *reloader) changePool(name string, change func(*config.PoolConfig) error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	poolCfg, ok := r.poolCfgs[name]
+	if !ok {
+		return fmt.Errorf("pool %s: %w", name, errNotFound)
+	}
+	poolCfg.Backends = slices.Clone(poolCfg.Backends)
+	if err := change(&poolCfg); err != nil {
+		return err
+	}
+
+	candidate := *r.cfg
+	if name == config.DefaultPool {
+		candidate.Backends = nil
+		for _, b := range poolCfg.Backends {
+			candidate.Backends = append(candidate.Backends, b.URL)
+		}
+	} else {
+		candidate.Pools = maps.Clone(r.cfg.Pools)
+		candidate.Pools[name] = poolCfg
+	}
+	if err := candidate.Validate(); err != nil {
+		return err
+	}
+
+	backends, err := newBackends(name, poolCfg, r.pools[name].Backend)
+	if err != nil {
+		return fmt.Errorf("pool %s: %w", name, err)
+	}
+	removed, err := r.updatePool(name, poolCfg, backends)
+	if err != nil {
+		return err
+	}
+	if len(removed) > 0 {
+		go r.drain(removed, candidate.GracePeriod.Or(defaultGracePeriod))
+	}
+
+	r.cfg = &candidate
+	r.poolCfgs = maps.Clone(r.poolCfgs)
+	r.poolCfgs[name] = poolCfg
+	return nil
+}
+
+// poolConfig returns the running config of the named pool.
+func (r *reloader) poolConfig(name string) (config.PoolConfig, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	poolCfg, ok := r.poolCfgs[name]
+	return poolCfg, ok
+}
+
+// updatePool swaps the backends of a pool for backends, built from poolCfg,
+// and returns the ones it no longer uses.
+func (r *reloader) updatePool(name string, poolCfg config.PoolConfig, backends []*balancer.Backend) ([]*balancer.Backend, error) {
+	// Weights are set first, since balancers read them when built.
+	for i, b := range backends {
+		b.SetWeight(backendWeight(poolCfg.Backends[i]))
+	}
+	removed, err := r.pools[name].Update(poolCfg.Method, backends)
+	if err != nil {
+		return nil, fmt.Errorf("pool %s: %w", name, err)
+	}
+	for _, b := range removed {
+		log.Printf("[INFO] Removed backend %s from pool %s, draining", b.URL, name)
+	}
+	return removed, nil
+}
+
+// checkPools rejects reloads that would need routes or listeners rebuilt.
+func (r *reloader) checkPools(poolCfgs map[string]config.PoolConfig) error {
+	for name := range poolCfgs {
+		if _, ok := r.pools[name]; !ok {
+			return fmt.Errorf("adding pool %s requires a restart", name)
+		}
+	}
+	for name := range r.pools {
+		if _, ok := poolCfgs[name]; !ok {
+			return fmt.Errorf("removing pool %s requires a restart", name)
+		}
+	}
+	for name, poolCfg := range poolCfgs {
+		if poolCfg.ProxyProtocol != r.poolCfgs[name].ProxyProtocol {
+			return fmt.Errorf("changing proxy_protocol of pool %s requires a restart", name)
+		}
+	}
+	return nil
+}
+
+// sameConnections reports whether two configs of a pool connect to its
+// backends the same way, so that backends with unchanged URLs can be kept.
+func sameConnections(a, b config.PoolConfig) bool {
+	a.Method, a.Backends = "", nil
+	b.Method, b.Backends = "", nil
+	return reflect.DeepEqual(a, b)
+}
+
+// drain waits up to timeout for the requests in flight on removed backends
+// to finish, then closes their idle connections. The metrics of backends no
+// pool uses any more are dropped.
+func (r *reloader) drain(removed []*balancer.Backend, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for _, b := range removed {
+		for b.GetConnections()+b.GetStreams() > 0 && time.Now().Before(deadline) {
+			time.Sleep(drainPollInterval)
+		}
+		if n := b.GetConnections() + b.GetStreams(); n > 0 {
+			log.Printf("[WARN] Removed backend %s still has %d connections after %s", b.URL, n, timeout)
+		} else {
+			log.Printf("[INFO] Drained removed backend %s", b.URL)
+		}
+		if t, ok := b.Transport.(interface{ CloseIdleConnections() }); ok {
+			t.CloseIdleConnections()
+		}
+		if !r.inUse(b.URL) {
+			metrics.DeleteBackend(b.URL)
+		}
+	}
+}
+
+// inUse reports whether any pool has a backend at url.
+func (r *reloader) inUse(url string) bool {
+	for _, pool := range r.pools {
+		if pool.Backend(url) != nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/novaru/golem/config"
+	"github.com/novaru/golem/internal/balancer"
+)
+
+// startReloader loads the config at path and builds its pools like main.
+func startReloader(t *testing.T, path string) (*reloader, map[string]*balancer.Pool) {
+	t.Helper()
+	cfg, weights, err := config.LoadConfigFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	poolCfgs := map[string]config.PoolConfig{config.DefaultPool: defaultPoolConfig(cfg, weights)}
+	for name, poolCfg := range cfg.Pools {
+		poolCfgs[name] = poolCfg
+	}
+	pools := make(map[string]*balancer.Pool)
+	for name, poolCfg := range poolCfgs {
+		if pools[name], err = newPool(name, poolCfg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return newReloader(path, cfg, &config.Config{}, pools, poolCfgs), pools
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloadKeepsUnchangedBackends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "golem.json")
+	writeFile(t, path, `{"port": 8080, "method": "roundrobin",
+		"backends": [{"url": "http://a"}, {"url": "http://b"}]}`)
+	rl, pools := startReloader(t, path)
+	pool := pools[config.DefaultPool]
+	a := pool.Backend("http://a")
+	a.AddConnections()
+
+	writeFile(t, path, `{"port": 8080, "method": "leastconn",
+		"backends": [{"url": "http://a", "weight": 5}, {"url": "http://c"}]}`)
+	if err := rl.reload(); err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	if pool.Backend("http://a") != a || a.GetConnections() != 1 {
+		t.Error("expected backend a to be kept with its connections")
+	}
+	if a.Weight() != 5 {
+		t.Errorf("expected weight 5, got %d", a.Weight())
+	}
+	if pool.Backend("http://b") != nil || pool.Backend("http://c") == nil {
+		t.Errorf("expected backends a and c, got %d backends", len(pool.Backends()))
+	}
+	if next, _ := pool.Balancer.NextBackend(); next.URL != "http://c" {
+		t.Errorf("expected least connections to pick c, got %s", next.URL)
+	}
+}
+
+func TestReloadRejectsInvalidConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "golem.json")
+	writeFile(t, path, `{"port": 8080, "method": "roundrobin", "backends": [{"url": "http://a"}]}`)
+	rl, pools := startReloader(t, path)
+
+	tests := []struct {
+		name   string
+		config string
+	}{
+		{"unknown method", `{"port": 8080, "method": "bogus", "backends": [{"url": "http://b"}]}`},
+		{"malformed", `{"port": 8080,`},
+		{"added pool", `{"port": 8080, "method": "roundrobin", "backends": [{"url": "http://b"}],
+			"pools": {"extra": {"method": "roundrobin", "backends": [{"url": "http://c"}]}}}`},
+	}
+	for _, tc := range tests {
+		writeFile(t, path, tc.config)
+		if err := rl.reload(); err == nil {
+			t.Errorf("%s: expected an error", tc.name)
+		}
+		if pools[config.DefaultPool].Backend("http://a") == nil {
+			t.Errorf("%s: expected the running backends to be kept", tc.name)
+		}
+	}
+}
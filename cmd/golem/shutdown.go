@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/novaru/golem/internal/server"
+	"github.com/novaru/golem/internal/tcpproxy"
+	"github.com/novaru/golem/internal/udpproxy"
+)
+
+// defaultGracePeriod bounds draining when the config sets no grace period.
+const defaultGracePeriod = 30 * time.Second
+
+const (
+	// drainPollInterval is how often in-flight work is checked while
+	// draining.
+	drainPollInterval = 50 * time.Millisecond
+	// drainLogInterval is how often drain progress is logged.
+	drainLogInterval = time.Second
+)
+
+// frontends are the servers accepting client traffic, drained on shutdown.
+type frontends struct {
+	ready   *server.Readiness
+	proxy   *server.ProxyServer
+	servers []*http.Server
+	tcp     []*tcpproxy.Proxy
+	udp     []*udpproxy.Proxy
+}
+
+// inFlight returns the number of proxied HTTP requests and TCP connections
+// still open.
+func (f *frontends) inFlight() (requests, conns int64) {
+	for _, p := range f.tcp {
+		conns += p.Active()
+	}
+	return f.proxy.InFlight(), conns
+}
+
+// shutdown fails readiness checks, keeps accepting connections for delay so
+// that load balancers see the failure, then stops accepting and waits up to
+// grace for in-flight requests, including streams and upgraded connections,
+// and TCP connections to finish. HTTP connections still open afterwards are
+// closed; the rest end with the process. UDP sockets are closed last so that
+// replies to drained requests still reach clients.
+func (f *frontends) shutdown(delay, grace time.Duration) {
+	f.ready.SetDraining()
+	if delay > 0 {
+		log.Printf("[INFO] Failing readiness for %s before draining", delay)
+		time.Sleep(delay)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, srv := range f.servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			srv.Shutdown(ctx)
+		}()
+	}
+	for _, p := range f.tcp {
+		p.Close()
+	}
+	serversDone := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(serversDone)
+	}()
+
+	if f.drain(ctx, serversDone) {
+		log.Printf("[INFO] Drained all connections")
+	} else {
+		requests, conns := f.inFlight()
+		log.Printf("[WARN] Grace period of %s expired with %d requests and %d TCP connections in flight, closing them", grace, requests, conns)
+		for _, srv := range f.servers {
+			srv.Close()
+		}
+	}
+	for _, p := range f.udp {
+		p.Close()
+	}
+}
+
+// drain waits until the HTTP servers have shut down and nothing is in
+// flight, logging progress. It reports false if ctx ends first.
+func (f *frontends) drain(ctx context.Context, serversDone <-chan struct{}) bool {
+	poll := time.NewTicker(drainPollInterval)
+	defer poll.Stop()
+	lastLog := time.Now()
+	for {
+		requests, conns := f.inFlight()
+		select {
+		case <-serversDone:
+			if requests == 0 && conns == 0 {
+				return true
+			}
+		default:
+		}
+		if time.Since(lastLog) >= drainLogInterval {
+			log.Printf("[INFO] Draining: %d requests and %d TCP connections in flight", requests, conns)
+			lastLog = time.Now()
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-poll.C:
+		}
+	}
+}
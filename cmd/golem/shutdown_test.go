@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/novaru/golem/internal/balancer"
+	"github.com/novaru/golem/internal/server"
+)
+
+func TestShutdownFailsReadinessBeforeDraining(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+	bal, _ := balancer.NewBalancer("roundrobin", []*balancer.Backend{balancer.NewBackend(backend.URL, 1)})
+
+	fe := &frontends{ready: &server.Readiness{}, proxy: server.NewProxyServer(bal)}
+	mux := http.NewServeMux()
+	mux.Handle("/", fe.proxy)
+	mux.Handle("/ready", fe.ready)
+	srv := &http.Server{Handler: mux}
+	fe.servers = []*http.Server{srv}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(ln)
+	url := "http://" + ln.Addr().String()
+	// Each check opens a connection, as a load balancer's would.
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	get := func(path string) int {
+		resp, err := client.Get(url + path)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	done := make(chan struct{})
+	go func() {
+		fe.shutdown(500*time.Millisecond, time.Second)
+		close(done)
+	}()
+	for !fe.ready.Draining() {
+		time.Sleep(time.Millisecond)
+	}
+
+	if code := get("/ready"); code != http.StatusServiceUnavailable {
+		t.Errorf("expected readiness to fail during the drain delay, got %d", code)
+	}
+	if code := get("/"); code != http.StatusOK {
+		t.Errorf("expected requests to be accepted during the drain delay, got %d", code)
+	}
+
+	<-done
+	if _, err := client.Get(url + "/"); err == nil {
+		t.Error("expected the listener to be closed after shutdown")
+	}
+}
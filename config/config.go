@@ -1,10 +1,19 @@
 package config
 
 import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
 	"errors"
 	"flag"
 	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"strconv"
 	"strings"
+
+	"github.com/novaru/golem/internal/balancer"
 )
 
 // Supported methods for load balancing
@@ -47,11 +56,419 @@ type Config struct {
 	Port     int
 	Backends StringSlice
 	Method   string
+
+	// Pools holds additional named backend pools, keyed by name. The
+	// top-level Backends and Method form the implicit "default" pool.
+	Pools map[string]PoolConfig
+
+	// Mirror enables traffic shadowing to one of the named pools.
+	Mirror *MirrorConfig
+
+	// Routes are matched in order against each request; the first match
+	// decides the pool and the per-route rules. Requests matching no route
+	// go to the default pool unchanged.
+	Routes []RouteConfig
+
+	// Forwarded controls the X-Forwarded-* and Forwarded request headers.
+	Forwarded *ForwardedConfig
+
+	// HostHeader is the Host header policy for proxied requests: "backend"
+	// (default) sends the backend's host, "preserve" keeps the client's.
+	HostHeader string
+
+	// Upgrade bounds the lifetime of upgraded connections such as WebSockets.
+	Upgrade *UpgradeConfig
+
+	// Timeout bounds each proxied exchange on non-streaming routes.
+	// Defaults to 10s.
+	Timeout Duration
+	// FlushInterval is the default response flush interval. Negative values
+	// flush after every write. Streaming content types always flush at once.
+	FlushInterval Duration
+
+	// Transport tunes the connections to the default pool's backends.
+	Transport *TransportConfig
+	// BackendProtocol is the HTTP version spoken to the default pool's
+	// backends; see PoolConfig.Protocol.
+	BackendProtocol string
+	// BackendTLS configures TLS to the default pool's https backends.
+	BackendTLS *BackendTLSConfig
+	// ClientCertHeaders forwards verified client certificate identities.
+	ClientCertHeaders *ClientCertHeadersConfig
+
+	// H2C accepts cleartext HTTP/2 with prior knowledge on the main port.
+	H2C bool
+	// ProxyProtocol accepts PROXY protocol headers on the main port.
+	ProxyProtocol *ProxyProtocolConfig
+	// ReusePort opens this many listeners on the main port with
+	// SO_REUSEPORT, each accepting on its own. 0 or 1 opens a single one.
+	ReusePort int
+	// Listeners are additional frontend ports serving the same routes.
+	Listeners []ListenerConfig
+
+	// GracePeriod bounds how long shutdown waits for in-flight requests and
+	// connections to finish. Defaults to 30s.
+	GracePeriod Duration
+	// DrainDelay is how long shutdown fails readiness checks while still
+	// accepting connections, so that load balancers polling readiness stop
+	// sending traffic before the listeners close. Set it to at least their
+	// check interval. Defaults to no delay.
+	DrainDelay Duration
+
+	// Admin serves the admin API on its own port.
+	Admin *AdminConfig
+}
+
+// DefaultAdminAddress is the address the admin API binds to by default, so
+// that it is only reachable from the host itself.
+const DefaultAdminAddress = "127.0.0.1"
+
+// AdminConfig enables the admin API, which lists the running pools and
+// changes their backends.
+type AdminConfig struct {
+	Port int `json:"port"`
+	// Address is the IP address to listen on. Default 127.0.0.1.
+	Address string `json:"address,omitempty"`
+	// TokensFile lists the bearer tokens accepted by the admin API, one
+	// name:role:token entry per line, where role is read-only, operator or
+	// admin. Entries may also be passed in the GOLEM_ADMIN_TOKENS
+	// environment variable, separated by commas. Without any token the API
+	// is open to everyone who can reach it, so it must listen on a loopback
+	// address.
+	TokensFile string `json:"tokens_file,omitempty"`
+	// AuditLog is the file every mutating call is appended to, as a JSON
+	// line. Defaults to the process log.
+	AuditLog string `json:"audit_log,omitempty"`
+}
+
+// Backend protocols.
+const (
+	ProtocolHTTP1 = "http1"
+	ProtocolH2    = "h2"
+	ProtocolH2C   = "h2c"
+)
+
+// Listener modes.
+const (
+	ListenerModeHTTP = "http"
+	ListenerModeTCP  = "tcp"
+	ListenerModeUDP  = "udp"
+	// ListenerModeSniff tells HTTP, TLS and other TCP connections apart by
+	// their first bytes.
+	ListenerModeSniff = "sniff"
+)
+
+// ListenerConfig describes an additional frontend listener.
+type ListenerConfig struct {
+	Name string `json:"name"`
+	Port int    `json:"port"`
+	// Mode is "http" (the default), proxying HTTP through the routes,
+	// "tcp", balancing raw connections across Pool, or "udp", balancing
+	// datagrams across Pool.
+	Mode string `json:"mode,omitempty"`
+	// Pool names the pool of tcp:// or udp:// backends used in tcp and udp
+	// mode.
+	Pool string `json:"pool,omitempty"`
+	// IdleTimeout closes tcp mode connections, and sniff mode connections
+	// handed to a tcp pool, when no bytes flowed in either direction for
+	// this long. Zero disables it. In udp mode it ends a client session,
+	// default 30 seconds.
+	IdleTimeout Duration `json:"idle_timeout,omitempty"`
+	// Hash pins udp mode clients to a backend by hashing their IP address,
+	// instead of using the pool's method for each new session.
+	Hash bool `json:"hash,omitempty"`
+	// SNI passes TLS through in tcp mode, choosing the pool by the server
+	// name in the client's ClientHello. Connections matching no route go to
+	// Pool, or are rejected when Pool is empty.
+	SNI []SNIRouteConfig `json:"sni,omitempty"`
+	// TLS terminates TLS on the listener and enables HTTP/2 through ALPN.
+	TLS *ListenerTLSConfig `json:"tls,omitempty"`
+	// H2C accepts cleartext HTTP/2 with prior knowledge. Only valid without TLS.
+	H2C bool `json:"h2c,omitempty"`
+	// ProxyProtocol accepts PROXY protocol headers in http, tcp and sniff
+	// mode.
+	ProxyProtocol *ProxyProtocolConfig `json:"proxy_protocol,omitempty"`
+	// Sniff dispatches the connections of a sniff mode listener.
+	Sniff *SniffConfig `json:"sniff,omitempty"`
+	// ReusePort opens this many sockets on the port with SO_REUSEPORT, so
+	// the kernel spreads clients across their accept loops. 0 or 1 opens a
+	// single one.
+	ReusePort int `json:"reuse_port,omitempty"`
+}
+
+// SniffConfig dispatches the connections of a sniff mode listener by the
+// protocol detected from their first bytes. Plaintext HTTP is served through
+// the routes. TLS is terminated and served through the routes too when the
+// listener has TLS settings.
+type SniffConfig struct {
+	// TLSPool receives TLS connections without terminating them. Without
+	// it and without listener TLS settings, TLS connections are closed.
+	TLSPool string `json:"tls_pool,omitempty"`
+	// TCPPool receives connections that are neither HTTP nor TLS. Without
+	// it they are closed.
+	TCPPool string `json:"tcp_pool,omitempty"`
+	// Timeout bounds the wait for a client's first bytes, after which the
+	// connection is taken as TCP. Default 1 second.
+	Timeout Duration `json:"timeout,omitempty"`
+}
+
+// ProxyProtocolConfig accepts PROXY protocol v1 and v2 headers from L4 load
+// balancers in front of a listener. The client address in the header is then
+// used for logging, forwarding headers and backends receiving PROXY headers
+// themselves.
+type ProxyProtocolConfig struct {
+	// TrustedProxies lists the CIDRs (or single IPs) allowed to send
+	// headers. Their connections must start with one; connections from
+	// other peers are taken as they are.
+	TrustedProxies []string `json:"trusted_proxies"`
+}
+
+// PROXY protocol versions sent to backends.
+const (
+	ProxyProtocolV1 = "v1"
+	ProxyProtocolV2 = "v2"
+)
+
+// SNIRouteConfig sends TLS connections for a server name to a pool of tcp://
+// backends.
+type SNIRouteConfig struct {
+	// ServerName is a host name, or a wildcard such as "*.example.com"
+	// matching exactly one label.
+	ServerName string `json:"server_name"`
+	Pool       string `json:"pool"`
+}
+
+// ListenerTLSConfig holds the certificates and TLS policy of a listener.
+type ListenerTLSConfig struct {
+	// Certificates are selected by the server name the client requests
+	// through SNI, including wildcard names. The first certificate is served
+	// when none matches.
+	Certificates []CertificateConfig `json:"certificates"`
+	// MinVersion is the lowest accepted TLS version: "1.0", "1.1", "1.2" or
+	// "1.3". Default "1.2".
+	MinVersion string `json:"min_version,omitempty"`
+	// CipherSuites restricts the TLS 1.2 cipher suites by IANA name. Empty
+	// uses Go's secure defaults.
+	CipherSuites []string `json:"cipher_suites,omitempty"`
+	// ClientAuth requires clients to present a certificate.
+	ClientAuth *ClientAuthConfig `json:"client_auth,omitempty"`
+}
+
+// ClientAuthConfig configures client certificate authentication on a TLS
+// listener. Certificates that are not signed by the CA or are rejected by
+// the allow and deny rules fail the handshake.
+type ClientAuthConfig struct {
+	// CAFile is a PEM bundle of the CAs allowed to sign client certificates.
+	CAFile string `json:"ca_file"`
+	// Optional lets clients without a certificate connect. Routes with
+	// client_cert still reject them.
+	Optional bool `json:"optional,omitempty"`
+	CertPolicyConfig
+}
+
+// CertPolicyConfig decides which client certificates are accepted. Deny
+// rules win; with no allow rules every certificate not denied is accepted.
+type CertPolicyConfig struct {
+	Allow []CertMatcherConfig `json:"allow,omitempty"`
+	Deny  []CertMatcherConfig `json:"deny,omitempty"`
+}
+
+// CertMatcherConfig matches client certificates. Every field set must match.
+type CertMatcherConfig struct {
+	// Subject is the full distinguished name, e.g. "CN=api,O=Example", or
+	// the common name alone.
+	Subject string `json:"subject,omitempty"`
+	// SAN is a DNS name, email address, URI or IP address; "*.example.com"
+	// matches one DNS label.
+	SAN string `json:"san,omitempty"`
+	// Fingerprint is the hex SHA-256 digest of the certificate.
+	Fingerprint string `json:"fingerprint,omitempty"`
+}
+
+// ClientCertHeadersConfig names the headers forwarding a verified client
+// certificate's identity to backends. Empty names are not sent.
+type ClientCertHeadersConfig struct {
+	Subject     string `json:"subject,omitempty"`
+	SAN         string `json:"san,omitempty"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+}
+
+// CertificateConfig names a PEM certificate chain and its private key.
+type CertificateConfig struct {
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+}
+
+// UpgradeConfig holds the timeouts for upgraded (e.g. WebSocket) connections.
+// Zero values disable the corresponding limit.
+type UpgradeConfig struct {
+	IdleTimeout Duration `json:"idle_timeout,omitempty"`
+	MaxDuration Duration `json:"max_duration,omitempty"`
+}
+
+// Host header policies.
+const (
+	HostHeaderBackend  = "backend"
+	HostHeaderPreserve = "preserve"
+)
+
+// Forwarding header modes.
+const (
+	ForwardedModeXForwarded = "x-forwarded"
+	ForwardedModeRFC7239    = "forwarded"
+	ForwardedModeBoth       = "both"
+)
+
+// ForwardedConfig describes how client information is passed to backends.
+type ForwardedConfig struct {
+	// TrustedProxies lists the CIDRs (or single IPs) of proxies in front of
+	// golem. Forwarding headers sent by these peers are appended to; headers
+	// sent by anyone else are replaced.
+	TrustedProxies []string `json:"trusted_proxies,omitempty"`
+	// Mode selects the headers to emit: "x-forwarded" (default), "forwarded"
+	// for RFC 7239, or "both".
+	Mode string `json:"mode,omitempty"`
+}
+
+// DefaultPool is the name of the pool built from the top-level backends.
+const DefaultPool = "default"
+
+// PoolConfig describes a named group of backends and how to balance them.
+type PoolConfig struct {
+	Method    string           `json:"method"`
+	Backends  []BackendConfig  `json:"backends"`
+	Transport *TransportConfig `json:"transport,omitempty"`
+	// Protocol is the HTTP version spoken to the backends: "http1", "h2"
+	// (HTTP/2 over TLS, https backends only) or "h2c" (cleartext HTTP/2 with
+	// prior knowledge, http backends only). Empty speaks HTTP/1.1 to http
+	// backends and negotiates with https backends.
+	Protocol string `json:"protocol,omitempty"`
+	// TLS configures connections to https backends, including their
+	// health checks.
+	TLS *BackendTLSConfig `json:"tls,omitempty"`
+	// ProxyProtocol sends a PROXY protocol header, "v1" or "v2", on every
+	// backend connection. HTTP backend connections then serve a single
+	// request, since each belongs to one client.
+	ProxyProtocol string `json:"proxy_protocol,omitempty"`
+}
+
+// BackendTLSConfig configures TLS connections to backends. Without it the
+// system trust store is used and no client certificate is sent.
+type BackendTLSConfig struct {
+	// CAFile is a PEM bundle of the CAs trusted to sign backend
+	// certificates. It replaces the system trust store.
+	CAFile string `json:"ca_file,omitempty"`
+	// CertFile and KeyFile are the client certificate presented to backends
+	// requiring mutual TLS.
+	CertFile string `json:"cert_file,omitempty"`
+	KeyFile  string `json:"key_file,omitempty"`
+	// ServerName overrides the name sent through SNI and verified against
+	// the backend certificate, which defaults to the host of the backend URL.
+	ServerName string `json:"server_name,omitempty"`
+	// InsecureSkipVerify accepts any backend certificate. For development
+	// only.
+	InsecureSkipVerify bool `json:"insecure_skip_verify,omitempty"`
+}
+
+// TransportConfig tunes the connection pool and timeouts used for each
+// backend of a pool. Every backend gets its own pool of connections. Unset
+// fields keep their defaults.
+type TransportConfig struct {
+	MaxIdleConns          int      `json:"max_idle_conns,omitempty"`
+	MaxConnsPerHost       int      `json:"max_conns_per_host,omitempty"`
+	IdleConnTimeout       Duration `json:"idle_conn_timeout,omitempty"`
+	KeepAlive             Duration `json:"keep_alive,omitempty"`
+	DialTimeout           Duration `json:"dial_timeout,omitempty"`
+	TLSHandshakeTimeout   Duration `json:"tls_handshake_timeout,omitempty"`
+	ResponseHeaderTimeout Duration `json:"response_header_timeout,omitempty"`
+	ExpectContinueTimeout Duration `json:"expect_continue_timeout,omitempty"`
+}
+
+// RouteConfig describes a set of requests, selected by host and path prefix,
+// and how they are handled.
+type RouteConfig struct {
+	Name string `json:"name"`
+	// Host matches the request host, ignoring any port. Empty matches all hosts.
+	Host string `json:"host,omitempty"`
+	// PathPrefix matches the start of the request path. Empty matches all paths.
+	PathPrefix string `json:"path_prefix,omitempty"`
+	// Pool names the pool serving this route. Empty means the default pool.
+	Pool    string             `json:"pool,omitempty"`
+	Headers *HeaderRulesConfig `json:"headers,omitempty"`
+	// HostHeader overrides the top-level Host header policy for this route.
+	HostHeader string `json:"host_header,omitempty"`
+	// Streaming marks routes serving long-lived responses, which are exempt
+	// from the request timeout.
+	Streaming bool `json:"streaming,omitempty"`
+	// Timeout overrides the top-level request timeout.
+	Timeout Duration `json:"timeout,omitempty"`
+	// FlushInterval overrides the top-level flush interval.
+	FlushInterval Duration `json:"flush_interval,omitempty"`
+	// SSE configures Server-Sent Events handling on this route.
+	SSE *SSEConfig `json:"sse,omitempty"`
+	// GRPC enables gRPC-aware proxying. The route's pool must speak HTTP/2
+	// to its backends.
+	GRPC bool `json:"grpc,omitempty"`
+	// GRPCMethods lists the services, as "package.Service", and methods, as
+	// "package.Service/Method", measured under their own names. Other calls
+	// are measured as "other".
+	GRPCMethods []string `json:"grpc_methods,omitempty"`
+	// ClientCert requires a verified client certificate accepted by the
+	// policy, answering other requests with 403 Forbidden.
+	ClientCert *CertPolicyConfig `json:"client_cert,omitempty"`
+}
+
+// SSEConfig holds Server-Sent Events settings for a route.
+type SSEConfig struct {
+	// HeartbeatInterval sends a comment line on streams idle this long.
+	HeartbeatInterval Duration `json:"heartbeat_interval,omitempty"`
+	// Sticky sends clients reconnecting with Last-Event-ID back to the
+	// backend that served their previous stream.
+	Sticky bool `json:"sticky,omitempty"`
+}
+
+// HeaderRulesConfig holds the header rewrites applied on a route.
+type HeaderRulesConfig struct {
+	Request  HeaderOpsConfig `json:"request"`
+	Response HeaderOpsConfig `json:"response"`
+}
+
+// HeaderOpsConfig lists header operations. Remove runs first, then Set, then
+// Add. Values may reference variables written as ${name}; see HeaderVariables.
+type HeaderOpsConfig struct {
+	Set    map[string]string `json:"set,omitempty"`
+	Add    map[string]string `json:"add,omitempty"`
+	Remove []string          `json:"remove,omitempty"`
+}
+
+// HeaderVariables are the variables that may be used in header rule values.
+var HeaderVariables = map[string]bool{
+	"client_ip":  true,
+	"backend":    true,
+	"request_id": true,
+	"route":      true,
+	"host":       true,
+	"method":     true,
+	"path":       true,
+}
+
+// MirrorConfig describes how live traffic is copied to a shadow pool.
+type MirrorConfig struct {
+	// Pool is the name of the pool receiving shadow requests.
+	Pool string `json:"pool"`
+	// Percent is the share of requests (0-100) copied to the shadow pool.
+	Percent float64 `json:"percent"`
+	// Timeout bounds each shadow request. Defaults to 10s.
+	Timeout Duration `json:"timeout,omitempty"`
+	// MaxBodyBytes is the largest request body that is buffered for
+	// mirroring. Requests with larger bodies are not mirrored. Defaults to 1MiB.
+	MaxBodyBytes int64 `json:"max_body_bytes,omitempty"`
 }
 
 // ParseFlags parses command-line flags and returns a Config struct.
 // It uses the flag package to define and parse the flags.
-// The flags include port, backend URLs, and load balancing method.
+// The flags include port, backend URLs, load balancing method and shutdown
+// grace period.
 func ParseFlags() (*Config, error) {
 	var cfg Config
 	flag.IntVar(&cfg.Port, "port", 8080, "Port to listen on")
@@ -69,6 +486,9 @@ func ParseFlags() (*Config, error) {
 			"  leastconn\t– Routes to backend with fewest active connections\n"+
 			"  weighted\t– Weighted response time (favors faster backends based on response time)\n",
 	)
+	flag.Var(&cfg.GracePeriod, "grace-period", "Time to wait for in-flight requests on shutdown (default 30s)")
+	flag.Var(&cfg.DrainDelay, "drain-delay", "Time to fail readiness while still accepting connections on shutdown")
+	flag.IntVar(&cfg.ReusePort, "reuse-port", 0, "Number of SO_REUSEPORT listeners to open on the port (0 for one plain listener)")
 	flag.Parse()
 	return &cfg, cfg.Validate()
 }
@@ -86,9 +506,596 @@ func (c *Config) Validate() error {
 	if c.Port < 1 || c.Port > 65535 {
 		return fmt.Errorf("invalid port: %d", c.Port)
 	}
+	// The default pool serves the main HTTP port.
+	for _, u := range c.Backends {
+		if backendScheme(u) != "" {
+			return fmt.Errorf("invalid backend url %q: the default pool takes http:// or https:// backends", u)
+		}
+		if err := validateBackendURL(u); err != nil {
+			return err
+		}
+	}
+	for name, pool := range c.Pools {
+		if err := pool.validate(name); err != nil {
+			return err
+		}
+	}
+	if c.Mirror != nil {
+		if err := c.Mirror.validate(c.Pools); err != nil {
+			return err
+		}
+	}
+	if err := validateHostHeader(c.HostHeader); err != nil {
+		return err
+	}
+	if c.Timeout < 0 {
+		return errors.New("timeout must not be negative")
+	}
+	if c.GracePeriod < 0 {
+		return errors.New("grace_period must not be negative")
+	}
+	if c.DrainDelay < 0 {
+		return errors.New("drain_delay must not be negative")
+	}
+	if c.Transport != nil {
+		if err := c.Transport.validate(); err != nil {
+			return err
+		}
+	}
+	if err := validateProtocol(c.BackendProtocol, c.Backends); err != nil {
+		return err
+	}
+	if c.BackendTLS != nil {
+		if err := c.BackendTLS.validate(c.Backends); err != nil {
+			return fmt.Errorf("backend_tls: %w", err)
+		}
+	}
+	// UDP listeners may share a port number with a TCP one, e.g. for DNS.
+	type port struct {
+		udp bool
+		num int
+	}
+	ports := map[port]bool{{num: c.Port}: true}
+	for _, l := range c.Listeners {
+		if err := l.validate(c); err != nil {
+			return fmt.Errorf("listener %s: %w", l.Name, err)
+		}
+		p := port{udp: l.Mode == ListenerModeUDP, num: l.Port}
+		if ports[p] {
+			return fmt.Errorf("listener %s: port %d is already in use", l.Name, l.Port)
+		}
+		ports[p] = true
+	}
+	if c.Admin != nil {
+		if err := c.Admin.validate(); err != nil {
+			return err
+		}
+		if ports[port{num: c.Admin.Port}] {
+			return fmt.Errorf("admin: port %d is already in use", c.Admin.Port)
+		}
+	}
+	if c.Upgrade != nil && (c.Upgrade.IdleTimeout < 0 || c.Upgrade.MaxDuration < 0) {
+		return errors.New("upgrade: timeouts must not be negative")
+	}
+	if c.Forwarded != nil {
+		if err := c.Forwarded.validate(); err != nil {
+			return err
+		}
+	}
+	if c.ProxyProtocol != nil {
+		if err := c.ProxyProtocol.validate(); err != nil {
+			return err
+		}
+	}
+	if c.ReusePort < 0 {
+		return errors.New("reuse_port must not be negative")
+	}
+	seen := make(map[string]bool)
+	for _, route := range c.Routes {
+		if route.Name == "" {
+			return errors.New("route name must not be empty")
+		}
+		if seen[route.Name] {
+			return fmt.Errorf("duplicate route name: %s", route.Name)
+		}
+		seen[route.Name] = true
+		if err := route.validate(c); err != nil {
+			return fmt.Errorf("route %s: %w", route.Name, err)
+		}
+	}
+	return nil
+}
+
+// HasPool reports whether name refers to the default pool or a named pool.
+func (c *Config) HasPool(name string) bool {
+	if name == "" || name == DefaultPool {
+		return true
+	}
+	_, ok := c.Pools[name]
+	return ok
+}
+
+// scheme returns balancer.TCPScheme or balancer.UDPScheme for pools
+// balancing raw connections or datagrams, and "" for pools serving HTTP.
+func (p *PoolConfig) scheme() string {
+	if len(p.Backends) == 0 {
+		return ""
+	}
+	return backendScheme(p.Backends[0].URL)
+}
+
+func backendScheme(url string) string {
+	for _, scheme := range []string{balancer.TCPScheme, balancer.UDPScheme} {
+		if strings.HasPrefix(url, scheme) {
+			return scheme
+		}
+	}
+	return ""
+}
+
+// validateBackendURL checks that u is an http:// or https:// URL with a host,
+// or a tcp:// or udp:// host:port address.
+func validateBackendURL(u string) error {
+	if scheme := backendScheme(u); scheme != "" {
+		host, port, err := net.SplitHostPort(strings.TrimPrefix(u, scheme))
+		if err != nil || host == "" || port == "" {
+			return fmt.Errorf("invalid backend url %q: expected %shost:port", u, scheme)
+		}
+		return nil
+	}
+	parsed, err := url.Parse(u)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("invalid backend url %q: expected an http:// or https:// url", u)
+	}
+	return nil
+}
+
+func (p *PoolConfig) validate(name string) error {
+	if name == "" || name == DefaultPool {
+		return fmt.Errorf("invalid pool name: %q", name)
+	}
+	if len(p.Backends) == 0 {
+		return fmt.Errorf("pool %s: at least one backend must be specified", name)
+	}
+	if !SupportedMethods[p.Method] {
+		return fmt.Errorf("pool %s: unsupported load balancing method: %s", name, p.Method)
+	}
+	for _, b := range p.Backends {
+		if b.URL == "" {
+			return fmt.Errorf("pool %s: backend url must not be empty", name)
+		}
+		if backendScheme(b.URL) != p.scheme() {
+			return fmt.Errorf("pool %s: cannot mix tcp://, udp:// and HTTP backends", name)
+		}
+		if err := validateBackendURL(b.URL); err != nil {
+			return fmt.Errorf("pool %s: %w", name, err)
+		}
+	}
+	if scheme := p.scheme(); scheme != "" && (p.Protocol != "" || p.TLS != nil) {
+		return fmt.Errorf("pool %s: protocol and tls do not apply to %s backends", name, scheme)
+	}
+	if p.Transport != nil {
+		if err := p.Transport.validate(); err != nil {
+			return fmt.Errorf("pool %s: %w", name, err)
+		}
+	}
+	urls := make([]string, 0, len(p.Backends))
+	for _, b := range p.Backends {
+		urls = append(urls, b.URL)
+	}
+	if err := validateProtocol(p.Protocol, urls); err != nil {
+		return fmt.Errorf("pool %s: %w", name, err)
+	}
+	if p.TLS != nil {
+		if err := p.TLS.validate(urls); err != nil {
+			return fmt.Errorf("pool %s: tls: %w", name, err)
+		}
+	}
+	switch p.ProxyProtocol {
+	case "", ProxyProtocolV1, ProxyProtocolV2:
+	default:
+		return fmt.Errorf("pool %s: unsupported proxy_protocol: %s", name, p.ProxyProtocol)
+	}
+	if p.ProxyProtocol != "" && p.scheme() == balancer.UDPScheme {
+		return fmt.Errorf("pool %s: proxy_protocol is not supported for %s backends", name, balancer.UDPScheme)
+	}
+	return nil
+}
+
+func (t *BackendTLSConfig) validate(urls []string) error {
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		return errors.New("cert_file and key_file must be set together")
+	}
+	for _, u := range urls {
+		if !strings.HasPrefix(u, "https://") {
+			return fmt.Errorf("requires https backends, got %s", u)
+		}
+	}
 	return nil
 }
 
+// validateProtocol checks that protocol is known and suits the scheme of
+// every backend URL.
+func validateProtocol(protocol string, urls []string) error {
+	var scheme string
+	switch protocol {
+	case "", ProtocolHTTP1:
+		return nil
+	case ProtocolH2:
+		scheme = "https://"
+	case ProtocolH2C:
+		scheme = "http://"
+	default:
+		return fmt.Errorf("unsupported protocol: %s", protocol)
+	}
+	for _, u := range urls {
+		if !strings.HasPrefix(u, scheme) {
+			return fmt.Errorf("protocol %s requires %s backends, got %s", protocol, scheme, u)
+		}
+	}
+	return nil
+}
+
+func (l *ListenerConfig) validate(c *Config) error {
+	if l.Port < 1 || l.Port > 65535 {
+		return fmt.Errorf("invalid port: %d", l.Port)
+	}
+	if l.Sniff != nil && l.Mode != ListenerModeSniff {
+		return errors.New("sniff is only used in sniff mode")
+	}
+	switch l.Mode {
+	case "", ListenerModeHTTP, ListenerModeSniff:
+		if l.Pool != "" {
+			return errors.New("pool is only used in tcp and udp mode")
+		}
+		if l.Hash {
+			return errors.New("hash is only used in udp mode")
+		}
+		if len(l.SNI) > 0 {
+			return errors.New("sni is only used in tcp mode")
+		}
+		if l.Sniff != nil {
+			if err := l.Sniff.validate(c, l.TLS != nil); err != nil {
+				return fmt.Errorf("sniff: %w", err)
+			}
+		}
+	case ListenerModeTCP, ListenerModeUDP:
+		scheme := l.Mode + "://"
+		if len(l.SNI) > 0 && l.Mode != ListenerModeTCP {
+			return errors.New("sni is only used in tcp mode")
+		}
+		for _, r := range l.SNI {
+			if err := r.validate(c); err != nil {
+				return fmt.Errorf("sni %q: %w", r.ServerName, err)
+			}
+		}
+		// With SNI routes the default pool is optional.
+		pool, ok := c.Pools[l.Pool]
+		if (!ok || pool.scheme() != scheme) && (l.Pool != "" || len(l.SNI) == 0) {
+			return fmt.Errorf("%s mode requires a pool of %s backends, got %q", l.Mode, scheme, l.Pool)
+		}
+		if l.TLS != nil || l.H2C {
+			return fmt.Errorf("tls and h2c are not supported in %s mode", l.Mode)
+		}
+		if l.Hash && l.Mode != ListenerModeUDP {
+			return errors.New("hash is only used in udp mode")
+		}
+	default:
+		return fmt.Errorf("unsupported mode: %s", l.Mode)
+	}
+	if l.IdleTimeout < 0 {
+		return errors.New("idle_timeout must not be negative")
+	}
+	if l.ReusePort < 0 {
+		return errors.New("reuse_port must not be negative")
+	}
+	if l.TLS != nil {
+		if err := l.TLS.validate(); err != nil {
+			return fmt.Errorf("tls: %w", err)
+		}
+		// A sniff mode listener serves plaintext HTTP alongside TLS.
+		if l.H2C && l.Mode != ListenerModeSniff {
+			return errors.New("h2c is only supported on plaintext listeners")
+		}
+	}
+	if l.ProxyProtocol != nil {
+		if l.Mode == ListenerModeUDP {
+			return errors.New("proxy_protocol is not supported in udp mode")
+		}
+		if err := l.ProxyProtocol.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SniffConfig) validate(c *Config, terminatesTLS bool) error {
+	if s.TLSPool != "" && terminatesTLS {
+		return errors.New("tls_pool cannot be used with listener tls settings")
+	}
+	for _, name := range []string{s.TLSPool, s.TCPPool} {
+		if name == "" {
+			continue
+		}
+		if pool, ok := c.Pools[name]; !ok || pool.scheme() != balancer.TCPScheme {
+			return fmt.Errorf("requires a pool of %s backends, got %q", balancer.TCPScheme, name)
+		}
+	}
+	if s.Timeout < 0 {
+		return errors.New("timeout must not be negative")
+	}
+	return nil
+}
+
+func (a *AdminConfig) validate() error {
+	if a.Port < 1 || a.Port > 65535 {
+		return fmt.Errorf("admin: invalid port: %d", a.Port)
+	}
+	if a.Address != "" {
+		if _, err := netip.ParseAddr(a.Address); err != nil {
+			return fmt.Errorf("admin: invalid address: %q", a.Address)
+		}
+	}
+	return nil
+}
+
+// Loopback reports whether the admin API listens on a loopback address.
+func (a *AdminConfig) Loopback() bool {
+	addr, err := netip.ParseAddr(a.Address)
+	return a.Address == "" || err == nil && addr.IsLoopback()
+}
+
+// Addr returns the host:port the admin API listens on.
+func (a *AdminConfig) Addr() string {
+	address := a.Address
+	if address == "" {
+		address = DefaultAdminAddress
+	}
+	return net.JoinHostPort(address, strconv.Itoa(a.Port))
+}
+
+func (p *ProxyProtocolConfig) validate() error {
+	if len(p.TrustedProxies) == 0 {
+		return errors.New("proxy_protocol: trusted_proxies must not be empty")
+	}
+	if _, err := ParseCIDRs(p.TrustedProxies); err != nil {
+		return fmt.Errorf("proxy_protocol: %w", err)
+	}
+	return nil
+}
+
+func (r *SNIRouteConfig) validate(c *Config) error {
+	name := strings.TrimPrefix(r.ServerName, "*.")
+	if name == "" || strings.Contains(name, "*") {
+		return errors.New("server_name must be a host name or *.domain")
+	}
+	if pool, ok := c.Pools[r.Pool]; !ok || pool.scheme() != balancer.TCPScheme {
+		return fmt.Errorf("requires a pool of %s backends, got %q", balancer.TCPScheme, r.Pool)
+	}
+	return nil
+}
+
+func (t *ListenerTLSConfig) validate() error {
+	if len(t.Certificates) == 0 {
+		return errors.New("at least one certificate is required")
+	}
+	for _, c := range t.Certificates {
+		if c.CertFile == "" || c.KeyFile == "" {
+			return errors.New("cert_file and key_file are required")
+		}
+	}
+	switch t.MinVersion {
+	case "", "1.0", "1.1", "1.2", "1.3":
+	default:
+		return fmt.Errorf("unsupported min_version: %s", t.MinVersion)
+	}
+	known := make(map[string]bool)
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = true
+	}
+	for _, name := range t.CipherSuites {
+		if !known[name] {
+			return fmt.Errorf("unsupported cipher suite: %s", name)
+		}
+	}
+	if t.ClientAuth != nil {
+		if t.ClientAuth.CAFile == "" {
+			return errors.New("client_auth: ca_file is required")
+		}
+		if err := t.ClientAuth.CertPolicyConfig.validate(); err != nil {
+			return fmt.Errorf("client_auth: %w", err)
+		}
+	}
+	return nil
+}
+
+func (p *CertPolicyConfig) validate() error {
+	for _, m := range append(p.Allow, p.Deny...) {
+		if m.Subject == "" && m.SAN == "" && m.Fingerprint == "" {
+			return errors.New("certificate rule must set subject, san or fingerprint")
+		}
+		if m.Fingerprint != "" {
+			fp := strings.ReplaceAll(m.Fingerprint, ":", "")
+			if _, err := hex.DecodeString(fp); err != nil || len(fp) != 2*sha256.Size {
+				return fmt.Errorf("invalid SHA-256 fingerprint: %s", m.Fingerprint)
+			}
+		}
+	}
+	return nil
+}
+
+func (t *TransportConfig) validate() error {
+	if t.MaxIdleConns < 0 || t.MaxConnsPerHost < 0 {
+		return errors.New("transport: connection limits must not be negative")
+	}
+	for _, d := range []Duration{
+		t.IdleConnTimeout, t.DialTimeout, t.TLSHandshakeTimeout,
+		t.ResponseHeaderTimeout, t.ExpectContinueTimeout,
+	} {
+		if d < 0 {
+			return errors.New("transport: timeouts must not be negative")
+		}
+	}
+	return nil
+}
+
+func (r *RouteConfig) validate(c *Config) error {
+	if !c.HasPool(r.Pool) {
+		return fmt.Errorf("unknown pool: %q", r.Pool)
+	}
+	if pool, ok := c.Pools[r.Pool]; ok && pool.scheme() != "" {
+		return fmt.Errorf("pool %s serves %s backends, not routes", r.Pool, pool.scheme())
+	}
+	if r.PathPrefix != "" && !strings.HasPrefix(r.PathPrefix, "/") {
+		return fmt.Errorf("path_prefix must start with /: %q", r.PathPrefix)
+	}
+	if err := validateHostHeader(r.HostHeader); err != nil {
+		return err
+	}
+	if r.Timeout < 0 {
+		return errors.New("timeout must not be negative")
+	}
+	if r.SSE != nil && r.SSE.HeartbeatInterval < 0 {
+		return errors.New("sse: heartbeat_interval must not be negative")
+	}
+	if r.GRPC {
+		if p := c.poolProtocol(r.Pool); p != ProtocolH2 && p != ProtocolH2C {
+			return fmt.Errorf("grpc requires a pool with protocol %s or %s", ProtocolH2, ProtocolH2C)
+		}
+	}
+	if len(r.GRPCMethods) > 0 && !r.GRPC {
+		return errors.New("grpc_methods requires grpc")
+	}
+	for _, name := range r.GRPCMethods {
+		service, method, hasMethod := strings.Cut(name, "/")
+		if service == "" || (hasMethod && (method == "" || strings.Contains(method, "/"))) {
+			return fmt.Errorf("grpc_methods: %q is not a service or service/method", name)
+		}
+	}
+	if r.ClientCert != nil {
+		if err := r.ClientCert.validate(); err != nil {
+			return fmt.Errorf("client_cert: %w", err)
+		}
+	}
+	if r.Headers != nil {
+		if err := r.Headers.Request.validate(); err != nil {
+			return fmt.Errorf("request headers: %w", err)
+		}
+		if err := r.Headers.Response.validate(); err != nil {
+			return fmt.Errorf("response headers: %w", err)
+		}
+	}
+	return nil
+}
+
+// poolProtocol returns the backend protocol of the named pool.
+func (c *Config) poolProtocol(name string) string {
+	if name == "" || name == DefaultPool {
+		return c.BackendProtocol
+	}
+	return c.Pools[name].Protocol
+}
+
+func validateHostHeader(policy string) error {
+	switch policy {
+	case "", HostHeaderBackend, HostHeaderPreserve:
+		return nil
+	}
+	return fmt.Errorf("unsupported host_header policy: %s", policy)
+}
+
+func (o *HeaderOpsConfig) validate() error {
+	for _, values := range []map[string]string{o.Set, o.Add} {
+		for name, value := range values {
+			if name == "" {
+				return errors.New("header name must not be empty")
+			}
+			if err := validateHeaderValue(value); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	}
+	for _, name := range o.Remove {
+		if name == "" {
+			return errors.New("header name must not be empty")
+		}
+	}
+	return nil
+}
+
+// validateHeaderValue checks that every ${name} reference in value is closed
+// and names a known variable.
+func validateHeaderValue(value string) error {
+	for {
+		start := strings.Index(value, "${")
+		if start < 0 {
+			return nil
+		}
+		end := strings.Index(value[start:], "}")
+		if end < 0 {
+			return fmt.Errorf("unterminated variable in %q", value)
+		}
+		name := value[start+2 : start+end]
+		if !HeaderVariables[name] {
+			return fmt.Errorf("unknown variable: %s", name)
+		}
+		value = value[start+end+1:]
+	}
+}
+
+func (f *ForwardedConfig) validate() error {
+	switch f.Mode {
+	case "", ForwardedModeXForwarded, ForwardedModeRFC7239, ForwardedModeBoth:
+	default:
+		return fmt.Errorf("forwarded: unsupported mode: %s", f.Mode)
+	}
+	if _, err := ParseCIDRs(f.TrustedProxies); err != nil {
+		return fmt.Errorf("forwarded: %w", err)
+	}
+	return nil
+}
+
+// ParseCIDRs parses a list of CIDRs. Bare IP addresses are accepted and
+// treated as single-address prefixes.
+func ParseCIDRs(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, v := range values {
+		if strings.Contains(v, "/") {
+			prefix, err := netip.ParsePrefix(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR: %q", v)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP address: %q", v)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+	return prefixes, nil
+}
+
+func (m *MirrorConfig) validate(pools map[string]PoolConfig) error {
+	pool, ok := pools[m.Pool]
+	if !ok {
+		return fmt.Errorf("mirror: unknown pool: %q", m.Pool)
+	}
+	if scheme := pool.scheme(); scheme != "" {
+		return fmt.Errorf("mirror: pool %s serves %s backends", m.Pool, scheme)
+	}
+	if m.Percent < 0 || m.Percent > 100 {
+		return fmt.Errorf("mirror: percent must be between 0 and 100, got %v", m.Percent)
+	}
+	if m.MaxBodyBytes < 0 {
+		return fmt.Errorf("mirror: invalid max_body_bytes: %d", m.MaxBodyBytes)
+	}
+	return nil
+}
+
+// Merge overrides c with the values set in other, such as command-line
+// flags.
 func (c *Config) Merge(other *Config) {
 	if other.Port != 0 {
 		c.Port = other.Port
@@ -99,4 +1106,13 @@ func (c *Config) Merge(other *Config) {
 	if other.Method != "" {
 		c.Method = other.Method
 	}
+	if other.GracePeriod != 0 {
+		c.GracePeriod = other.GracePeriod
+	}
+	if other.DrainDelay != 0 {
+		c.DrainDelay = other.DrainDelay
+	}
+	if other.ReusePort != 0 {
+		c.ReusePort = other.ReusePort
+	}
 }
@@ -5,6 +5,7 @@ import (
 	"os"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestConfigValidation(t *testing.T) {
@@ -31,6 +32,407 @@ func TestConfigValidation(t *testing.T) {
 	if err := cfg.Validate(); err == nil {
 		t.Errorf("expected error for invalid port")
 	}
+
+	// Negative shutdown grace period
+	cfg = &Config{Port: 8080, Backends: StringSlice{"http://b1"}, Method: "roundrobin",
+		GracePeriod: Duration(-time.Second)}
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("expected error for negative grace period")
+	}
+
+	// Negative drain delay
+	cfg = &Config{Port: 8080, Backends: StringSlice{"http://b1"}, Method: "roundrobin",
+		DrainDelay: Duration(-time.Second)}
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("expected error for negative drain delay")
+	}
+
+	// Admin API on the main port or a bad address
+	cfg = &Config{Port: 8080, Backends: StringSlice{"http://b1"}, Method: "roundrobin",
+		Admin: &AdminConfig{Port: 8080}}
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("expected error for admin port in use")
+	}
+	cfg.Admin = &AdminConfig{Port: 9090, Address: "localhost"}
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("expected error for admin address that is not an IP")
+	}
+	cfg.Admin = &AdminConfig{Port: 9090}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("unexpected error for admin config: %v", err)
+	}
+	if addr := cfg.Admin.Addr(); addr != "127.0.0.1:9090" {
+		t.Errorf("expected admin API on 127.0.0.1:9090 by default, got %s", addr)
+	}
+	for address, loopback := range map[string]bool{"": true, "::1": true, "10.0.0.1": false, "0.0.0.0": false} {
+		if got := (&AdminConfig{Port: 9090, Address: address}).Loopback(); got != loopback {
+			t.Errorf("expected Loopback() = %t for %q, got %t", loopback, address, got)
+		}
+	}
+
+	// Negative SO_REUSEPORT listener count
+	cfg = &Config{Port: 8080, Backends: StringSlice{"http://b1"}, Method: "roundrobin", ReusePort: -1}
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("expected error for negative reuse_port")
+	}
+
+	// Pool without backends
+	cfg = &Config{Port: 8080, Backends: StringSlice{"http://b1"}, Method: "roundrobin",
+		Pools: map[string]PoolConfig{"shadow": {Method: "roundrobin"}}}
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("expected error for pool without backends")
+	}
+
+	// Mirror percent out of range
+	cfg = &Config{Port: 8080, Backends: StringSlice{"http://b1"}, Method: "roundrobin",
+		Pools:  map[string]PoolConfig{"shadow": {Method: "roundrobin", Backends: []BackendConfig{{URL: "http://s1"}}}},
+		Mirror: &MirrorConfig{Pool: "shadow", Percent: 150}}
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("expected error for mirror percent above 100")
+	}
+
+	// Route referencing an unknown pool
+	cfg = &Config{Port: 8080, Backends: StringSlice{"http://b1"}, Method: "roundrobin",
+		Routes: []RouteConfig{{Name: "api", Pool: "missing"}}}
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("expected error for route with unknown pool")
+	}
+
+	// Header rule using an unknown variable
+	cfg = &Config{Port: 8080, Backends: StringSlice{"http://b1"}, Method: "roundrobin",
+		Routes: []RouteConfig{{Name: "api", Headers: &HeaderRulesConfig{
+			Request: HeaderOpsConfig{Set: map[string]string{"X-User": "${user}"}},
+		}}}}
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("expected error for unknown header variable")
+	}
+
+	// Valid route with header rules
+	cfg = &Config{Port: 8080, Backends: StringSlice{"http://b1"}, Method: "roundrobin",
+		Routes: []RouteConfig{{Name: "api", PathPrefix: "/api", Headers: &HeaderRulesConfig{
+			Request:  HeaderOpsConfig{Set: map[string]string{"X-Client-IP": "${client_ip}"}},
+			Response: HeaderOpsConfig{Remove: []string{"Server"}},
+		}}}}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("expected valid route config, got error: %v", err)
+	}
+
+	// Forwarding with an invalid trusted proxy
+	cfg = &Config{Port: 8080, Backends: StringSlice{"http://b1"}, Method: "roundrobin",
+		Forwarded: &ForwardedConfig{TrustedProxies: []string{"10.0.0.0/33"}}}
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("expected error for invalid trusted proxy CIDR")
+	}
+
+	// Forwarding with an unknown mode
+	cfg = &Config{Port: 8080, Backends: StringSlice{"http://b1"}, Method: "roundrobin",
+		Forwarded: &ForwardedConfig{Mode: "x-real-ip"}}
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("expected error for unsupported forwarded mode")
+	}
+
+	// h2c backends must be plaintext
+	cfg = &Config{Port: 8080, Backends: StringSlice{"https://b1"}, Method: "roundrobin", BackendProtocol: ProtocolH2C}
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("expected error for h2c with https backend")
+	}
+
+	// Unknown backend protocol
+	cfg = &Config{Port: 8080, Backends: StringSlice{"http://b1"}, Method: "roundrobin", BackendProtocol: "spdy"}
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("expected error for unsupported backend protocol")
+	}
+
+	// Listener reusing the main port
+	cfg = &Config{Port: 8080, Backends: StringSlice{"http://b1"}, Method: "roundrobin",
+		Listeners: []ListenerConfig{{Name: "alt", Port: 8080}}}
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("expected error for duplicate listener port")
+	}
+
+	// h2c on a TLS listener
+	cfg = &Config{Port: 8080, Backends: StringSlice{"http://b1"}, Method: "roundrobin",
+		Listeners: []ListenerConfig{{Name: "tls", Port: 8443, H2C: true,
+			TLS: &ListenerTLSConfig{Certificates: []CertificateConfig{{CertFile: "cert.pem", KeyFile: "key.pem"}}}}}}
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("expected error for h2c on a TLS listener")
+	}
+
+	// gRPC route on an HTTP/1.1 pool
+	cfg = &Config{Port: 8080, Backends: StringSlice{"http://b1"}, Method: "roundrobin",
+		Routes: []RouteConfig{{Name: "rpc", GRPC: true}}}
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("expected error for gRPC route without HTTP/2 backends")
+	}
+
+	// gRPC route on an h2c pool
+	cfg = &Config{Port: 8080, Backends: StringSlice{"http://b1"}, Method: "roundrobin",
+		Pools:  map[string]PoolConfig{"rpc": {Method: "roundrobin", Backends: []BackendConfig{{URL: "http://r1"}}, Protocol: ProtocolH2C}},
+		Routes: []RouteConfig{{Name: "rpc", Pool: "rpc", GRPC: true}}}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("expected valid gRPC route, got error: %v", err)
+	}
+
+	// Backend URLs must suit the pool
+	for _, cfg := range []*Config{
+		{Port: 8080, Backends: StringSlice{"not a url"}, Method: "roundrobin"},
+		{Port: 8080, Backends: StringSlice{"tcp://10.0.0.5:5432"}, Method: "roundrobin"},
+		{Port: 8080, Backends: StringSlice{"http://b1"}, Method: "roundrobin",
+			Pools: map[string]PoolConfig{"db": {Method: "roundrobin", Backends: []BackendConfig{{URL: "tcp://db1"}}}}},
+		{Port: 8080, Backends: StringSlice{"http://b1"}, Method: "roundrobin",
+			Pools: map[string]PoolConfig{"web": {Method: "roundrobin", Backends: []BackendConfig{{URL: "http://"}}}}},
+	} {
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("expected error for backends %v %v", cfg.Backends, cfg.Pools)
+		}
+	}
+
+	// gRPC metric names must be a service or service/method
+	cfg = &Config{Port: 8080, Backends: StringSlice{"http://b1"}, Method: "roundrobin",
+		Pools: map[string]PoolConfig{"rpc": {Method: "roundrobin", Backends: []BackendConfig{{URL: "http://r1"}}, Protocol: ProtocolH2C}},
+		Routes: []RouteConfig{{Name: "rpc", Pool: "rpc", GRPC: true,
+			GRPCMethods: []string{"helloworld.Greeter", "test.Echo/Ping/Extra"}}}}
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("expected error for a malformed grpc_methods entry")
+	}
+
+	// TLS listener without certificates
+	cfg = &Config{Port: 8080, Backends: StringSlice{"http://b1"}, Method: "roundrobin",
+		Listeners: []ListenerConfig{{Name: "tls", Port: 8443, TLS: &ListenerTLSConfig{}}}}
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("expected error for TLS listener without certificates")
+	}
+
+	// TLS listener with an insecure cipher suite
+	cfg = &Config{Port: 8080, Backends: StringSlice{"http://b1"}, Method: "roundrobin",
+		Listeners: []ListenerConfig{{Name: "tls", Port: 8443, TLS: &ListenerTLSConfig{
+			Certificates: []CertificateConfig{{CertFile: "cert.pem", KeyFile: "key.pem"}},
+			CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"},
+		}}}}
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("expected error for insecure cipher suite")
+	}
+
+	// Backend TLS on plaintext backends
+	cfg = &Config{Port: 8080, Backends: StringSlice{"http://b1"}, Method: "roundrobin",
+		BackendTLS: &BackendTLSConfig{CAFile: "ca.pem"}}
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("expected error for backend TLS with http backends")
+	}
+
+	// Backend client certificate without key
+	cfg = &Config{Port: 8080, Backends: StringSlice{"http://b1"}, Method: "roundrobin",
+		Pools: map[string]PoolConfig{"secure": {Method: "roundrobin", Backends: []BackendConfig{{URL: "https://s1"}},
+			TLS: &BackendTLSConfig{CertFile: "client.pem"}}}}
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("expected error for client certificate without key")
+	}
+
+	// Client auth without a CA
+	cfg = &Config{Port: 8080, Backends: StringSlice{"http://b1"}, Method: "roundrobin",
+		Listeners: []ListenerConfig{{Name: "mtls", Port: 8443, TLS: &ListenerTLSConfig{
+			Certificates: []CertificateConfig{{CertFile: "cert.pem", KeyFile: "key.pem"}},
+			ClientAuth:   &ClientAuthConfig{},
+		}}}}
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("expected error for client auth without ca_file")
+	}
+
+	// Route client certificate rule with a malformed fingerprint
+	cfg = &Config{Port: 8080, Backends: StringSlice{"http://b1"}, Method: "roundrobin",
+		Routes: []RouteConfig{{Name: "internal", ClientCert: &CertPolicyConfig{
+			Allow: []CertMatcherConfig{{Fingerprint: "abc"}},
+		}}}}
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("expected error for malformed fingerprint")
+	}
+
+	// Valid TLS listener
+	cfg = &Config{Port: 8080, Backends: StringSlice{"http://b1"}, Method: "roundrobin",
+		Listeners: []ListenerConfig{{Name: "tls", Port: 8443,
+			TLS: &ListenerTLSConfig{Certificates: []CertificateConfig{{CertFile: "cert.pem", KeyFile: "key.pem"}}}}}}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("expected valid listener config, got error: %v", err)
+	}
+
+	tcpPools := map[string]PoolConfig{
+		"db": {Method: "leastconn", Backends: []BackendConfig{{URL: "tcp://db1:5432"}, {URL: "tcp://db2:5432"}}},
+	}
+
+	// TCP listener without a pool
+	cfg = &Config{Port: 8080, Backends: StringSlice{"http://b1"}, Method: "roundrobin", Pools: tcpPools,
+		Listeners: []ListenerConfig{{Name: "db", Port: 5432, Mode: ListenerModeTCP}}}
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("expected error for tcp listener without pool")
+	}
+
+	// TCP listener on an HTTP pool
+	cfg = &Config{Port: 8080, Backends: StringSlice{"http://b1"}, Method: "roundrobin",
+		Pools:     map[string]PoolConfig{"web": {Method: "roundrobin", Backends: []BackendConfig{{URL: "http://w1"}}}},
+		Listeners: []ListenerConfig{{Name: "db", Port: 5432, Mode: ListenerModeTCP, Pool: "web"}}}
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("expected error for tcp listener on an http pool")
+	}
+
+	// Pool mixing tcp and http backends
+	cfg = &Config{Port: 8080, Backends: StringSlice{"http://b1"}, Method: "roundrobin",
+		Pools: map[string]PoolConfig{"mixed": {Method: "roundrobin", Backends: []BackendConfig{{URL: "tcp://db1:5432"}, {URL: "http://w1"}}}}}
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("expected error for pool mixing tcp and http backends")
+	}
+
+	// HTTP route on a tcp pool
+	cfg = &Config{Port: 8080, Backends: StringSlice{"http://b1"}, Method: "roundrobin", Pools: tcpPools,
+		Routes: []RouteConfig{{Name: "db", Pool: "db"}}}
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("expected error for route on a tcp pool")
+	}
+
+	// Valid TCP listener
+	cfg = &Config{Port: 8080, Backends: StringSlice{"http://b1"}, Method: "roundrobin", Pools: tcpPools,
+		Listeners: []ListenerConfig{{Name: "db", Port: 5432, Mode: ListenerModeTCP, Pool: "db", IdleTimeout: Duration(time.Minute)}}}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("expected valid tcp listener, got error: %v", err)
+	}
+
+	udpPools := map[string]PoolConfig{
+		"dns": {Method: "roundrobin", Backends: []BackendConfig{{URL: "udp://ns1:53"}, {URL: "udp://ns2:53"}}},
+	}
+
+	// UDP listener on a tcp pool
+	cfg = &Config{Port: 8080, Backends: StringSlice{"http://b1"}, Method: "roundrobin", Pools: tcpPools,
+		Listeners: []ListenerConfig{{Name: "dns", Port: 53, Mode: ListenerModeUDP, Pool: "db"}}}
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("expected error for udp listener on a tcp pool")
+	}
+
+	// Hash on a tcp listener
+	cfg = &Config{Port: 8080, Backends: StringSlice{"http://b1"}, Method: "roundrobin", Pools: tcpPools,
+		Listeners: []ListenerConfig{{Name: "db", Port: 5432, Mode: ListenerModeTCP, Pool: "db", Hash: true}}}
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("expected error for hash outside udp mode")
+	}
+
+	// UDP and TCP listeners sharing a port number
+	pools := map[string]PoolConfig{"dns": udpPools["dns"],
+		"dns-tcp": {Method: "roundrobin", Backends: []BackendConfig{{URL: "tcp://ns1:53"}}}}
+	cfg = &Config{Port: 8080, Backends: StringSlice{"http://b1"}, Method: "roundrobin", Pools: pools,
+		Listeners: []ListenerConfig{
+			{Name: "dns", Port: 53, Mode: ListenerModeUDP, Pool: "dns", Hash: true},
+			{Name: "dns-tcp", Port: 53, Mode: ListenerModeTCP, Pool: "dns-tcp"},
+		}}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("expected valid udp listener, got error: %v", err)
+	}
+
+	// SNI routes to a tcp pool without a default pool
+	cfg = &Config{Port: 8080, Backends: StringSlice{"http://b1"}, Method: "roundrobin", Pools: tcpPools,
+		Listeners: []ListenerConfig{{Name: "tls", Port: 443, Mode: ListenerModeTCP,
+			SNI: []SNIRouteConfig{{ServerName: "*.db.example.com", Pool: "db"}}}}}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("expected valid sni routes, got error: %v", err)
+	}
+
+	// SNI route with a misplaced wildcard
+	cfg.Listeners[0].SNI = []SNIRouteConfig{{ServerName: "db.*.example.com", Pool: "db"}}
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("expected error for misplaced sni wildcard")
+	}
+
+	// SNI route to an unknown pool
+	cfg.Listeners[0].SNI = []SNIRouteConfig{{ServerName: "db.example.com", Pool: "missing"}}
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("expected error for sni route to unknown pool")
+	}
+
+	// PROXY protocol without trusted proxies
+	cfg = &Config{Port: 8080, Backends: StringSlice{"http://b1"}, Method: "roundrobin",
+		ProxyProtocol: &ProxyProtocolConfig{}}
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("expected error for proxy_protocol without trusted_proxies")
+	}
+
+	// PROXY protocol on a udp listener
+	cfg = &Config{Port: 8080, Backends: StringSlice{"http://b1"}, Method: "roundrobin", Pools: udpPools,
+		Listeners: []ListenerConfig{{Name: "dns", Port: 53, Mode: ListenerModeUDP, Pool: "dns",
+			ProxyProtocol: &ProxyProtocolConfig{TrustedProxies: []string{"10.0.0.0/8"}}}}}
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("expected error for proxy_protocol in udp mode")
+	}
+
+	// Unknown PROXY protocol version toward a pool
+	cfg = &Config{Port: 8080, Backends: StringSlice{"http://b1"}, Method: "roundrobin",
+		Pools: map[string]PoolConfig{"web": {Method: "roundrobin", Backends: []BackendConfig{{URL: "http://w1"}}, ProxyProtocol: "v3"}}}
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("expected error for unsupported proxy_protocol version")
+	}
+
+	// PROXY protocol in and out of a tcp listener
+	cfg = &Config{Port: 8080, Backends: StringSlice{"http://b1"}, Method: "roundrobin",
+		ProxyProtocol: &ProxyProtocolConfig{TrustedProxies: []string{"10.0.0.0/8", "192.168.1.10"}},
+		Pools:         map[string]PoolConfig{"db": {Method: "roundrobin", Backends: []BackendConfig{{URL: "tcp://db1:5432"}}, ProxyProtocol: ProxyProtocolV2}},
+		Listeners: []ListenerConfig{{Name: "db", Port: 5432, Mode: ListenerModeTCP, Pool: "db",
+			ProxyProtocol: &ProxyProtocolConfig{TrustedProxies: []string{"10.0.0.0/8"}}}}}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("expected valid proxy_protocol config, got error: %v", err)
+	}
+
+	// Sniff mode passing TLS through while also terminating it
+	certs := []CertificateConfig{{CertFile: "cert.pem", KeyFile: "key.pem"}}
+	cfg = &Config{Port: 8080, Backends: StringSlice{"http://b1"}, Method: "roundrobin", Pools: tcpPools,
+		Listeners: []ListenerConfig{{Name: "any", Port: 9000, Mode: ListenerModeSniff,
+			TLS: &ListenerTLSConfig{Certificates: certs}, Sniff: &SniffConfig{TLSPool: "db"}}}}
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("expected error for tls_pool with listener tls")
+	}
+
+	// Sniff mode with an HTTP pool for raw TCP
+	cfg = &Config{Port: 8080, Backends: StringSlice{"http://b1"}, Method: "roundrobin",
+		Pools:     map[string]PoolConfig{"web": {Method: "roundrobin", Backends: []BackendConfig{{URL: "http://w1"}}}},
+		Listeners: []ListenerConfig{{Name: "any", Port: 9000, Mode: ListenerModeSniff, Sniff: &SniffConfig{TCPPool: "web"}}}}
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("expected error for sniff tcp_pool with http backends")
+	}
+
+	// Sniff settings outside sniff mode
+	cfg = &Config{Port: 8080, Backends: StringSlice{"http://b1"}, Method: "roundrobin", Pools: tcpPools,
+		Listeners: []ListenerConfig{{Name: "alt", Port: 9000, Sniff: &SniffConfig{TCPPool: "db"}}}}
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("expected error for sniff outside sniff mode")
+	}
+
+	// Valid sniff mode listener terminating TLS, with h2c and a tcp pool
+	cfg = &Config{Port: 8080, Backends: StringSlice{"http://b1"}, Method: "roundrobin", Pools: tcpPools,
+		Listeners: []ListenerConfig{{Name: "any", Port: 9000, Mode: ListenerModeSniff, H2C: true,
+			TLS: &ListenerTLSConfig{Certificates: certs}, Sniff: &SniffConfig{TCPPool: "db", Timeout: Duration(time.Second)}}}}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("expected valid sniff listener, got error: %v", err)
+	}
+
+	// Two UDP listeners on one port
+	cfg = &Config{Port: 8080, Backends: StringSlice{"http://b1"}, Method: "roundrobin", Pools: pools,
+		Listeners: []ListenerConfig{{Name: "dns", Port: 53, Mode: ListenerModeUDP, Pool: "dns"}, {}}}
+	cfg.Listeners[1] = ListenerConfig{Name: "dns2", Port: 53, Mode: ListenerModeUDP, Pool: "dns"}
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("expected error for duplicate udp port")
+	}
+}
+
+func TestParseCIDRs(t *testing.T) {
+	prefixes, err := ParseCIDRs([]string{"10.0.0.0/8", "192.168.1.7", "2001:db8::/32"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{"10.0.0.0/8", "192.168.1.7/32", "2001:db8::/32"}
+	for i, p := range prefixes {
+		if p.String() != want[i] {
+			t.Errorf("prefix %d: expected %s, got %s", i, want[i], p)
+		}
+	}
+
+	if _, err := ParseCIDRs([]string{"not-an-ip"}); err == nil {
+		t.Error("expected error for invalid address")
+	}
 }
 
 func TestStringSliceFlag(t *testing.T) {
@@ -80,11 +482,15 @@ func TestParseFlags(t *testing.T) {
 				"-port=9000",
 				"-backend=http://b1,http://b2",
 				"-method=leastconn",
+				"-grace-period=45s",
+				"-reuse-port=4",
 			},
 			expected: &Config{
-				Port:     9000,
-				Backends: StringSlice{"http://b1", "http://b2"},
-				Method:   "leastconn",
+				Port:        9000,
+				Backends:    StringSlice{"http://b1", "http://b2"},
+				Method:      "leastconn",
+				GracePeriod: Duration(45 * time.Second),
+				ReusePort:   4,
 			},
 			wantErr: false,
 		},
@@ -186,6 +592,12 @@ func TestParseFlags(t *testing.T) {
 			if !reflect.DeepEqual(cfg.Backends, tc.expected.Backends) {
 				t.Errorf("ParseFlags() Backends = %v, want %v", cfg.Backends, tc.expected.Backends)
 			}
+			if cfg.GracePeriod != tc.expected.GracePeriod {
+				t.Errorf("ParseFlags() GracePeriod = %v, want %v", cfg.GracePeriod, tc.expected.GracePeriod)
+			}
+			if cfg.ReusePort != tc.expected.ReusePort {
+				t.Errorf("ParseFlags() ReusePort = %v, want %v", cfg.ReusePort, tc.expected.ReusePort)
+			}
 		})
 	}
 }
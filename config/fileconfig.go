@@ -14,9 +14,28 @@ type BackendConfig struct {
 
 // FileConfig represents configuration loaded from a file
 type FileConfig struct {
-	Port     int             `json:"port"`
-	Backends []BackendConfig `json:"backends"`
-	Method   string          `json:"method"`
+	Port              int                      `json:"port"`
+	Backends          []BackendConfig          `json:"backends"`
+	Method            string                   `json:"method"`
+	Pools             map[string]PoolConfig    `json:"pools,omitempty"`
+	Mirror            *MirrorConfig            `json:"mirror,omitempty"`
+	Routes            []RouteConfig            `json:"routes,omitempty"`
+	Forwarded         *ForwardedConfig         `json:"forwarded,omitempty"`
+	HostHeader        string                   `json:"host_header,omitempty"`
+	Upgrade           *UpgradeConfig           `json:"upgrade,omitempty"`
+	Timeout           Duration                 `json:"timeout,omitempty"`
+	FlushInterval     Duration                 `json:"flush_interval,omitempty"`
+	Transport         *TransportConfig         `json:"transport,omitempty"`
+	BackendProtocol   string                   `json:"backend_protocol,omitempty"`
+	BackendTLS        *BackendTLSConfig        `json:"backend_tls,omitempty"`
+	ClientCertHeaders *ClientCertHeadersConfig `json:"client_cert_headers,omitempty"`
+	H2C               bool                     `json:"h2c,omitempty"`
+	ProxyProtocol     *ProxyProtocolConfig     `json:"proxy_protocol,omitempty"`
+	ReusePort         int                      `json:"reuse_port,omitempty"`
+	Listeners         []ListenerConfig         `json:"listeners,omitempty"`
+	GracePeriod       Duration                 `json:"grace_period,omitempty"`
+	DrainDelay        Duration                 `json:"drain_delay,omitempty"`
+	Admin             *AdminConfig             `json:"admin,omitempty"`
 }
 
 // LoadConfigFromFile loads config from a JSON file
@@ -27,12 +46,7 @@ func LoadConfigFromFile(path string) (*Config, map[string]int, error) {
 		return nil, nil, err
 	}
 
-	var fileConfig struct {
-		Port     int             `json:"port"`
-		Backends []BackendConfig `json:"backends"`
-		Method   string          `json:"method"`
-	}
-
+	var fileConfig FileConfig
 	if err := json.Unmarshal(data, &fileConfig); err != nil {
 		return nil, nil, fmt.Errorf("failed to parse config file: %w", err)
 	}
@@ -50,9 +64,28 @@ func LoadConfigFromFile(path string) (*Config, map[string]int, error) {
 	}
 
 	config := &Config{
-		Port:     fileConfig.Port,
-		Backends: StringSlice(urls),
-		Method:   fileConfig.Method,
+		Port:              fileConfig.Port,
+		Backends:          StringSlice(urls),
+		Method:            fileConfig.Method,
+		Pools:             fileConfig.Pools,
+		Mirror:            fileConfig.Mirror,
+		Routes:            fileConfig.Routes,
+		Forwarded:         fileConfig.Forwarded,
+		HostHeader:        fileConfig.HostHeader,
+		Upgrade:           fileConfig.Upgrade,
+		Timeout:           fileConfig.Timeout,
+		FlushInterval:     fileConfig.FlushInterval,
+		Transport:         fileConfig.Transport,
+		BackendProtocol:   fileConfig.BackendProtocol,
+		BackendTLS:        fileConfig.BackendTLS,
+		ClientCertHeaders: fileConfig.ClientCertHeaders,
+		H2C:               fileConfig.H2C,
+		ProxyProtocol:     fileConfig.ProxyProtocol,
+		ReusePort:         fileConfig.ReusePort,
+		Listeners:         fileConfig.Listeners,
+		GracePeriod:       fileConfig.GracePeriod,
+		DrainDelay:        fileConfig.DrainDelay,
+		Admin:             fileConfig.Admin,
 	}
 
 	if err := config.Validate(); err != nil {
@@ -0,0 +1,129 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+	return path
+}
+
+func TestLoadConfigFromFileWithMirror(t *testing.T) {
+	path := writeConfigFile(t, `{
+		"port": 8000,
+		"method": "roundrobin",
+		"backends": [{"url": "http://b1", "weight": 3}, {"url": "http://b2"}],
+		"pools": {
+			"shadow": {"method": "leastconn", "backends": [{"url": "http://s1"}]}
+		},
+		"mirror": {"pool": "shadow", "percent": 25, "timeout": "2s"}
+	}`)
+
+	cfg, weights, err := LoadConfigFromFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if weights["http://b1"] != 3 || weights["http://b2"] != 1 {
+		t.Errorf("unexpected weights: %v", weights)
+	}
+	if len(cfg.Pools["shadow"].Backends) != 1 {
+		t.Fatalf("expected shadow pool with 1 backend, got %+v", cfg.Pools["shadow"])
+	}
+	if cfg.Mirror == nil || cfg.Mirror.Pool != "shadow" || cfg.Mirror.Percent != 25 {
+		t.Fatalf("unexpected mirror config: %+v", cfg.Mirror)
+	}
+	if time.Duration(cfg.Mirror.Timeout) != 2*time.Second {
+		t.Errorf("expected mirror timeout 2s, got %v", time.Duration(cfg.Mirror.Timeout))
+	}
+}
+
+func TestLoadConfigFromFileInvalidMirror(t *testing.T) {
+	path := writeConfigFile(t, `{
+		"port": 8000,
+		"method": "roundrobin",
+		"backends": [{"url": "http://b1"}],
+		"mirror": {"pool": "missing", "percent": 10}
+	}`)
+
+	if _, _, err := LoadConfigFromFile(path); err == nil {
+		t.Error("expected error for mirror referencing an unknown pool")
+	}
+}
+
+func TestDurationUnmarshal(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{`"150ms"`, 150 * time.Millisecond, false},
+		{`"2m"`, 2 * time.Minute, false},
+		{`3`, 3 * time.Second, false},
+		{`"soon"`, 0, true},
+		{`true`, 0, true},
+	}
+
+	for _, tc := range tests {
+		var d Duration
+		err := d.UnmarshalJSON([]byte(tc.input))
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected error", tc.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.input, err)
+		}
+		if time.Duration(d) != tc.want {
+			t.Errorf("%s: expected %v, got %v", tc.input, tc.want, time.Duration(d))
+		}
+	}
+}
+
+func TestLoadConfigFromFileWithClientAuth(t *testing.T) {
+	path := writeConfigFile(t, `{
+		"port": 8000,
+		"method": "roundrobin",
+		"backends": [{"url": "http://b1"}],
+		"listeners": [{
+			"name": "internal",
+			"port": 8443,
+			"tls": {
+				"certificates": [{"cert_file": "server.pem", "key_file": "server.key"}],
+				"client_auth": {
+					"ca_file": "clients.pem",
+					"optional": true,
+					"deny": [{"subject": "CN=revoked"}]
+				}
+			}
+		}],
+		"routes": [{"name": "admin", "path_prefix": "/admin", "client_cert": {"allow": [{"san": "*.ops.internal"}]}}],
+		"client_cert_headers": {"subject": "X-Client-Subject"}
+	}`)
+
+	cfg, _, err := LoadConfigFromFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ca := cfg.Listeners[0].TLS.ClientAuth
+	if ca == nil || ca.CAFile != "clients.pem" || !ca.Optional || len(ca.Deny) != 1 || ca.Deny[0].Subject != "CN=revoked" {
+		t.Errorf("unexpected client auth config: %+v", ca)
+	}
+	if rc := cfg.Routes[0].ClientCert; rc == nil || len(rc.Allow) != 1 || rc.Allow[0].SAN != "*.ops.internal" {
+		t.Errorf("unexpected route client cert config: %+v", rc)
+	}
+	if cfg.ClientCertHeaders == nil || cfg.ClientCertHeaders.Subject != "X-Client-Subject" {
+		t.Errorf("unexpected client cert headers: %+v", cfg.ClientCertHeaders)
+	}
+}
@@ -0,0 +1,208 @@
+// Package auth authenticates callers of the admin API by bearer token and
+// authorizes them by role.
+package auth
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Role is what a caller may do. Each role includes the ones below it.
+type Role int
+
+const (
+	// RoleReadOnly may list pools and backends.
+	RoleReadOnly Role = iota + 1
+	// RoleOperator may also drain backends and change their weights.
+	RoleOperator
+	// RoleAdmin may also add and remove backends and reload the config.
+	RoleAdmin
+)
+
+var roleNames = map[Role]string{
+	RoleReadOnly: "read-only",
+	RoleOperator: "operator",
+	RoleAdmin:    "admin",
+}
+
+func (r Role) String() string {
+	if name, ok := roleNames[r]; ok {
+		return name
+	}
+	return fmt.Sprintf("Role(%d)", int(r))
+}
+
+// ParseRole returns the Role named s.
+func ParseRole(s string) (Role, error) {
+	for role, name := range roleNames {
+		if name == s {
+			return role, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown role: %q", s)
+}
+
+// MinTokenLength is the length below which tokens are rejected as too easy
+// to guess.
+const MinTokenLength = 16
+
+// Caller is an authenticated client.
+type Caller struct {
+	// Name identifies the token the caller presented.
+	Name string
+	Role Role
+}
+
+type token struct {
+	Caller
+	digest [sha256.Size]byte
+}
+
+// Tokens holds the bearer tokens accepted by the admin API.
+type Tokens struct {
+	tokens []token
+}
+
+// parse adds token entries of the form name:role:token, separated by
+// newlines or commas. Blank entries and lines starting with # are skipped.
+// source names the origin of the entries in errors.
+func (t *Tokens) parse(source, entries string) error {
+	scanner := bufio.NewScanner(strings.NewReader(entries))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(text, "#") {
+			continue
+		}
+		for _, entry := range strings.Split(text, ",") {
+			entry = strings.TrimSpace(entry)
+			if entry == "" {
+				continue
+			}
+			tok, err := parseToken(entry)
+			if err != nil {
+				return fmt.Errorf("%s:%d: %w", source, line, err)
+			}
+			for _, other := range t.tokens {
+				if other.Name == tok.Name {
+					return fmt.Errorf("%s:%d: duplicate token name: %s", source, line, tok.Name)
+				}
+				if other.digest == tok.digest {
+					return fmt.Errorf("%s:%d: %s reuses the token of %s", source, line, tok.Name, other.Name)
+				}
+			}
+			t.tokens = append(t.tokens, tok)
+		}
+	}
+	return scanner.Err()
+}
+
+func parseToken(entry string) (token, error) {
+	name, rest, ok1 := strings.Cut(entry, ":")
+	roleName, secret, ok2 := strings.Cut(rest, ":")
+	if !ok1 || !ok2 || name == "" {
+		return token{}, errors.New("expected name:role:token")
+	}
+	role, err := ParseRole(roleName)
+	if err != nil {
+		return token{}, err
+	}
+	if len(secret) < MinTokenLength {
+		return token{}, fmt.Errorf("token of %s is shorter than %d characters", name, MinTokenLength)
+	}
+	return token{
+		Caller: Caller{Name: name, Role: role},
+		digest: sha256.Sum256([]byte(secret)),
+	}, nil
+}
+
+// LoadTokens reads the token entries in the file at path, if path is set,
+// and in the environment variable env, if it is set. It returns nil when
+// neither holds any token.
+func LoadTokens(path, env string) (*Tokens, error) {
+	t := &Tokens{}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := t.parse(path, string(data)); err != nil {
+			return nil, err
+		}
+	}
+	if err := t.parse(env, os.Getenv(env)); err != nil {
+		return nil, err
+	}
+	if len(t.tokens) == 0 {
+		return nil, nil
+	}
+	return t, nil
+}
+
+// Authenticate returns the caller presenting secret. Every token is
+// compared in constant time, so the time taken does not reveal which of
+// them, or how much of one, matched.
+func (t *Tokens) Authenticate(secret string) (Caller, bool) {
+	digest := sha256.Sum256([]byte(secret))
+	var caller Caller
+	found := 0
+	for _, tok := range t.tokens {
+		match := subtle.ConstantTimeCompare(digest[:], tok.digest[:])
+		if match == 1 {
+			caller = tok.Caller
+		}
+		found |= match
+	}
+	return caller, found == 1
+}
+
+// Require authorizes requests carrying the bearer token of a caller with at
+// least role, and passes them to next with the caller in their context.
+// Requests without a valid token get 401 Unauthorized, callers with a lesser
+// role 403 Forbidden.
+func (t *Tokens) Require(role Role, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scheme, secret, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+		caller, ok := t.Authenticate(secret)
+		if !strings.EqualFold(scheme, "Bearer") || !ok {
+			log.Printf("[WARN] Rejected admin API request %s %s from %s: missing or invalid token", r.Method, r.URL.Path, r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="golem admin"`)
+			writeError(w, http.StatusUnauthorized, "missing or invalid bearer token")
+			return
+		}
+		if caller.Role < role {
+			log.Printf("[WARN] Rejected admin API request %s %s from %s: %s has the %s role, not %s", r.Method, r.URL.Path, r.RemoteAddr, caller.Name, caller.Role, role)
+			writeError(w, http.StatusForbidden, fmt.Sprintf("%s needs the %s role", caller.Name, role))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), caller)))
+	})
+}
+
+// writeError reports msg as JSON, like the admin API's own errors.
+func writeError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
+
+type contextKey struct{}
+
+// NewContext returns a context carrying caller.
+func NewContext(ctx context.Context, caller Caller) context.Context {
+	return context.WithValue(ctx, contextKey{}, caller)
+}
+
+// FromContext returns the caller stored in ctx by NewContext, if any.
+func FromContext(ctx context.Context) (Caller, bool) {
+	caller, ok := ctx.Value(contextKey{}).(Caller)
+	return caller, ok
+}
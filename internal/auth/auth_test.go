@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const (
+	viewerToken = "viewer-0123456789abcdef"
+	rootToken   = "root-0123456789abcdef"
+)
+
+func TestParseTokens(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries string
+		wantErr bool
+	}{
+		{"valid", "# callers\nviewer:read-only:" + viewerToken + "\n\nroot:admin:" + rootToken, false},
+		{"comma separated", "viewer:read-only:" + viewerToken + ", root:admin:" + rootToken, false},
+		{"token with colons", "viewer:read-only:a:b:c:0123456789abcdef", false},
+		{"missing role", "viewer:" + viewerToken, true},
+		{"unknown role", "viewer:superuser:" + viewerToken, true},
+		{"short token", "viewer:read-only:secret", true},
+		{"duplicate name", "viewer:read-only:" + viewerToken + "\nviewer:admin:" + rootToken, true},
+		{"duplicate token", "viewer:read-only:" + viewerToken + "\nroot:admin:" + viewerToken, true},
+	}
+	for _, tc := range tests {
+		err := (&Tokens{}).parse("tokens", tc.entries)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: expected error %t, got %v", tc.name, tc.wantErr, err)
+		}
+	}
+}
+
+func TestLoadTokensFromFileAndEnv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens")
+	if err := os.WriteFile(path, []byte("viewer:read-only:"+viewerToken+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_ADMIN_TOKENS", "root:admin:"+rootToken)
+	tokens, err := LoadTokens(path, "TEST_ADMIN_TOKENS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if caller, ok := tokens.Authenticate(viewerToken); !ok || caller != (Caller{"viewer", RoleReadOnly}) {
+		t.Errorf("expected the viewer, got %+v, %t", caller, ok)
+	}
+	if caller, ok := tokens.Authenticate(rootToken); !ok || caller != (Caller{"root", RoleAdmin}) {
+		t.Errorf("expected root, got %+v, %t", caller, ok)
+	}
+	if _, ok := tokens.Authenticate(rootToken[:len(rootToken)-1]); ok {
+		t.Error("expected a truncated token to be rejected")
+	}
+
+	t.Setenv("TEST_ADMIN_TOKENS", "")
+	if tokens, err := LoadTokens("", "TEST_ADMIN_TOKENS"); err != nil || tokens != nil {
+		t.Errorf("expected no tokens, got %v, %v", tokens, err)
+	}
+}
+
+func TestRequire(t *testing.T) {
+	tokens := &Tokens{}
+	if err := tokens.parse("tokens", "viewer:read-only:"+viewerToken+",root:admin:"+rootToken); err != nil {
+		t.Fatal(err)
+	}
+	var got Caller
+	h := tokens.Require(RoleOperator, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = FromContext(r.Context())
+	}))
+
+	tests := []struct {
+		name   string
+		header string
+		code   int
+	}{
+		{"no header", "", http.StatusUnauthorized},
+		{"basic auth", "Basic " + rootToken, http.StatusUnauthorized},
+		{"wrong token", "Bearer " + viewerToken + "x", http.StatusUnauthorized},
+		{"lesser role", "Bearer " + viewerToken, http.StatusForbidden},
+		{"greater role", "Bearer " + rootToken, http.StatusOK},
+		{"lowercase scheme", "bearer " + rootToken, http.StatusOK},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		if tc.header != "" {
+			req.Header.Set("Authorization", tc.header)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.code {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.code, rec.Code)
+		}
+		if tc.code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: expected a WWW-Authenticate challenge", tc.name)
+		}
+	}
+	if got != (Caller{"root", RoleAdmin}) {
+		t.Errorf("expected root in the request context, got %+v", got)
+	}
+}
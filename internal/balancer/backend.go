@@ -1,17 +1,56 @@
 package balancer
 
 import (
+	"fmt"
+	"net/http"
 	"sync"
+	"time"
 
 	"github.com/novaru/golem/internal/metrics"
 )
 
+// State is the administrative state of a backend. Operators set it
+// independently of health checks.
+type State string
+
+const (
+	// StateUp backends receive traffic while they are healthy.
+	StateUp State = "up"
+	// StateDraining backends receive no new traffic, except reconnects
+	// pinned to them, while the requests already on them finish.
+	StateDraining State = "draining"
+	// StateDown backends receive no traffic at all.
+	StateDown State = "down"
+)
+
+// ParseState returns the State named s.
+func ParseState(s string) (State, error) {
+	switch state := State(s); state {
+	case StateUp, StateDraining, StateDown:
+		return state, nil
+	}
+	return "", fmt.Errorf("unknown backend state: %q", s)
+}
+
+// responseTimeWeight is the weight of each new sample in the smoothed
+// response time, as in TCP's round-trip time estimate.
+const responseTimeWeight = 0.125
+
 // Backend represents a connection to a backend server.
 type Backend struct {
-	URL         string
-	healthy     bool
-	connections int
-	weight      int
+	URL string
+	// Transport carries requests to this backend. When nil, the proxy's
+	// shared transport is used.
+	Transport http.RoundTripper
+
+	healthy bool
+	// state is the administrative state; empty means StateUp.
+	state        State
+	connections  int
+	streams      int
+	weight       int
+	requests     uint64
+	responseTime time.Duration
 
 	mu sync.RWMutex
 }
@@ -41,6 +80,56 @@ func NewBackend(url string, weight int) *Backend {
 	}
 }
 
+// State returns the backend's administrative state.
+func (b *Backend) State() State {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.state == "" {
+		return StateUp
+	}
+	return b.state
+}
+
+// SetState changes the backend's administrative state.
+func (b *Backend) SetState(state State) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state = state
+}
+
+// Available reports whether the backend may be picked for new traffic: it
+// is healthy and up.
+func (b *Backend) Available() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.available()
+}
+
+func (b *Backend) available() bool {
+	return b.healthy && (b.state == "" || b.state == StateUp)
+}
+
+// Weight returns the backend's configured weight.
+func (b *Backend) Weight() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.weight
+}
+
+// share returns the backend's weight for picking it, treating unset
+// weights as 1. b.mu must be held.
+func (b *Backend) share() int {
+	return max(b.weight, 1)
+}
+
+// SetWeight changes the backend's configured weight. Round-robin balancers
+// read weights when they are built, so the pool must be updated as well.
+func (b *Backend) SetWeight(weight int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.weight = weight
+}
+
 // AddConnections increments the current connection count.
 func (b *Backend) AddConnections() {
 	b.mu.Lock()
@@ -63,3 +152,56 @@ func (b *Backend) GetConnections() int {
 	defer b.mu.RUnlock()
 	return b.connections
 }
+
+// AddStreams increments the count of long-lived streams, such as Server-Sent
+// Events, served by the backend. Streams are tracked apart from connections
+// so they do not dominate least-connection decisions.
+func (b *Backend) AddStreams() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.streams++
+}
+
+// RemoveStreams decrements the current stream count.
+func (b *Backend) RemoveStreams() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.streams > 0 {
+		b.streams--
+	}
+}
+
+// GetStreams returns the current number of long-lived streams.
+func (b *Backend) GetStreams() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.streams
+}
+
+// ObserveResponseTime records the time the backend took to respond to a
+// request.
+func (b *Backend) ObserveResponseTime(d time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.requests == 0 {
+		b.responseTime = d
+	} else {
+		b.responseTime += time.Duration(responseTimeWeight * float64(d-b.responseTime))
+	}
+	b.requests++
+}
+
+// ResponseTime returns the smoothed response time of the backend, or 0 if
+// it has not served a request yet.
+func (b *Backend) ResponseTime() time.Duration {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.responseTime
+}
+
+// Requests returns the number of requests the backend has responded to.
+func (b *Backend) Requests() uint64 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.requests
+}
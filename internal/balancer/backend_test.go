@@ -3,6 +3,7 @@ package balancer
 import (
 	"sync"
 	"testing"
+	"time"
 )
 
 func TestBackendConnectionsCount(t *testing.T) {
@@ -53,3 +54,62 @@ func TestBackendConcurrentAccess(t *testing.T) {
 
 	wg.Wait()
 }
+
+func TestBackendStreamsCountedSeparately(t *testing.T) {
+	b := NewBackend("http://example.com", 1)
+	b.AddConnections()
+	b.AddStreams()
+	b.AddStreams()
+
+	if b.GetStreams() != 2 {
+		t.Errorf("expected 2 streams, got %d", b.GetStreams())
+	}
+	if b.GetConnections() != 1 {
+		t.Errorf("expected streams not to affect connections, got %d", b.GetConnections())
+	}
+
+	b.RemoveStreams()
+	b.RemoveStreams()
+	b.RemoveStreams()
+	if b.GetStreams() != 0 {
+		t.Errorf("expected stream count not to go below 0, got %d", b.GetStreams())
+	}
+}
+
+func TestBackendStateExcludesFromBalancing(t *testing.T) {
+	a := NewBackend("http://a", 1)
+	b := NewBackend("http://b", 1)
+	for _, method := range []string{"roundrobin", "leastconn", "weighted"} {
+		bal, err := NewBalancer(method, []*Backend{a, b})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, state := range []State{StateDraining, StateDown} {
+			a.SetState(state)
+			for range 10 {
+				if next, err := bal.NextBackend(); err != nil || next != b {
+					t.Fatalf("%s: expected only b while a is %s, got %v, %v", method, state, next, err)
+				}
+			}
+		}
+		a.SetState(StateUp)
+	}
+	if _, err := ParseState("maintenance"); err == nil {
+		t.Error("expected an error for an unknown state")
+	}
+}
+
+func TestBackendResponseTime(t *testing.T) {
+	b := NewBackend("http://example.com", 1)
+	b.ObserveResponseTime(80 * time.Millisecond)
+	if got := b.ResponseTime(); got != 80*time.Millisecond {
+		t.Errorf("expected the first sample, got %v", got)
+	}
+	b.ObserveResponseTime(160 * time.Millisecond)
+	if got := b.ResponseTime(); got != 90*time.Millisecond {
+		t.Errorf("expected 90ms, got %v", got)
+	}
+	if b.Requests() != 2 {
+		t.Errorf("expected 2 requests, got %d", b.Requests())
+	}
+}
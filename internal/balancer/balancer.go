@@ -2,11 +2,20 @@ package balancer
 
 import (
 	"errors"
+	"time"
 )
 
 // Balancer interface for all load balancers
 type Balancer interface {
 	NextBackend() (*Backend, error)
+	// Backends returns all backends the balancer chooses from, healthy or not.
+	Backends() []*Backend
+}
+
+// ResponseTimeRecorder is implemented by balancers that choose backends by how
+// quickly they respond. The proxy reports each response time to them.
+type ResponseTimeRecorder interface {
+	RecordResponseTime(backend *Backend, responseTime time.Duration)
 }
 
 func NewBalancer(method string, backends []*Backend) (Balancer, error) {
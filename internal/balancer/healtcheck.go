@@ -1,10 +1,29 @@
 package balancer
 
 import (
+	"errors"
+	"net"
 	"net/http"
+	"strings"
 	"time"
 )
 
+// healthCheckTimeout bounds a single health check.
+const healthCheckTimeout = 2 * time.Second
+
+// udpProbeWait is how long a UDP health check waits for a port unreachable
+// error after its probe.
+const udpProbeWait = 500 * time.Millisecond
+
+// TCPScheme prefixes the addresses of backends that are proxied at the
+// connection level, e.g. "tcp://10.0.0.5:5432". They are health checked by
+// opening a TCP connection.
+const TCPScheme = "tcp://"
+
+// UDPScheme prefixes the addresses of backends that receive datagrams, e.g.
+// "udp://10.0.0.53:53". They are health checked by probing the port.
+const UDPScheme = "udp://"
+
 // HealthChecker periodically checks backend health.
 type HealthChecker struct {
 	Backends []*Backend
@@ -50,9 +69,20 @@ func (hc *HealthChecker) checkAll() {
 	}
 }
 
-// checkBackend checks the health of a single backend.
+// checkBackend checks the health of a single backend. The check goes through
+// the backend's own transport, so it uses the same TLS settings as proxied
+// requests. TCP and UDP backends are checked without HTTP.
 func (hc *HealthChecker) checkBackend(b *Backend) {
-	client := &http.Client{Timeout: 2 * time.Second}
+	if addr, ok := strings.CutPrefix(b.URL, TCPScheme); ok {
+		hc.checkTCPBackend(b, addr)
+		return
+	}
+	if addr, ok := strings.CutPrefix(b.URL, UDPScheme); ok {
+		hc.checkUDPBackend(b, addr)
+		return
+	}
+
+	client := &http.Client{Timeout: healthCheckTimeout, Transport: b.Transport}
 	resp, err := client.Get(b.URL + "/health")
 	if err != nil || resp.StatusCode >= 400 {
 		b.SetHealth(false)
@@ -63,3 +93,34 @@ func (hc *HealthChecker) checkBackend(b *Backend) {
 		resp.Body.Close()
 	}
 }
+
+// checkTCPBackend marks b healthy if a TCP connection to addr can be opened.
+func (hc *HealthChecker) checkTCPBackend(b *Backend, addr string) {
+	conn, err := net.DialTimeout("tcp", addr, healthCheckTimeout)
+	if err != nil {
+		b.SetHealth(false)
+		return
+	}
+	conn.Close()
+	b.SetHealth(true)
+}
+
+// checkUDPBackend sends an empty datagram to addr and waits briefly for an
+// answer. UDP has no handshake, so only an ICMP port unreachable, surfacing as
+// a refused connection, marks b unhealthy; silence is taken as healthy.
+func (hc *HealthChecker) checkUDPBackend(b *Backend, addr string) {
+	conn, err := net.DialTimeout("udp", addr, healthCheckTimeout)
+	if err != nil {
+		b.SetHealth(false)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write(nil); err != nil {
+		b.SetHealth(false)
+		return
+	}
+	conn.SetReadDeadline(time.Now().Add(udpProbeWait))
+	_, err = conn.Read(make([]byte, 1))
+	var netErr net.Error
+	b.SetHealth(err == nil || errors.As(err, &netErr) && netErr.Timeout())
+}
@@ -0,0 +1,82 @@
+package balancer
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHealthCheckUsesBackendTransport(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			t.Errorf("unexpected health check path %s", r.URL.Path)
+		}
+	}))
+	defer srv.Close()
+
+	// The test server's certificate is not in the system trust store, so
+	// only a check using the backend's transport can succeed.
+	trusted := NewBackend(srv.URL, 1)
+	trusted.Transport = srv.Client().Transport
+	untrusted := NewBackend(srv.URL, 1)
+
+	hc := NewHealthChecker([]*Backend{trusted, untrusted}, 0)
+	hc.checkBackend(trusted)
+	hc.checkBackend(untrusted)
+
+	if !trusted.IsHealthy() {
+		t.Error("expected backend checked over its own transport to be healthy")
+	}
+	if untrusted.IsHealthy() {
+		t.Error("expected backend with an untrusted certificate to be unhealthy")
+	}
+}
+
+func TestHealthCheckTCPConnect(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	up := NewBackend(TCPScheme+ln.Addr().String(), 1)
+
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	down := NewBackend(TCPScheme+closed.Addr().String(), 1)
+	closed.Close()
+
+	hc := NewHealthChecker([]*Backend{up, down}, 0)
+	hc.checkBackend(up)
+	hc.checkBackend(down)
+	ln.Close()
+
+	if !up.IsHealthy() {
+		t.Error("expected listening TCP backend to be healthy")
+	}
+	if down.IsHealthy() {
+		t.Error("expected closed TCP backend to be unhealthy")
+	}
+}
+
+func TestHealthCheckUDPProbe(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	up := NewBackend(UDPScheme+pc.LocalAddr().String(), 1)
+
+	closed, _ := net.ListenPacket("udp", "127.0.0.1:0")
+	down := NewBackend(UDPScheme+closed.LocalAddr().String(), 1)
+	closed.Close()
+
+	hc := NewHealthChecker([]*Backend{up, down}, 0)
+	hc.checkBackend(up)
+	hc.checkBackend(down)
+
+	if !up.IsHealthy() {
+		t.Error("expected silent UDP backend to be healthy")
+	}
+	if down.IsHealthy() {
+		t.Error("expected UDP backend with a closed port to be unhealthy")
+	}
+}
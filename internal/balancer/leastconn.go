@@ -2,7 +2,6 @@ package balancer
 
 import (
 	"errors"
-	"math"
 	"sync"
 )
 
@@ -26,18 +25,26 @@ func (l *LeastConnBalancer) NextBackend() (*Backend, error) {
 	}
 
 	var selected *Backend
-	minConnections := math.MaxInt
+	var minConnections, minStreams, minShare int
 
 	for _, b := range l.backends {
 		b.mu.RLock()
 
-		if !b.healthy {
+		if !b.available() {
 			b.mu.RUnlock()
 			continue
 		}
 
-		if b.connections < minConnections {
+		// Connections are compared relative to weight, so a backend of
+		// weight 2 takes twice the connections of one of weight 1. Long-lived
+		// streams only break ties.
+		share := b.share()
+		load, minLoad := b.connections*minShare, minConnections*share
+		if selected == nil || load < minLoad ||
+			(load == minLoad && b.streams < minStreams) {
 			minConnections = b.connections
+			minStreams = b.streams
+			minShare = share
 			selected = b
 		}
 
@@ -50,3 +57,8 @@ func (l *LeastConnBalancer) NextBackend() (*Backend, error) {
 
 	return selected, nil
 }
+
+// Backends returns the backends the balancer chooses from.
+func (l *LeastConnBalancer) Backends() []*Backend {
+	return l.backends
+}
@@ -170,3 +170,31 @@ func TestLeastConnBalancer_NextBackend(t *testing.T) {
 		}
 	})
 }
+
+func TestLeastConnBalancer_StreamsBreakTies(t *testing.T) {
+	busyStreams := NewBackend("http://streams.com", 1)
+	quiet := NewBackend("http://quiet.com", 1)
+	busyRequests := NewBackend("http://requests.com", 1)
+
+	for range 5 {
+		busyStreams.AddStreams()
+	}
+	busyRequests.AddConnections()
+
+	balancer := NewLeastConnBalancer([]*Backend{busyStreams, quiet, busyRequests})
+
+	selected, err := balancer.NextBackend()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if selected != quiet {
+		t.Errorf("expected the backend with no connections and no streams, got %s", selected.URL)
+	}
+
+	quiet.AddConnections()
+	quiet.AddConnections()
+	selected, _ = balancer.NextBackend()
+	if selected != busyStreams {
+		t.Errorf("expected streams not to count as connections, got %s", selected.URL)
+	}
+}
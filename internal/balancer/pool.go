@@ -0,0 +1,143 @@
+package balancer
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Pool groups a set of backends together with the balancer that picks
+// between them and the health checker that watches them.
+type Pool struct {
+	Name string
+	// Balancer picks between the pool's backends. It stays the same value
+	// across Update, so it can be held onto while the backends change.
+	Balancer Balancer
+
+	swap     *swapBalancer
+	interval time.Duration
+
+	mu       sync.Mutex
+	backends []*Backend
+	checker  *HealthChecker
+	running  bool
+}
+
+// NewPool creates a pool balancing across backends with the given method.
+// Health checks run at the given interval once Start is called.
+func NewPool(name, method string, backends []*Backend, interval time.Duration) (*Pool, error) {
+	bal, err := NewBalancer(method, backends)
+	if err != nil {
+		return nil, err
+	}
+	swap := &swapBalancer{}
+	swap.current.Store(&bal)
+	return &Pool{
+		Name:     name,
+		Balancer: swap,
+		swap:     swap,
+		interval: interval,
+		backends: backends,
+		checker:  NewHealthChecker(backends, interval),
+	}, nil
+}
+
+// Backends returns the pool's current backends.
+func (p *Pool) Backends() []*Backend {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.backends
+}
+
+// Backend returns the pool's backend with the given URL, or nil.
+func (p *Pool) Backend(url string) *Backend {
+	for _, b := range p.Backends() {
+		if b.URL == url {
+			return b
+		}
+	}
+	return nil
+}
+
+// Update replaces the pool's backends and balancing method while it serves
+// traffic. Backends carried over from the current set keep their health,
+// connection counts and response time statistics. Requests already sent to
+// removed backends are unaffected; the removed backends are returned so the
+// caller can wait for them to drain.
+func (p *Pool) Update(method string, backends []*Backend) ([]*Backend, error) {
+	next, err := NewBalancer(method, backends)
+	if err != nil {
+		return nil, err
+	}
+	prev := p.swap.load()
+	if w, ok := next.(*WeightedResponseTimeBalancer); ok {
+		if pw, ok := prev.(*WeightedResponseTimeBalancer); ok {
+			w.inherit(pw)
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.swap.current.Store(&next)
+
+	kept := make(map[*Backend]bool, len(backends))
+	for _, b := range backends {
+		kept[b] = true
+	}
+	var removed []*Backend
+	for _, b := range p.backends {
+		if !kept[b] {
+			removed = append(removed, b)
+		}
+	}
+	p.backends = backends
+
+	checker := NewHealthChecker(backends, p.interval)
+	if p.running {
+		p.checker.Stop()
+		checker.Start()
+	}
+	p.checker = checker
+	return removed, nil
+}
+
+// Start begins health checking the pool's backends.
+func (p *Pool) Start() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.checker.Start()
+	p.running = true
+}
+
+// Stop stops health checking the pool's backends.
+func (p *Pool) Stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.checker.Stop()
+	p.running = false
+}
+
+// swapBalancer forwards to a balancer that can be replaced while in use.
+type swapBalancer struct {
+	current atomic.Pointer[Balancer]
+}
+
+func (s *swapBalancer) load() Balancer {
+	return *s.current.Load()
+}
+
+func (s *swapBalancer) NextBackend() (*Backend, error) {
+	return s.load().NextBackend()
+}
+
+func (s *swapBalancer) Backends() []*Backend {
+	return s.load().Backends()
+}
+
+// RecordResponseTime passes the response time on to the current balancer, if
+// it records them.
+func (s *swapBalancer) RecordResponseTime(backend *Backend, responseTime time.Duration) {
+	if rec, ok := s.load().(ResponseTimeRecorder); ok {
+		rec.RecordResponseTime(backend, responseTime)
+	}
+}
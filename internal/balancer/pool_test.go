@@ -0,0 +1,80 @@
+package balancer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewPool(t *testing.T) {
+	backends := []*Backend{
+		NewBackend("http://a", 1),
+		NewBackend("http://b", 1),
+	}
+
+	pool, err := NewPool("shadow", "roundrobin", backends, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pool.Name != "shadow" {
+		t.Errorf("expected pool name shadow, got %s", pool.Name)
+	}
+	if len(pool.Backends()) != 2 {
+		t.Errorf("expected 2 backends, got %d", len(pool.Backends()))
+	}
+	if b, err := pool.Balancer.NextBackend(); err != nil || b == nil {
+		t.Errorf("expected a backend from the pool balancer, got err=%v", err)
+	}
+
+	if _, err := NewPool("bad", "unknown", backends, time.Second); err == nil {
+		t.Error("expected error for unknown method")
+	}
+}
+
+func TestPoolUpdateKeepsBackends(t *testing.T) {
+	a, b := NewBackend("http://a", 1), NewBackend("http://b", 1)
+	pool, _ := NewPool("web", "roundrobin", []*Backend{a, b}, time.Hour)
+	bal := pool.Balancer
+	a.AddConnections()
+
+	c := NewBackend("http://c", 2)
+	removed, err := pool.Update("leastconn", []*Backend{a, c})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(removed) != 1 || removed[0] != b {
+		t.Errorf("expected b to be removed, got %v", removed)
+	}
+	if pool.Backend("http://a") != a || a.GetConnections() != 1 {
+		t.Error("expected backend a to be kept with its connections")
+	}
+	if pool.Balancer != bal {
+		t.Error("expected the pool balancer to stay the same")
+	}
+	// Least connections now prefers c over the busy a.
+	for range 4 {
+		if next, _ := bal.NextBackend(); next != c {
+			t.Fatalf("expected c, got %s", next.URL)
+		}
+	}
+
+	if _, err := pool.Update("unknown", []*Backend{a}); err == nil {
+		t.Error("expected error for unknown method")
+	}
+	if len(pool.Backends()) != 2 {
+		t.Errorf("expected a failed update to keep 2 backends, got %d", len(pool.Backends()))
+	}
+}
+
+func TestPoolUpdateKeepsResponseTimes(t *testing.T) {
+	a := NewBackend("http://a", 1)
+	pool, _ := NewPool("web", "weighted", []*Backend{a}, time.Hour)
+	pool.swap.load().(*WeightedResponseTimeBalancer).RecordResponseTime(a, 40*time.Millisecond)
+
+	if _, err := pool.Update("weighted", []*Backend{a, NewBackend("http://b", 1)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	w := pool.swap.load().(*WeightedResponseTimeBalancer)
+	if got := w.GetAverageResponseTime(a); got != 40*time.Millisecond {
+		t.Errorf("expected a's average of 40ms to carry over, got %v", got)
+	}
+}
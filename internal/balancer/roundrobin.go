@@ -8,28 +8,82 @@ import (
 // RoundRobinBalancer implements a round-robin load balancer.
 type RoundRobinBalancer struct {
 	backends []*Backend
+	// schedule is one cycle of picks, in which each backend appears as
+	// often as its weight. With equal weights it is backends itself.
+	schedule []*Backend
 	index    uint64
 }
 
-// NewRoundRobinBalancer creates a new RoundRobinBalancer with the provided backends.
+// NewRoundRobinBalancer creates a new RoundRobinBalancer with the provided
+// backends. Their weights are read once, here; the pool builds a new
+// balancer when they change.
 func NewRoundRobinBalancer(backends []*Backend) *RoundRobinBalancer {
-	return &RoundRobinBalancer{backends: backends}
+	return &RoundRobinBalancer{backends: backends, schedule: weightedSchedule(backends)}
 }
 
-// NextBackend returns the next healthy backend in a round-robin fashion
+// NextBackend returns the next available backend in a round-robin fashion
 // (this will forward requests cyclically between servers and skip over
-// unhealthy backends). If no healthy backends are available, it returns nil.
+// unhealthy or drained backends). Each backend gets as many turns per cycle
+// as its weight. If no healthy backends are available, it returns nil.
 func (r *RoundRobinBalancer) NextBackend() (*Backend, error) {
-	n := len(r.backends)
+	n := len(r.schedule)
 	if n == 0 {
 		return nil, errors.New("no backends provided")
 	}
 	for range n {
-		idx := int(atomic.AddUint64(&r.index, 1)) % n
-		next := r.backends[idx]
-		if next.IsHealthy() {
+		idx := int(atomic.AddUint64(&r.index, 1) % uint64(n))
+		next := r.schedule[idx]
+		if next.Available() {
 			return next, nil
 		}
 	}
 	return nil, errors.New("no healthy backends available")
 }
+
+// Backends returns the backends the balancer chooses from.
+func (r *RoundRobinBalancer) Backends() []*Backend {
+	return r.backends
+}
+
+// weightedSchedule spreads the turns of backends over one cycle with smooth
+// weighted round robin, so a heavy backend's turns are interleaved with the
+// others' rather than taken in a row.
+func weightedSchedule(backends []*Backend) []*Backend {
+	weights := make([]int, len(backends))
+	divisor, equal := 0, true
+	for i, b := range backends {
+		weights[i] = max(b.Weight(), 1)
+		divisor = gcd(divisor, weights[i])
+		equal = equal && weights[i] == weights[0]
+	}
+	if equal {
+		return backends
+	}
+
+	total := 0
+	for i := range weights {
+		weights[i] /= divisor
+		total += weights[i]
+	}
+	schedule := make([]*Backend, 0, total)
+	current := make([]int, len(backends))
+	for range total {
+		best := 0
+		for i, w := range weights {
+			current[i] += w
+			if current[i] > current[best] {
+				best = i
+			}
+		}
+		current[best] -= total
+		schedule = append(schedule, backends[best])
+	}
+	return schedule
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
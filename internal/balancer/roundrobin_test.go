@@ -210,3 +210,55 @@ func TestRoundRobinHealthToggling(t *testing.T) {
 		t.Error("Expected both backends to be used after health recovery")
 	}
 }
+
+func TestRoundRobinByWeight(t *testing.T) {
+	backends := []*Backend{
+		NewBackend("http://a", 3),
+		NewBackend("http://b", 1),
+	}
+
+	rr := NewRoundRobinBalancer(backends)
+	counts := make(map[string]int)
+	got := []string{}
+	for range 40 {
+		b, _ := rr.NextBackend()
+		if b == nil {
+			t.Fatal("NextBackend() returned nil")
+		}
+		counts[b.URL]++
+		got = append(got, b.URL)
+	}
+	if counts["http://a"] != 30 || counts["http://b"] != 10 {
+		t.Errorf("expected a 30/10 split for weights 3 and 1, got %v", counts)
+	}
+	// Smooth weighting interleaves b with a instead of sending a's three
+	// turns in a row.
+	expectedURL := []string{"http://a", "http://b", "http://a", "http://a", "http://a", "http://b"}
+	for i := range expectedURL {
+		if got[i] != expectedURL[i] {
+			t.Errorf("weighted round robin failed at %d: got %s, expected %s", i, got[i], expectedURL[i])
+		}
+	}
+
+	// A balancer built after a weight change follows the new weights.
+	backends[1].SetWeight(3)
+	rr = NewRoundRobinBalancer(backends)
+	clear(counts)
+	for range 60 {
+		b, _ := rr.NextBackend()
+		counts[b.URL]++
+	}
+	if counts["http://a"] != 30 || counts["http://b"] != 30 {
+		t.Errorf("expected an even split after the weight change, got %v", counts)
+	}
+}
+
+func TestRoundRobinDoesNotAllocate(t *testing.T) {
+	rr := NewRoundRobinBalancer([]*Backend{
+		NewBackend("http://a", 2),
+		NewBackend("http://b", 1),
+	})
+	if allocs := testing.AllocsPerRun(100, func() { rr.NextBackend() }); allocs != 0 {
+		t.Errorf("expected NextBackend not to allocate, got %v allocations", allocs)
+	}
+}
@@ -60,7 +60,7 @@ func (w *WeightedResponseTimeBalancer) NextBackend() (*Backend, error) {
 
 	// Collect healthy backends and calculate their weights
 	for _, backend := range w.backends {
-		if backend.IsHealthy() {
+		if backend.Available() {
 			healthyBackends = append(healthyBackends, backend)
 			weight := w.calculateWeight(backend)
 			weights = append(weights, weight)
@@ -75,8 +75,14 @@ func (w *WeightedResponseTimeBalancer) NextBackend() (*Backend, error) {
 	return w.selectByWeight(healthyBackends, weights), nil
 }
 
-// calculateWeight calculates the weight for a backend based on its response time
+// calculateWeight calculates the weight for a backend based on its response
+// time, scaled by its configured weight.
 func (w *WeightedResponseTimeBalancer) calculateWeight(backend *Backend) float64 {
+	return w.responseTimeWeight(backend) * float64(max(backend.Weight(), 1))
+}
+
+// responseTimeWeight weighs a backend by its response time alone.
+func (w *WeightedResponseTimeBalancer) responseTimeWeight(backend *Backend) float64 {
 	tracker := w.responseTimes[backend]
 
 	// If no requests have been made, give it a high default weight
@@ -132,6 +138,21 @@ func (w *WeightedResponseTimeBalancer) selectByWeight(backends []*Backend, weigh
 	return backends[len(backends)-1]
 }
 
+// inherit copies prev's response time statistics for the backends both
+// balancers share.
+func (w *WeightedResponseTimeBalancer) inherit(prev *WeightedResponseTimeBalancer) {
+	prev.mutex.RLock()
+	defer prev.mutex.RUnlock()
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	for backend := range w.responseTimes {
+		if tracker, ok := prev.responseTimes[backend]; ok {
+			copied := *tracker
+			w.responseTimes[backend] = &copied
+		}
+	}
+}
+
 // GetWeight returns the current weight for a backend (for testing)
 func (w *WeightedResponseTimeBalancer) GetWeight(backend *Backend) float64 {
 	w.mutex.RLock()
@@ -186,3 +207,8 @@ func (w *WeightedResponseTimeBalancer) SetSeed(seed int64) {
 	defer w.rngMutex.Unlock()
 	w.rng = rand.New(rand.NewSource(seed))
 }
+
+// Backends returns the backends the balancer chooses from.
+func (w *WeightedResponseTimeBalancer) Backends() []*Backend {
+	return w.backends
+}
@@ -0,0 +1,322 @@
+// Package handoff passes listening sockets to a new process, so that golem
+// can be upgraded to a new binary without refusing connections. The new
+// process inherits the sockets as file descriptors, accepts on them, and
+// tells the old one when it is ready; the old process then drains and exits.
+package handoff
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/novaru/golem/internal/reuseport"
+)
+
+// Environment variables describing the inherited descriptors. Sockets start
+// at descriptor 3, in the order of their keys.
+const (
+	envSockets = "GOLEM_HANDOFF_SOCKETS"
+	envReady   = "GOLEM_HANDOFF_READY"
+)
+
+// firstFD is the first descriptor after stdin, stdout and stderr.
+const firstFD = 3
+
+// DefaultReadyTimeout bounds the wait for a new process to become ready.
+const DefaultReadyTimeout = 30 * time.Second
+
+// filer is implemented by the sockets that can be handed off, such as
+// *net.TCPListener and *net.UDPConn.
+type filer interface {
+	File() (*os.File, error)
+}
+
+// Sockets opens listening sockets, reusing those inherited from a previous
+// process, and hands them to the next one on Upgrade.
+type Sockets struct {
+	// ReadyTimeout bounds the wait for the next process in Upgrade.
+	// Default 30 seconds.
+	ReadyTimeout time.Duration
+
+	// upgraded is set when the process was started by Upgrade.
+	upgraded bool
+
+	mu        sync.Mutex
+	inherited map[string]*os.File
+	ready     *os.File
+	// open holds the sockets in use, by key, to pass on.
+	open      map[string]filer
+	upgrading bool
+}
+
+// New returns the Sockets of this process, taking over the descriptors
+// passed by the previous process if it was started by Upgrade.
+func New() (*Sockets, error) {
+	s := &Sockets{
+		inherited: make(map[string]*os.File),
+		open:      make(map[string]filer),
+	}
+	if keys := os.Getenv(envSockets); keys != "" {
+		for i, key := range strings.Split(keys, ",") {
+			s.inherited[key] = os.NewFile(uintptr(firstFD+i), key)
+		}
+	}
+	if v := os.Getenv(envReady); v != "" {
+		fd, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("handoff: invalid %s: %q", envReady, v)
+		}
+		s.ready = os.NewFile(uintptr(fd), "ready")
+		s.upgraded = true
+	}
+	os.Unsetenv(envSockets)
+	os.Unsetenv(envReady)
+	return s, nil
+}
+
+// Inherited reports whether the process took over sockets from a previous
+// one.
+func (s *Sockets) Inherited() bool {
+	return s.upgraded
+}
+
+func key(network, addr string) string {
+	return network + "/" + addr
+}
+
+// Listen returns a listener for addr, inherited if the previous process
+// passed one for the same network and address.
+func (s *Sockets) Listen(network, addr string) (net.Listener, error) {
+	return s.listen(key(network, addr), func() (net.Listener, error) {
+		return net.Listen(network, addr)
+	})
+}
+
+// ListenReusePort returns n listeners sharing addr through SO_REUSEPORT,
+// inheriting those the previous process passed.
+func (s *Sockets) ListenReusePort(network, addr string, n int) ([]net.Listener, error) {
+	lns := make([]net.Listener, 0, n)
+	for i := range n {
+		ln, err := s.listen(fmt.Sprintf("%s#%d", key(network, addr), i), func() (net.Listener, error) {
+			return reuseport.Listen(network, addr)
+		})
+		if err != nil {
+			for _, ln := range lns {
+				ln.Close()
+			}
+			return nil, err
+		}
+		lns = append(lns, ln)
+	}
+	return lns, nil
+}
+
+func (s *Sockets) listen(k string, open func() (net.Listener, error)) (net.Listener, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ln net.Listener
+	var err error
+	if f, ok := s.inherited[k]; ok {
+		delete(s.inherited, k)
+		ln, err = net.FileListener(f)
+		f.Close()
+	} else {
+		ln, err = open()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if f, ok := ln.(filer); ok {
+		s.open[k] = f
+	}
+	return ln, nil
+}
+
+// ListenPacket returns a packet socket for addr, inherited if the previous
+// process passed one for the same network and address.
+func (s *Sockets) ListenPacket(network, addr string) (net.PacketConn, error) {
+	return s.listenPacket(key(network, addr), func() (net.PacketConn, error) {
+		return net.ListenPacket(network, addr)
+	})
+}
+
+// ListenPacketReusePort returns n packet sockets sharing addr through
+// SO_REUSEPORT, inheriting those the previous process passed.
+func (s *Sockets) ListenPacketReusePort(network, addr string, n int) ([]net.PacketConn, error) {
+	pcs := make([]net.PacketConn, 0, n)
+	for i := range n {
+		pc, err := s.listenPacket(fmt.Sprintf("%s#%d", key(network, addr), i), func() (net.PacketConn, error) {
+			return reuseport.ListenPacket(network, addr)
+		})
+		if err != nil {
+			for _, pc := range pcs {
+				pc.Close()
+			}
+			return nil, err
+		}
+		pcs = append(pcs, pc)
+	}
+	return pcs, nil
+}
+
+func (s *Sockets) listenPacket(k string, open func() (net.PacketConn, error)) (net.PacketConn, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var pc net.PacketConn
+	var err error
+	if f, ok := s.inherited[k]; ok {
+		delete(s.inherited, k)
+		pc, err = net.FilePacketConn(f)
+		f.Close()
+	} else {
+		pc, err = open()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if f, ok := pc.(filer); ok {
+		s.open[k] = f
+	}
+	return pc, nil
+}
+
+// Ready tells the previous process, if any, that this one serves every
+// socket, and closes the inherited sockets no listener asked for.
+func (s *Sockets) Ready() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, f := range s.inherited {
+		f.Close()
+		delete(s.inherited, k)
+	}
+	if s.ready == nil {
+		return nil
+	}
+	_, err := s.ready.Write([]byte{1})
+	s.ready.Close()
+	s.ready = nil
+	return err
+}
+
+// Upgrade starts the current executable again with the same arguments,
+// passing it every open socket, and waits until it calls Ready. On error the
+// new process is stopped and this one keeps serving.
+func (s *Sockets) Upgrade() error {
+	s.mu.Lock()
+	if s.upgrading {
+		s.mu.Unlock()
+		return errors.New("handoff: upgrade already in progress")
+	}
+	s.upgrading = true
+	files, keys, err := s.files()
+	s.mu.Unlock()
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+		s.mu.Lock()
+		s.upgrading = false
+		s.mu.Unlock()
+	}()
+	if err != nil {
+		return err
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("handoff: %w", err)
+	}
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("handoff: %w", err)
+	}
+	defer readyR.Close()
+
+	// exec.Cmd would put the sockets into blocking mode through File.Fd,
+	// which they share with this process' listeners, so the raw
+	// descriptors are passed instead.
+	fds := []uintptr{0, 1, 2}
+	for _, f := range append(files, readyW) {
+		fd, err := rawFD(f)
+		if err != nil {
+			readyW.Close()
+			return fmt.Errorf("handoff: %w", err)
+		}
+		fds = append(fds, fd)
+	}
+	env := append(environ(),
+		envSockets+"="+strings.Join(keys, ","),
+		envReady+"="+strconv.Itoa(firstFD+len(files)),
+	)
+	pid, err := syscall.ForkExec(exe, os.Args, &syscall.ProcAttr{Env: env, Files: fds})
+	readyW.Close()
+	if err != nil {
+		return fmt.Errorf("handoff: starting %s: %w", exe, err)
+	}
+
+	timeout := s.ReadyTimeout
+	if timeout <= 0 {
+		timeout = DefaultReadyTimeout
+	}
+	readyR.SetReadDeadline(time.Now().Add(timeout))
+	if _, err := io.ReadFull(readyR, make([]byte, 1)); err != nil {
+		if p, ferr := os.FindProcess(pid); ferr == nil {
+			p.Kill()
+			p.Wait()
+		}
+		if errors.Is(err, io.EOF) {
+			return fmt.Errorf("handoff: process %d exited before becoming ready", pid)
+		}
+		return fmt.Errorf("handoff: waiting for process %d: %w", pid, err)
+	}
+	return nil
+}
+
+// rawFD returns the descriptor of f without changing its blocking mode.
+func rawFD(f *os.File) (uintptr, error) {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var fd uintptr
+	if err := rc.Control(func(v uintptr) { fd = v }); err != nil {
+		return 0, err
+	}
+	return fd, nil
+}
+
+// files duplicates the open sockets for the next process. s.mu must be held.
+func (s *Sockets) files() ([]*os.File, []string, error) {
+	var files []*os.File
+	var keys []string
+	for k, f := range s.open {
+		file, err := f.File()
+		if err != nil {
+			for _, f := range files {
+				f.Close()
+			}
+			return nil, nil, fmt.Errorf("handoff: %s: %w", k, err)
+		}
+		files = append(files, file)
+		keys = append(keys, k)
+	}
+	return files, keys, nil
+}
+
+// environ returns the environment without handoff variables.
+func environ() []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, envSockets+"=") && !strings.HasPrefix(kv, envReady+"=") {
+			env = append(env, kv)
+		}
+	}
+	return env
+}
@@ -0,0 +1,143 @@
+package handoff
+
+import (
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// Test processes started by Upgrade run as children instead of the tests.
+const (
+	envTestAddr = "HANDOFF_TEST_ADDR"
+	envTestFail = "HANDOFF_TEST_FAIL"
+)
+
+func TestMain(m *testing.M) {
+	if os.Getenv(envSockets) != "" {
+		runChild()
+		return
+	}
+	os.Exit(m.Run())
+}
+
+// runChild takes over the listener, reports ready and answers a single
+// connection with "child".
+func runChild() {
+	if os.Getenv(envTestFail) != "" {
+		os.Exit(1)
+	}
+	s, err := New()
+	if err != nil {
+		os.Exit(2)
+	}
+	ln, err := s.Listen("tcp", os.Getenv(envTestAddr))
+	if err != nil || !s.Inherited() {
+		os.Exit(3)
+	}
+	s.Ready()
+	conn, err := ln.Accept()
+	if err != nil {
+		os.Exit(4)
+	}
+	io.WriteString(conn, "child")
+	conn.Close()
+	os.Exit(0)
+}
+
+// serve answers every connection on ln with name.
+func serve(ln net.Listener, name string) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		io.WriteString(conn, name)
+		conn.Close()
+	}
+}
+
+func greeting(t *testing.T, addr string) string {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	b, _ := io.ReadAll(conn)
+	return string(b)
+}
+
+func TestUpgradeHandsOverListener(t *testing.T) {
+	const addr = "127.0.0.1:0"
+	t.Setenv(envTestAddr, addr)
+	s, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := s.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go serve(ln, "parent")
+	bound := ln.Addr().String()
+	if got := greeting(t, bound); got != "parent" {
+		t.Fatalf("expected parent, got %q", got)
+	}
+
+	if err := s.Upgrade(); err != nil {
+		t.Fatalf("upgrade failed: %v", err)
+	}
+	// Once the parent stops accepting, the child serves the same port.
+	ln.Close()
+	if got := greeting(t, bound); got != "child" {
+		t.Errorf("expected child, got %q", got)
+	}
+}
+
+func TestUpgradeFailsWhenChildExits(t *testing.T) {
+	t.Setenv(envTestFail, "1")
+	s, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := s.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	if err := s.Upgrade(); err == nil {
+		t.Fatal("expected an error when the new process exits")
+	}
+	// The parent keeps its listener.
+	go serve(ln, "parent")
+	if got := greeting(t, ln.Addr().String()); got != "parent" {
+		t.Errorf("expected parent, got %q", got)
+	}
+}
+
+func TestListenReusePortSharesAddress(t *testing.T) {
+	s, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lns, err := s.ListenReusePort("tcp", "127.0.0.1:0", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lns[0].Close()
+	addr := lns[0].Addr().String()
+	more, err := s.ListenReusePort("tcp", addr, 2)
+	if err != nil {
+		t.Fatalf("expected more listeners on %s: %v", addr, err)
+	}
+	for _, ln := range more {
+		ln.Close()
+	}
+	if len(s.open) != 3 {
+		t.Errorf("expected 3 sockets to hand off, got %d", len(s.open))
+	}
+}
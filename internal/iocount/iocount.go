@@ -0,0 +1,22 @@
+// Package iocount counts the bytes relayed between the two ends of a proxied
+// connection.
+package iocount
+
+import "io"
+
+// Writer adds every byte written to W to Counter and calls Touch to report
+// activity for idle timeout tracking.
+type Writer struct {
+	W       io.Writer
+	Counter interface{ Add(float64) }
+	Touch   func()
+}
+
+func (cw *Writer) Write(p []byte) (int, error) {
+	n, err := cw.W.Write(p)
+	if n > 0 {
+		cw.Counter.Add(float64(n))
+		cw.Touch()
+	}
+	return n, err
+}
@@ -0,0 +1,24 @@
+package iocount
+
+import (
+	"bytes"
+	"testing"
+)
+
+type counter float64
+
+func (c *counter) Add(v float64) { *c += counter(v) }
+
+func TestWriterCountsAndTouches(t *testing.T) {
+	var buf bytes.Buffer
+	var c counter
+	touches := 0
+	w := &Writer{W: &buf, Counter: &c, Touch: func() { touches++ }}
+
+	w.Write([]byte("hello"))
+	w.Write(nil)
+	w.Write([]byte("!"))
+	if buf.String() != "hello!" || c != 6 || touches != 2 {
+		t.Errorf("got %q, %v bytes counted and %d touches", buf.String(), c, touches)
+	}
+}
@@ -79,6 +79,208 @@ var (
 		},
 		[]string{"backend"},
 	)
+
+	ShadowRequestsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "golem_shadow_requests_total",
+			Help: "Total number of mirrored requests sent to the shadow pool",
+		},
+		[]string{"backend", "method", "status"},
+	)
+
+	ShadowRequestDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "golem_shadow_request_duration_seconds",
+			Help:    "Shadow request duration in seconds",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"backend", "method"},
+	)
+
+	ShadowRequestFailures = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "golem_shadow_request_failures_total",
+			Help: "Number of mirrored requests that failed or were skipped",
+		},
+		[]string{"backend", "method", "reason"},
+	)
+
+	ShadowActiveRequests = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "golem_shadow_active_requests",
+			Help: "Current number of mirrored requests in flight per shadow backend",
+		},
+		[]string{"backend"},
+	)
+
+	UpgradedConnections = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "golem_upgraded_connections",
+			Help: "Current number of upgraded (e.g. WebSocket) connections per backend",
+		},
+		[]string{"backend"},
+	)
+
+	UpgradedBytes = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "golem_upgraded_bytes_total",
+			Help: "Bytes transferred over upgraded connections",
+		},
+		[]string{"backend", "direction"}, // direction: to_backend/to_client
+	)
+
+	SSEStreams = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "golem_sse_streams",
+			Help: "Current number of Server-Sent Events streams per backend",
+		},
+		[]string{"backend"},
+	)
+
+	SSEHeartbeats = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "golem_sse_heartbeats_total",
+			Help: "Number of heartbeat comments sent on idle Server-Sent Events streams",
+		},
+		[]string{"backend"},
+	)
+
+	TransportDials = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "golem_transport_dials_total",
+			Help: "Number of new connections dialed to each backend",
+		},
+		[]string{"backend", "result"}, // result: success/error
+	)
+
+	TransportOpenConns = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "golem_transport_open_connections",
+			Help: "Current number of open pooled connections to each backend",
+		},
+		[]string{"backend"},
+	)
+
+	TransportConnsUsed = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "golem_transport_connections_used_total",
+			Help: "Connections handed out for backend requests, by whether they were reused from the pool",
+		},
+		[]string{"backend", "reused"},
+	)
+
+	GRPCRequestsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "golem_grpc_requests_total",
+			Help: "Total number of proxied gRPC calls by service, method and status code",
+		},
+		[]string{"service", "method", "code"},
+	)
+
+	GRPCRequestDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "golem_grpc_request_duration_seconds",
+			Help:    "gRPC call duration in seconds, including the response stream",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"service", "method"},
+	)
+
+	TLSHandshakeFailures = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "golem_tls_handshake_failures_total",
+			Help: "Number of failed client TLS handshakes per listener",
+		},
+		[]string{"listener", "reason"},
+	)
+
+	TLSCertNotAfter = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "golem_tls_cert_not_after_timestamp_seconds",
+			Help: "Expiry (NotAfter) of each served TLS certificate as a Unix timestamp in seconds; subtract time() for the time left",
+		},
+		[]string{"cert_file", "subject"},
+	)
+
+	TLSCertReloads = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "golem_tls_cert_reloads_total",
+			Help: "Number of TLS certificate reloads",
+		},
+		[]string{"result"}, // result: success/error
+	)
+
+	ConfigReloads = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "golem_config_reloads_total",
+			Help: "Number of configuration reloads",
+		},
+		[]string{"result"}, // result: success/error
+	)
+
+	ClientCertRejections = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "golem_client_cert_rejections_total",
+			Help: "Number of requests rejected by a route's client certificate policy",
+		},
+		[]string{"route", "reason"},
+	)
+
+	TCPConnections = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "golem_tcp_connections_total",
+			Help: "Number of client connections accepted by tcp listeners, by backend and outcome",
+		},
+		[]string{"backend", "result"}, // result: success/no_backend/backend_unavailable/bad_client_hello/unknown_sni
+	)
+
+	TCPBytes = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "golem_tcp_bytes_total",
+			Help: "Bytes relayed by tcp listeners",
+		},
+		[]string{"backend", "direction"}, // direction: to_backend/to_client
+	)
+
+	ProxyProtocolHeaders = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "golem_proxy_protocol_headers_total",
+			Help: "PROXY protocol headers read from trusted peers, by listener and outcome",
+		},
+		[]string{"listener", "result"}, // result: v1/v2/missing/invalid/timeout
+	)
+
+	SniffedConnections = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "golem_sniffed_connections_total",
+			Help: "Connections accepted by sniff mode listeners, by detected protocol",
+		},
+		[]string{"listener", "protocol"}, // protocol: http/tls/tcp
+	)
+
+	UDPSessions = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "golem_udp_sessions_total",
+			Help: "Number of client sessions started by udp listeners, by backend and outcome",
+		},
+		[]string{"backend", "result"}, // result: success/no_backend/backend_unavailable
+	)
+
+	UDPPackets = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "golem_udp_packets_total",
+			Help: "Datagrams relayed by udp listeners",
+		},
+		[]string{"backend", "direction"}, // direction: to_backend/to_client
+	)
+
+	UDPBytes = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "golem_udp_bytes_total",
+			Help: "Payload bytes relayed by udp listeners",
+		},
+		[]string{"backend", "direction"}, // direction: to_backend/to_client
+	)
 )
 
 func UpdateBackendHealth(backend string, healthy bool) {
@@ -89,6 +291,13 @@ func UpdateBackendHealth(backend string, healthy bool) {
 	BackendHealth.WithLabelValues(backend).Set(value)
 }
 
+// DeleteBackend removes the series of a backend that is no longer
+// configured.
+func DeleteBackend(backend string) {
+	BackendHealth.DeleteLabelValues(backend)
+	ActiveConnections.DeleteLabelValues(backend)
+}
+
 func UpdateActiveConnections(backend string, conn float64) {
 	ActiveConnections.WithLabelValues(backend).Set(conn)
 }
@@ -98,6 +307,24 @@ func RecordRequest(backend, method, status string, duration float64) {
 	RequestDuration.WithLabelValues(backend, method).Observe(duration)
 }
 
+func RecordShadowRequest(backend, method, status string, duration float64) {
+	ShadowRequestsTotal.WithLabelValues(backend, method, status).Inc()
+	ShadowRequestDuration.WithLabelValues(backend, method).Observe(duration)
+}
+
+func RecordGRPCRequest(service, method, code string, duration float64) {
+	GRPCRequestsTotal.WithLabelValues(service, method, code).Inc()
+	GRPCRequestDuration.WithLabelValues(service, method).Observe(duration)
+}
+
+// SetTLSCertNotAfter records the expiry, as a Unix timestamp in seconds, of
+// the certificate loaded from certFile, replacing the series of any
+// certificate previously loaded from it.
+func SetTLSCertNotAfter(certFile, subject string, notAfter float64) {
+	TLSCertNotAfter.DeletePartialMatch(prometheus.Labels{"cert_file": certFile})
+	TLSCertNotAfter.WithLabelValues(certFile, subject).Set(notAfter)
+}
+
 func SetLoadBalancerInfo(version, method string) {
 	LoadBalancerInfo.WithLabelValues(version, method).Set(1)
 }
@@ -0,0 +1,229 @@
+// Package proxyproto reads and writes PROXY protocol headers, which L4 load
+// balancers put in front of a connection to carry the original client
+// address. Both the text format (v1) and the binary format (v2) are supported.
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// Protocol versions.
+const (
+	V1 = 1
+	V2 = 2
+)
+
+// v2Signature starts every v2 header.
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// v1MaxLength is the longest v1 header, including the CRLF.
+const v1MaxLength = 107
+
+// ErrNoHeader is returned when a connection does not start with a PROXY
+// protocol header.
+var ErrNoHeader = errors.New("proxyproto: no PROXY protocol header")
+
+// Header is a PROXY protocol header.
+type Header struct {
+	// Version is the format the header was read in, V1 or V2.
+	Version int
+	// Source and Destination are the addresses of the client connection
+	// the header describes. They are invalid when the sender gave none,
+	// such as for its own health checks.
+	Source      netip.AddrPort
+	Destination netip.AddrPort
+}
+
+// HeaderFor describes a connection from src to dst. Addresses that are not
+// TCP or UDP addresses are left out.
+func HeaderFor(src, dst net.Addr) *Header {
+	return &Header{Source: addrPort(src), Destination: addrPort(dst)}
+}
+
+func addrPort(addr net.Addr) netip.AddrPort {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.AddrPort()
+	case *net.UDPAddr:
+		return a.AddrPort()
+	}
+	return netip.AddrPort{}
+}
+
+// hasAddrs reports whether h describes a connection.
+func (h *Header) hasAddrs() bool {
+	return h.Source.IsValid() && h.Destination.IsValid()
+}
+
+// ipv4 reports whether both addresses fit the IPv4 family.
+func (h *Header) ipv4() bool {
+	return h.Source.Addr().Unmap().Is4() && h.Destination.Addr().Unmap().Is4()
+}
+
+// Format encodes h in the given version. Headers without addresses are sent
+// as "UNKNOWN" in v1 and as a LOCAL command in v2.
+func (h *Header) Format(version int) []byte {
+	if version == V1 {
+		return h.formatV1()
+	}
+	return h.formatV2()
+}
+
+func (h *Header) formatV1() []byte {
+	if !h.hasAddrs() {
+		return []byte("PROXY UNKNOWN\r\n")
+	}
+	family, src, dst := "TCP6", h.Source.Addr(), h.Destination.Addr()
+	if h.ipv4() {
+		family, src, dst = "TCP4", src.Unmap(), dst.Unmap()
+	}
+	return fmt.Appendf(nil, "PROXY %s %s %s %d %d\r\n", family, src, dst, h.Source.Port(), h.Destination.Port())
+}
+
+func (h *Header) formatV2() []byte {
+	b := append([]byte{}, v2Signature...)
+	if !h.hasAddrs() {
+		// LOCAL command, unspecified family.
+		return append(b, 0x20, 0x00, 0, 0)
+	}
+	var addrs []byte
+	family := byte(0x21) // TCP over IPv6
+	if h.ipv4() {
+		family = 0x11 // TCP over IPv4
+		src, dst := h.Source.Addr().Unmap().As4(), h.Destination.Addr().Unmap().As4()
+		addrs = append(append(addrs, src[:]...), dst[:]...)
+	} else {
+		src, dst := h.Source.Addr().As16(), h.Destination.Addr().As16()
+		addrs = append(append(addrs, src[:]...), dst[:]...)
+	}
+	addrs = binary.BigEndian.AppendUint16(addrs, h.Source.Port())
+	addrs = binary.BigEndian.AppendUint16(addrs, h.Destination.Port())
+
+	b = append(b, 0x21, family) // PROXY command
+	b = binary.BigEndian.AppendUint16(b, uint16(len(addrs)))
+	return append(b, addrs...)
+}
+
+// Read parses the header at the start of r, leaving r positioned at the data
+// that follows it. It returns ErrNoHeader if r does not start with one.
+func Read(r *bufio.Reader) (*Header, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	switch first[0] {
+	case 'P':
+		return readV1(r)
+	case v2Signature[0]:
+		return readV2(r)
+	}
+	return nil, ErrNoHeader
+}
+
+func readV1(r *bufio.Reader) (*Header, error) {
+	var line []byte
+	for len(line) < v1MaxLength {
+		c, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, c)
+		if c == '\n' {
+			break
+		}
+	}
+	rest, ok := bytes.CutPrefix(line, []byte("PROXY "))
+	if !ok {
+		return nil, ErrNoHeader
+	}
+	rest, ok = bytes.CutSuffix(rest, []byte("\r\n"))
+	if !ok {
+		return nil, errors.New("proxyproto: v1 header is too long or not terminated by CRLF")
+	}
+
+	fields := strings.Split(string(rest), " ")
+	h := &Header{Version: V1}
+	if fields[0] == "UNKNOWN" {
+		return h, nil
+	}
+	if len(fields) != 5 || fields[0] != "TCP4" && fields[0] != "TCP6" {
+		return nil, fmt.Errorf("proxyproto: malformed v1 header: %q", rest)
+	}
+	var err error
+	if h.Source, err = parseV1Addr(fields[1], fields[3], fields[0]); err != nil {
+		return nil, err
+	}
+	if h.Destination, err = parseV1Addr(fields[2], fields[4], fields[0]); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func parseV1Addr(ip, port, family string) (netip.AddrPort, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil || addr.Is4() != (family == "TCP4") {
+		return netip.AddrPort{}, fmt.Errorf("proxyproto: invalid %s address: %q", family, ip)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || port[0] == '0' && len(port) > 1 {
+		return netip.AddrPort{}, fmt.Errorf("proxyproto: invalid port: %q", port)
+	}
+	return netip.AddrPortFrom(addr, uint16(p)), nil
+}
+
+func readV2(r *bufio.Reader) (*Header, error) {
+	fixed := make([]byte, len(v2Signature)+4)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(fixed[:len(v2Signature)], v2Signature) {
+		return nil, ErrNoHeader
+	}
+	verCmd, family := fixed[12], fixed[13]
+	length := int(binary.BigEndian.Uint16(fixed[14:]))
+	if verCmd>>4 != 2 {
+		return nil, fmt.Errorf("proxyproto: unsupported version %d", verCmd>>4)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	h := &Header{Version: V2}
+	switch verCmd & 0x0f {
+	case 0x0: // LOCAL: the sender's own connection, addresses are ignored.
+		return h, nil
+	case 0x1: // PROXY
+	default:
+		return nil, fmt.Errorf("proxyproto: unsupported command %d", verCmd&0x0f)
+	}
+
+	// Both TCP (x1) and UDP (x2) transports carry the same address block;
+	// TLVs after it are skipped.
+	switch family >> 4 {
+	case 0x1:
+		if length < 12 {
+			return nil, errors.New("proxyproto: truncated IPv4 addresses")
+		}
+		h.Source = netip.AddrPortFrom(netip.AddrFrom4([4]byte(payload[0:4])), binary.BigEndian.Uint16(payload[8:]))
+		h.Destination = netip.AddrPortFrom(netip.AddrFrom4([4]byte(payload[4:8])), binary.BigEndian.Uint16(payload[10:]))
+	case 0x2:
+		if length < 36 {
+			return nil, errors.New("proxyproto: truncated IPv6 addresses")
+		}
+		h.Source = netip.AddrPortFrom(netip.AddrFrom16([16]byte(payload[0:16])), binary.BigEndian.Uint16(payload[32:]))
+		h.Destination = netip.AddrPortFrom(netip.AddrFrom16([16]byte(payload[16:32])), binary.BigEndian.Uint16(payload[34:]))
+	}
+	// Unix socket and unspecified families keep the connection's own
+	// addresses.
+	return h, nil
+}
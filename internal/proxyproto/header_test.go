@@ -0,0 +1,104 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestHeaderRoundTrip(t *testing.T) {
+	headers := map[string]*Header{
+		"ipv4": {
+			Source:      netip.MustParseAddrPort("203.0.113.7:51234"),
+			Destination: netip.MustParseAddrPort("10.0.0.1:443"),
+		},
+		"ipv6": {
+			Source:      netip.MustParseAddrPort("[2001:db8::7]:51234"),
+			Destination: netip.MustParseAddrPort("[2001:db8::1]:443"),
+		},
+		"local": {},
+	}
+	for name, h := range headers {
+		for _, version := range []int{V1, V2} {
+			data := append(h.Format(version), "GET / HTTP/1.1\r\n"...)
+			r := bufio.NewReader(bytes.NewReader(data))
+			got, err := Read(r)
+			if err != nil {
+				t.Fatalf("%s v%d: %v", name, version, err)
+			}
+			if got.Version != version || got.Source != h.Source || got.Destination != h.Destination {
+				t.Errorf("%s v%d: got %+v, want %+v", name, version, got, h)
+			}
+			if rest, _ := io.ReadAll(r); string(rest) != "GET / HTTP/1.1\r\n" {
+				t.Errorf("%s v%d: data after header = %q", name, version, rest)
+			}
+		}
+	}
+}
+
+func TestFormatV1(t *testing.T) {
+	h := &Header{
+		Source:      netip.MustParseAddrPort("[::ffff:203.0.113.7]:51234"),
+		Destination: netip.MustParseAddrPort("10.0.0.1:443"),
+	}
+	if got := string(h.Format(V1)); got != "PROXY TCP4 203.0.113.7 10.0.0.1 51234 443\r\n" {
+		t.Errorf("unexpected v1 header %q", got)
+	}
+	if got := string((&Header{}).Format(V1)); got != "PROXY UNKNOWN\r\n" {
+		t.Errorf("unexpected v1 header without addresses %q", got)
+	}
+}
+
+func TestReadV2SkipsTLVs(t *testing.T) {
+	payload := []byte{203, 0, 113, 7, 10, 0, 0, 1}
+	payload = binary.BigEndian.AppendUint16(payload, 51234)
+	payload = binary.BigEndian.AppendUint16(payload, 443)
+	payload = append(payload, 0x04, 0x00, 0x02, 'h', 'i') // a NOOP TLV
+
+	data := append([]byte{}, v2Signature...)
+	data = append(data, 0x21, 0x11)
+	data = binary.BigEndian.AppendUint16(data, uint16(len(payload)))
+	data = append(append(data, payload...), "hello"...)
+
+	r := bufio.NewReader(bytes.NewReader(data))
+	h, err := Read(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Source != netip.MustParseAddrPort("203.0.113.7:51234") {
+		t.Errorf("unexpected source %v", h.Source)
+	}
+	if rest, _ := io.ReadAll(r); string(rest) != "hello" {
+		t.Errorf("data after header = %q", rest)
+	}
+}
+
+func TestReadRejectsInvalidHeaders(t *testing.T) {
+	tests := map[string]string{
+		"plain http":    "GET / HTTP/1.1\r\n",
+		"not proxy":     "POST / HTTP/1.1\r\n",
+		"bad family":    "PROXY TCP5 1.2.3.4 5.6.7.8 1 2\r\n",
+		"family clash":  "PROXY TCP6 1.2.3.4 5.6.7.8 1 2\r\n",
+		"bad port":      "PROXY TCP4 1.2.3.4 5.6.7.8 1 70000\r\n",
+		"missing field": "PROXY TCP4 1.2.3.4 5.6.7.8 1\r\n",
+		"no crlf":       "PROXY TCP4 1.2.3.4 5.6.7.8 1 2\n",
+		"too long":      "PROXY TCP4 " + strings.Repeat("1", 120) + "\r\n",
+		"v2 version":    string(v2Signature) + "\x11\x11\x00\x00",
+		"v2 truncated":  string(v2Signature) + "\x21\x11\x00\x04abcd",
+	}
+	for name, data := range tests {
+		_, err := Read(bufio.NewReader(strings.NewReader(data)))
+		if err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	if _, err := Read(bufio.NewReader(strings.NewReader("GET /"))); !errors.Is(err, ErrNoHeader) {
+		t.Errorf("expected ErrNoHeader, got %v", err)
+	}
+}
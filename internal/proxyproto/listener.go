@@ -0,0 +1,179 @@
+package proxyproto
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/novaru/golem/internal/metrics"
+)
+
+// defaultHeaderTimeout bounds reading a header.
+const defaultHeaderTimeout = 5 * time.Second
+
+// Listener accepts PROXY protocol headers from trusted peers. Connections
+// from Trusted addresses must start with a header, whose source address then
+// becomes the connection's RemoteAddr. Connections from anyone else are
+// returned untouched, so clients cannot spoof their address.
+type Listener struct {
+	net.Listener
+	// Name labels the listener's metrics.
+	Name    string
+	Trusted []netip.Prefix
+	// HeaderTimeout bounds reading the header. Default 5 seconds.
+	HeaderTimeout time.Duration
+}
+
+// Accept returns the next connection. The header of a trusted peer is read
+// on first use of the connection, so a slow peer does not hold up Accept.
+func (l *Listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.trusted(c.RemoteAddr()) {
+		return c, nil
+	}
+	timeout := l.HeaderTimeout
+	if timeout <= 0 {
+		timeout = defaultHeaderTimeout
+	}
+	return &Conn{Conn: c, reader: bufio.NewReader(c), name: l.Name, timeout: timeout}, nil
+}
+
+func (l *Listener) trusted(addr net.Addr) bool {
+	ap := addrPort(addr)
+	if !ap.IsValid() {
+		return false
+	}
+	ip := ap.Addr().Unmap()
+	for _, prefix := range l.Trusted {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Conn is a connection that started with a PROXY protocol header.
+type Conn struct {
+	net.Conn
+	reader  *bufio.Reader
+	name    string
+	timeout time.Duration
+
+	once   sync.Once
+	header *Header
+	err    error
+
+	// readDeadline is the deadline set by the user of the connection,
+	// restored once the header is read.
+	mu           sync.Mutex
+	readDeadline time.Time
+}
+
+// readHeader reads the header once. An invalid or missing header fails
+// every read.
+func (c *Conn) readHeader() {
+	c.once.Do(func() {
+		c.mu.Lock()
+		deadline := time.Now().Add(c.timeout)
+		if !c.readDeadline.IsZero() && c.readDeadline.Before(deadline) {
+			deadline = c.readDeadline
+		}
+		c.Conn.SetReadDeadline(deadline)
+		c.mu.Unlock()
+
+		c.header, c.err = Read(c.reader)
+
+		c.mu.Lock()
+		c.Conn.SetReadDeadline(c.readDeadline)
+		c.mu.Unlock()
+
+		result := "invalid"
+		switch {
+		case c.err == nil && c.header.Version == V1:
+			result = "v1"
+		case c.err == nil:
+			result = "v2"
+		case errors.Is(c.err, os.ErrDeadlineExceeded):
+			result = "timeout"
+		case errors.Is(c.err, ErrNoHeader):
+			result = "missing"
+		}
+		metrics.ProxyProtocolHeaders.WithLabelValues(c.name, result).Inc()
+	})
+}
+
+// Header returns the connection's header, reading it if needed.
+func (c *Conn) Header() (*Header, error) {
+	c.readHeader()
+	return c.header, c.err
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	if c.readHeader(); c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(p)
+}
+
+// RemoteAddr returns the client address from the header, or the peer's
+// address if the header carries none.
+func (c *Conn) RemoteAddr() net.Addr {
+	if c.readHeader(); c.err == nil && c.header.hasAddrs() {
+		return net.TCPAddrFromAddrPort(c.header.Source)
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the address the client connected to according to the
+// header, or the local address if the header carries none.
+func (c *Conn) LocalAddr() net.Addr {
+	if c.readHeader(); c.err == nil && c.header.hasAddrs() {
+		return net.TCPAddrFromAddrPort(c.header.Destination)
+	}
+	return c.Conn.LocalAddr()
+}
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	return c.Conn.SetReadDeadline(t)
+}
+
+// CloseWrite shuts down the write half of the underlying connection, if it
+// supports it.
+func (c *Conn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+type contextKey struct{}
+
+// NewContext returns a context carrying h, for dialers that send the header
+// of the client a backend connection is made for.
+func NewContext(ctx context.Context, h *Header) context.Context {
+	return context.WithValue(ctx, contextKey{}, h)
+}
+
+// FromContext returns the header stored in ctx by NewContext, if any.
+func FromContext(ctx context.Context) (*Header, bool) {
+	h, ok := ctx.Value(contextKey{}).(*Header)
+	return h, ok
+}
@@ -0,0 +1,108 @@
+package proxyproto
+
+import (
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+// acceptOne listens on loopback and returns the address and a channel
+// delivering the first accepted connection.
+func acceptOne(t *testing.T, trusted ...netip.Prefix) (string, <-chan net.Conn) {
+	t.Helper()
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln := &Listener{Listener: inner, Name: "test", Trusted: trusted, HeaderTimeout: time.Second}
+	t.Cleanup(func() { ln.Close() })
+	conns := make(chan net.Conn, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err == nil {
+			conns <- c
+		}
+	}()
+	return inner.Addr().String(), conns
+}
+
+func send(t *testing.T, addr, data string) net.Conn {
+	t.Helper()
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	io.WriteString(c, data)
+	return c
+}
+
+var loopback = netip.MustParsePrefix("127.0.0.0/8")
+
+func TestListenerTrustedPeer(t *testing.T) {
+	addr, conns := acceptOne(t, loopback)
+	send(t, addr, "PROXY TCP4 203.0.113.7 10.0.0.1 51234 443\r\nhello")
+	c := <-conns
+	defer c.Close()
+
+	if got := c.RemoteAddr().String(); got != "203.0.113.7:51234" {
+		t.Errorf("RemoteAddr = %s, want the client from the header", got)
+	}
+	if got := c.LocalAddr().String(); got != "10.0.0.1:443" {
+		t.Errorf("LocalAddr = %s, want the destination from the header", got)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(c, buf); err != nil || string(buf) != "hello" {
+		t.Errorf("read %q, %v after header", buf, err)
+	}
+}
+
+func TestListenerTrustedPeerWithoutHeader(t *testing.T) {
+	addr, conns := acceptOne(t, loopback)
+	send(t, addr, "GET / HTTP/1.1\r\n\r\n")
+	c := <-conns
+	defer c.Close()
+
+	if _, err := c.Read(make([]byte, 1)); err == nil {
+		t.Error("expected reads to fail without a header")
+	}
+	if _, ok := c.RemoteAddr().(*net.TCPAddr); !ok {
+		t.Errorf("expected the peer address, got %v", c.RemoteAddr())
+	}
+}
+
+func TestListenerUntrustedPeer(t *testing.T) {
+	addr, conns := acceptOne(t, netip.MustParsePrefix("10.0.0.0/8"))
+	header := "PROXY TCP4 203.0.113.7 10.0.0.1 51234 443\r\n"
+	send(t, addr, header)
+	c := <-conns
+	defer c.Close()
+
+	if _, ok := c.(*Conn); ok {
+		t.Fatal("expected an untrusted connection to be returned as is")
+	}
+	buf := make([]byte, len(header))
+	if _, err := io.ReadFull(c, buf); err != nil || string(buf) != header {
+		t.Errorf("expected the header to reach the application as data, got %q, %v", buf, err)
+	}
+}
+
+func TestConnHeaderTimeoutKeepsUserDeadline(t *testing.T) {
+	addr, conns := acceptOne(t, loopback)
+	client := send(t, addr, "")
+	c := <-conns
+	defer c.Close()
+
+	// The user's earlier deadline cuts the header wait short.
+	c.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	start := time.Now()
+	if _, err := c.Read(make([]byte, 1)); err == nil {
+		t.Fatal("expected the header read to time out")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("header wait ignored the read deadline: %v", elapsed)
+	}
+	client.Close()
+}
@@ -0,0 +1,12 @@
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package reuseport
+
+import (
+	"errors"
+	"syscall"
+)
+
+func control(network, address string, c syscall.RawConn) error {
+	return errors.New("reuseport: SO_REUSEPORT is not supported on this platform")
+}
@@ -0,0 +1,21 @@
+// Package reuseport opens sockets with SO_REUSEPORT, which lets several of
+// them bind the same address. The kernel then spreads incoming connections
+// and datagrams across them, so each can be served by its own goroutine.
+package reuseport
+
+import (
+	"context"
+	"net"
+)
+
+var listenConfig = net.ListenConfig{Control: control}
+
+// Listen is net.Listen with SO_REUSEPORT set on the socket.
+func Listen(network, addr string) (net.Listener, error) {
+	return listenConfig.Listen(context.Background(), network, addr)
+}
+
+// ListenPacket is net.ListenPacket with SO_REUSEPORT set on the socket.
+func ListenPacket(network, addr string) (net.PacketConn, error) {
+	return listenConfig.ListenPacket(context.Background(), network, addr)
+}
@@ -0,0 +1,76 @@
+package reuseport
+
+import (
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// listenN opens n listeners sharing one local port.
+func listenN(t *testing.T, n int) []net.Listener {
+	t.Helper()
+	first, err := Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lns := []net.Listener{first}
+	for range n - 1 {
+		ln, err := Listen("tcp", first.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		lns = append(lns, ln)
+	}
+	t.Cleanup(func() {
+		for _, ln := range lns {
+			ln.Close()
+		}
+	})
+	return lns
+}
+
+func TestListenSharesPort(t *testing.T) {
+	lns := listenN(t, 2)
+	var accepted [2]atomic.Int64
+	for i, ln := range lns {
+		go func() {
+			for {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				accepted[i].Add(1)
+				conn.Close()
+			}
+		}()
+	}
+
+	// The kernel hashes each connection to one of the sockets.
+	for range 64 {
+		conn, err := net.Dial("tcp", lns[0].Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.ReadAll(conn)
+		conn.Close()
+	}
+	time.Sleep(50 * time.Millisecond)
+	if accepted[0].Load() == 0 || accepted[1].Load() == 0 {
+		t.Errorf("expected both listeners to accept, got %d and %d", accepted[0].Load(), accepted[1].Load())
+	}
+}
+
+func TestListenPacketSharesPort(t *testing.T) {
+	first, err := ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	second, err := ListenPacket("udp", first.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("expected a second socket on the port: %v", err)
+	}
+	second.Close()
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/novaru/golem/internal/tlsutil"
+)
+
+// ClientCertHeaders names the headers that carry the identity of a verified
+// client certificate to backends. Headers with an empty name are not set.
+// The named headers are always removed from client requests first, so
+// clients cannot claim an identity they did not prove.
+type ClientCertHeaders struct {
+	Subject     string
+	SAN         string
+	Fingerprint string
+}
+
+// Apply sets the identity headers on out, the header of the request sent to
+// the backend, from the certificate the client presented on r.
+func (h *ClientCertHeaders) Apply(out http.Header, r *http.Request) {
+	for _, name := range []string{h.Subject, h.SAN, h.Fingerprint} {
+		if name != "" {
+			out.Del(name)
+		}
+	}
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	if h.Subject != "" {
+		out.Set(h.Subject, cert.Subject.String())
+	}
+	if h.SAN != "" {
+		if sans := tlsutil.SANs(cert); len(sans) > 0 {
+			out.Set(h.SAN, strings.Join(sans, ", "))
+		}
+	}
+	if h.Fingerprint != "" {
+		out.Set(h.Fingerprint, tlsutil.Fingerprint(cert))
+	}
+}
+
+// checkClientCert applies a route's client certificate policy to r. It
+// returns the rejection reason, or "" if the request may proceed.
+func checkClientCert(r *http.Request, policy *tlsutil.CertPolicy) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return tlsutil.ReasonCertMissing
+	}
+	if policy.Check(r.TLS.VerifiedChains[0][0]) != nil {
+		return tlsutil.ReasonCertDenied
+	}
+	return ""
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/novaru/golem/internal/balancer"
+	"github.com/novaru/golem/internal/tlsutil"
+)
+
+// newClientCert creates a self-signed client certificate.
+func newClientCert(t *testing.T, cn string, dnsNames ...string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestProxyClientCertRoute(t *testing.T) {
+	var gotSubject, gotSAN, gotFingerprint string
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotSubject = r.Header.Get("X-Client-Subject")
+		gotSAN = r.Header.Get("X-Client-SAN")
+		gotFingerprint = r.Header.Get("X-Client-Fingerprint")
+	}))
+	defer backendServer.Close()
+
+	allowed := newClientCert(t, "billing", "billing.internal")
+	denied := newClientCert(t, "intruder")
+
+	bal, _ := balancer.NewBalancer("roundrobin", []*balancer.Backend{
+		balancer.NewBackend(backendServer.URL, 1),
+	})
+	ps := NewProxyServer(bal)
+	ps.Routes = []*Route{
+		{Name: "internal", PathPrefix: "/internal", ClientCert: &tlsutil.CertPolicy{
+			Allow: []tlsutil.CertMatcher{{SAN: "*.internal"}},
+		}},
+		{Name: "public"},
+	}
+	ps.ClientCertHeaders = &ClientCertHeaders{
+		Subject:     "X-Client-Subject",
+		SAN:         "X-Client-SAN",
+		Fingerprint: "X-Client-Fingerprint",
+	}
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(allowed.Leaf)
+	clientCAs.AddCert(denied.Leaf)
+	front := httptest.NewUnstartedServer(ps)
+	front.TLS = &tls.Config{ClientAuth: tls.VerifyClientCertIfGiven, ClientCAs: clientCAs}
+	front.StartTLS()
+	defer front.Close()
+
+	get := func(path string, cert *tls.Certificate) int {
+		t.Helper()
+		transport := front.Client().Transport.(*http.Transport).Clone()
+		if cert != nil {
+			transport.TLSClientConfig.Certificates = []tls.Certificate{*cert}
+		}
+		req, _ := http.NewRequest("GET", front.URL+path, nil)
+		req.Header.Set("X-Client-Subject", "CN=spoofed")
+		resp, err := (&http.Client{Transport: transport}).Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := get("/internal/report", &allowed); code != http.StatusOK {
+		t.Errorf("expected allowed certificate to pass, got %d", code)
+	}
+	if gotSubject != "CN=billing" || gotSAN != "billing.internal" || gotFingerprint != tlsutil.Fingerprint(allowed.Leaf) {
+		t.Errorf("unexpected identity headers: subject=%q san=%q fingerprint=%q", gotSubject, gotSAN, gotFingerprint)
+	}
+
+	if code := get("/internal/report", &denied); code != http.StatusForbidden {
+		t.Errorf("expected denied certificate to get 403, got %d", code)
+	}
+	if code := get("/internal/report", nil); code != http.StatusForbidden {
+		t.Errorf("expected request without certificate to get 403, got %d", code)
+	}
+
+	if code := get("/public", nil); code != http.StatusOK {
+		t.Errorf("expected public route to pass without certificate, got %d", code)
+	}
+	if gotSubject != "" {
+		t.Errorf("expected spoofed identity header to be removed, got %q", gotSubject)
+	}
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Forwarding sets the headers that tell backends about the original client:
+// X-Forwarded-For, X-Forwarded-Proto and X-Forwarded-Host, and/or the RFC 7239
+// Forwarded header.
+//
+// Values already present on the request are only kept (and appended to) when
+// the immediate peer is one of TrustedProxies. Otherwise they are replaced, so
+// clients cannot spoof their address by sending the headers themselves.
+type Forwarding struct {
+	TrustedProxies []netip.Prefix
+
+	// XForwarded emits the de-facto X-Forwarded-* headers.
+	XForwarded bool
+	// Forwarded emits the RFC 7239 Forwarded header.
+	Forwarded bool
+}
+
+// NewForwarding returns a Forwarding that emits the X-Forwarded-* headers and
+// trusts no upstream proxies.
+func NewForwarding() *Forwarding {
+	return &Forwarding{XForwarded: true}
+}
+
+// Apply sets the forwarding headers on out, the header of the request sent to
+// the backend, based on the client request r.
+func (f *Forwarding) Apply(out http.Header, r *http.Request) {
+	ip := clientIP(r)
+	proto := requestScheme(r)
+
+	if !f.isTrusted(ip) {
+		out.Del("X-Forwarded-For")
+		out.Del("X-Forwarded-Proto")
+		out.Del("X-Forwarded-Host")
+		out.Del("Forwarded")
+	}
+
+	if f.XForwarded {
+		if prior := out.Values("X-Forwarded-For"); len(prior) > 0 {
+			out.Set("X-Forwarded-For", strings.Join(prior, ", ")+", "+ip)
+		} else {
+			out.Set("X-Forwarded-For", ip)
+		}
+		if out.Get("X-Forwarded-Proto") == "" {
+			out.Set("X-Forwarded-Proto", proto)
+		}
+		if out.Get("X-Forwarded-Host") == "" {
+			out.Set("X-Forwarded-Host", r.Host)
+		}
+	}
+
+	if f.Forwarded {
+		element := "for=" + forwardedNode(ip) + ";host=" + quoteForwarded(r.Host) + ";proto=" + proto
+		if prior := out.Values("Forwarded"); len(prior) > 0 {
+			out.Set("Forwarded", strings.Join(prior, ", ")+", "+element)
+		} else {
+			out.Set("Forwarded", element)
+		}
+	}
+}
+
+// isTrusted reports whether ip belongs to one of the trusted proxy ranges.
+func (f *Forwarding) isTrusted(ip string) bool {
+	if len(f.TrustedProxies) == 0 {
+		return false
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range f.TrustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestScheme returns the scheme the client used to reach golem.
+func requestScheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// forwardedNode formats an IP address as an RFC 7239 node. IPv6 addresses
+// must be bracketed and therefore quoted.
+func forwardedNode(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return quoteForwarded(ip)
+	}
+	if addr.Is6() && !addr.Is4In6() {
+		return `"[` + addr.String() + `]"`
+	}
+	return addr.Unmap().String()
+}
+
+// quoteForwarded quotes value if it is not a valid RFC 7230 token, as
+// required for Forwarded parameter values.
+func quoteForwarded(value string) string {
+	for _, c := range value {
+		if !isTokenChar(c) {
+			return `"` + strings.ReplaceAll(strings.ReplaceAll(value, `\`, `\\`), `"`, `\"`) + `"`
+		}
+	}
+	return value
+}
+
+func isTokenChar(c rune) bool {
+	if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' {
+		return true
+	}
+	return strings.ContainsRune("!#$%&'*+-.^_`|~", c)
+}
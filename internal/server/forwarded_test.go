@@ -0,0 +1,111 @@
+package server
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/novaru/golem/internal/balancer"
+)
+
+func TestForwardingUntrustedPeerReplacesHeaders(t *testing.T) {
+	f := NewForwarding()
+
+	req := httptest.NewRequest("GET", "http://shop.example.com/", nil)
+	req.RemoteAddr = "203.0.113.5:40000"
+	req.Header.Set("X-Forwarded-For", "1.2.3.4")
+	req.Header.Set("X-Forwarded-Host", "spoofed.example.com")
+
+	out := req.Header.Clone()
+	f.Apply(out, req)
+
+	if got := out.Get("X-Forwarded-For"); got != "203.0.113.5" {
+		t.Errorf("expected X-Forwarded-For to be replaced, got %q", got)
+	}
+	if got := out.Get("X-Forwarded-Host"); got != "shop.example.com" {
+		t.Errorf("expected X-Forwarded-Host shop.example.com, got %q", got)
+	}
+	if got := out.Get("X-Forwarded-Proto"); got != "http" {
+		t.Errorf("expected X-Forwarded-Proto http, got %q", got)
+	}
+	if out.Get("Forwarded") != "" {
+		t.Error("Forwarded header should not be emitted in x-forwarded mode")
+	}
+}
+
+func TestForwardingTrustedPeerAppends(t *testing.T) {
+	f := NewForwarding()
+	f.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+
+	req := httptest.NewRequest("GET", "http://internal:8000/", nil)
+	req.RemoteAddr = "10.1.2.3:5555"
+	req.Header.Add("X-Forwarded-For", "198.51.100.1")
+	req.Header.Add("X-Forwarded-For", "192.0.2.44")
+	req.Header.Set("X-Forwarded-Proto", "https")
+	req.Header.Set("X-Forwarded-Host", "www.example.com")
+
+	out := req.Header.Clone()
+	f.Apply(out, req)
+
+	if got := out.Get("X-Forwarded-For"); got != "198.51.100.1, 192.0.2.44, 10.1.2.3" {
+		t.Errorf("unexpected X-Forwarded-For: %q", got)
+	}
+	if got := out.Get("X-Forwarded-Proto"); got != "https" {
+		t.Errorf("expected trusted X-Forwarded-Proto to be kept, got %q", got)
+	}
+	if got := out.Get("X-Forwarded-Host"); got != "www.example.com" {
+		t.Errorf("expected trusted X-Forwarded-Host to be kept, got %q", got)
+	}
+}
+
+func TestForwardingRFC7239(t *testing.T) {
+	f := &Forwarding{
+		Forwarded:      true,
+		TrustedProxies: []netip.Prefix{netip.MustParsePrefix("2001:db8::/32")},
+	}
+
+	req := httptest.NewRequest("GET", "https://api.example.com/", nil)
+	req.TLS = &tls.ConnectionState{}
+	req.RemoteAddr = "[2001:db8::7]:443"
+	req.Header.Set("Forwarded", "for=192.0.2.60;proto=http")
+	req.Header.Set("X-Forwarded-For", "192.0.2.60")
+
+	out := req.Header.Clone()
+	f.Apply(out, req)
+
+	want := `for=192.0.2.60;proto=http, for="[2001:db8::7]";host=api.example.com;proto=https`
+	if got := out.Get("Forwarded"); got != want {
+		t.Errorf("Forwarded = %q, want %q", got, want)
+	}
+	if got := out.Get("X-Forwarded-For"); got != "192.0.2.60" {
+		t.Errorf("X-Forwarded-For from a trusted proxy should pass through unchanged, got %q", got)
+	}
+}
+
+func TestProxySetsForwardingHeaders(t *testing.T) {
+	var received http.Header
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+	}))
+	defer backendServer.Close()
+
+	bal, _ := balancer.NewBalancer("roundrobin", []*balancer.Backend{
+		balancer.NewBackend(backendServer.URL, 1),
+	})
+	proxy := NewProxyServer(bal)
+	proxy.Forwarding.Forwarded = true
+
+	req := httptest.NewRequest("GET", "http://front.example.com/", nil)
+	req.RemoteAddr = "192.0.2.10:1234"
+	rr := httptest.NewRecorder()
+	proxy.ServeHTTP(rr, req)
+
+	if received.Get("X-Forwarded-For") != "192.0.2.10" {
+		t.Errorf("expected X-Forwarded-For on backend request, got %q", received.Get("X-Forwarded-For"))
+	}
+	if received.Get("Forwarded") != "for=192.0.2.10;host=front.example.com;proto=http" {
+		t.Errorf("unexpected Forwarded header: %q", received.Get("Forwarded"))
+	}
+}
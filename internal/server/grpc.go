@@ -0,0 +1,158 @@
+package server
+
+import (
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/novaru/golem/internal/metrics"
+)
+
+// gRPC status codes, as defined in google.golang.org/grpc/codes.
+const (
+	grpcOK               = 0
+	grpcCanceled         = 1
+	grpcUnknown          = 2
+	grpcDeadlineExceeded = 4
+	grpcUnavailable      = 14
+)
+
+// grpcMaxTimeoutDigits is the longest numeric part grpc-timeout may have.
+const grpcMaxTimeoutDigits = 8
+
+var grpcCodeNames = []string{
+	"OK", "CANCELLED", "UNKNOWN", "INVALID_ARGUMENT", "DEADLINE_EXCEEDED",
+	"NOT_FOUND", "ALREADY_EXISTS", "PERMISSION_DENIED", "RESOURCE_EXHAUSTED",
+	"FAILED_PRECONDITION", "ABORTED", "OUT_OF_RANGE", "UNIMPLEMENTED",
+	"INTERNAL", "UNAVAILABLE", "DATA_LOSS", "UNAUTHENTICATED",
+}
+
+// isGRPC reports whether r is a gRPC call.
+func isGRPC(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "application/grpc" || strings.HasPrefix(mediaType, "application/grpc+")
+}
+
+// grpcMethod splits a gRPC request path of the form /package.Service/Method
+// into its service and method names.
+func grpcMethod(path string) (service, method string) {
+	service, method, ok := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	if !ok || service == "" || method == "" || strings.Contains(method, "/") {
+		return "unknown", "unknown"
+	}
+	return service, method
+}
+
+// parseGRPCTimeout parses a grpc-timeout header value: at most eight digits
+// followed by a unit of H, M, S, m (milliseconds), u (microseconds) or n
+// (nanoseconds).
+func parseGRPCTimeout(value string) (time.Duration, bool) {
+	if len(value) < 2 || len(value) > grpcMaxTimeoutDigits+1 {
+		return 0, false
+	}
+	var unit time.Duration
+	switch value[len(value)-1] {
+	case 'H':
+		unit = time.Hour
+	case 'M':
+		unit = time.Minute
+	case 'S':
+		unit = time.Second
+	case 'm':
+		unit = time.Millisecond
+	case 'u':
+		unit = time.Microsecond
+	case 'n':
+		unit = time.Nanosecond
+	default:
+		return 0, false
+	}
+	n, err := strconv.ParseInt(value[:len(value)-1], 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * unit, true
+}
+
+// grpcTimeout returns the deadline for a gRPC call on route. The client's
+// grpc-timeout wins when it is shorter than the route's timeout. Without
+// either there is no deadline, since RPCs may stream indefinitely.
+func grpcTimeout(route *Route, h http.Header) time.Duration {
+	var timeout time.Duration
+	if route != nil {
+		timeout = route.Timeout
+	}
+	if d, ok := parseGRPCTimeout(h.Get("Grpc-Timeout")); ok && (timeout == 0 || d < timeout) {
+		timeout = d
+	}
+	return timeout
+}
+
+// grpcStatus returns the status code of a completed gRPC response. Servers
+// send it as a trailer, or as a header on trailers-only responses.
+func grpcStatus(resp *http.Response) int {
+	value := resp.Trailer.Get("Grpc-Status")
+	if value == "" {
+		value = resp.Header.Get("Grpc-Status")
+	}
+	code, err := strconv.Atoi(value)
+	if err != nil {
+		return grpcUnknown
+	}
+	return code
+}
+
+// writeGRPCError answers a gRPC call with a trailers-only response carrying
+// code and msg, which gRPC clients surface as a proper status rather than a
+// protocol error.
+func writeGRPCError(w http.ResponseWriter, code int, msg string) {
+	h := w.Header()
+	h.Set("Content-Type", "application/grpc")
+	h.Set("Grpc-Status", strconv.Itoa(code))
+	h.Set("Grpc-Message", encodeGRPCMessage(msg))
+	w.WriteHeader(http.StatusOK)
+}
+
+// encodeGRPCMessage percent-encodes msg as required for grpc-message.
+func encodeGRPCMessage(msg string) string {
+	return strings.ReplaceAll(url.PathEscape(msg), "%20", " ")
+}
+
+// grpcCodeName returns the canonical name of a gRPC status code.
+func grpcCodeName(code int) string {
+	if code >= 0 && code < len(grpcCodeNames) {
+		return grpcCodeNames[code]
+	}
+	return strconv.Itoa(code)
+}
+
+// grpcOther is the metric label of services and methods not listed in a
+// route's GRPCMethods.
+const grpcOther = "other"
+
+// grpcLabels returns the service and method labels for a call on path. A
+// service listed without its methods keeps its name but has its methods
+// measured as "other".
+func (rt *Route) grpcLabels(path string) (service, method string) {
+	service, method = grpcMethod(path)
+	listed := false
+	for _, name := range rt.GRPCMethods {
+		if name == service+"/"+method {
+			return service, method
+		}
+		listed = listed || name == service
+	}
+	if listed {
+		return service, grpcOther
+	}
+	return grpcOther, grpcOther
+}
+
+// recordGRPCCall records the outcome of a gRPC call on path.
+func recordGRPCCall(route *Route, path string, code int, start time.Time) {
+	service, method := route.grpcLabels(path)
+	metrics.RecordGRPCRequest(service, method, grpcCodeName(code), time.Since(start).Seconds())
+}
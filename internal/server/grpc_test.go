@@ -0,0 +1,256 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/novaru/golem/internal/balancer"
+	"github.com/novaru/golem/internal/metrics"
+	"github.com/novaru/golem/internal/transport"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// newH2CServer starts a backend that accepts cleartext HTTP/2, as gRPC
+// servers without TLS do.
+func newH2CServer(handler http.Handler) *httptest.Server {
+	srv := httptest.NewUnstartedServer(handler)
+	srv.Config = NewHTTPServer(handler, ListenerOptions{H2C: true})
+	srv.Start()
+	return srv
+}
+
+// newGRPCProxy starts an h2c proxy with a single gRPC route in front of
+// backendURL and returns it with an h2c client.
+func newGRPCProxy(t *testing.T, backendURL string, route *Route) (*httptest.Server, *http.Client) {
+	t.Helper()
+	backend := balancer.NewBackend(backendURL, 1)
+	backend.Transport = transport.New(backendURL, transport.Options{Protocol: transport.ProtocolH2C})
+	bal, _ := balancer.NewBalancer("roundrobin", []*balancer.Backend{backend})
+
+	ps := NewProxyServer(bal)
+	route.GRPC = true
+	ps.Routes = []*Route{route}
+
+	front := newH2CServer(ps)
+	t.Cleanup(front.Close)
+
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	return front, &http.Client{Transport: &http.Transport{Protocols: protocols}}
+}
+
+func newGRPCRequest(t *testing.T, url string) *http.Request {
+	t.Helper()
+	// A single empty, uncompressed message.
+	req, err := http.NewRequest("POST", url, bytes.NewReader([]byte{0, 0, 0, 0, 0}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	return req
+}
+
+func TestParseGRPCTimeout(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"1H", time.Hour, true},
+		{"2M", 2 * time.Minute, true},
+		{"3S", 3 * time.Second, true},
+		{"250m", 250 * time.Millisecond, true},
+		{"10u", 10 * time.Microsecond, true},
+		{"99999999n", 99999999 * time.Nanosecond, true},
+		{"123456789S", 0, false},
+		{"5", 0, false},
+		{"5s", 0, false},
+		{"-1S", 0, false},
+		{"", 0, false},
+	}
+
+	for _, tc := range tests {
+		got, ok := parseGRPCTimeout(tc.value)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("parseGRPCTimeout(%q) = %v, %v; want %v, %v", tc.value, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestGRPCTimeoutPrefersShorterDeadline(t *testing.T) {
+	route := &Route{Timeout: time.Second}
+	if got := grpcTimeout(route, http.Header{"Grpc-Timeout": {"100m"}}); got != 100*time.Millisecond {
+		t.Errorf("expected client deadline to win, got %v", got)
+	}
+	if got := grpcTimeout(route, http.Header{"Grpc-Timeout": {"5S"}}); got != time.Second {
+		t.Errorf("expected route timeout to win, got %v", got)
+	}
+	if got := grpcTimeout(&Route{}, http.Header{}); got != 0 {
+		t.Errorf("expected no deadline without grpc-timeout, got %v", got)
+	}
+}
+
+func TestGRPCMethod(t *testing.T) {
+	tests := []struct {
+		path, service, method string
+	}{
+		{"/helloworld.Greeter/SayHello", "helloworld.Greeter", "SayHello"},
+		{"/Greeter/", "unknown", "unknown"},
+		{"/a/b/c", "unknown", "unknown"},
+		{"/", "unknown", "unknown"},
+	}
+
+	for _, tc := range tests {
+		service, method := grpcMethod(tc.path)
+		if service != tc.service || method != tc.method {
+			t.Errorf("grpcMethod(%q) = %q, %q; want %q, %q", tc.path, service, method, tc.service, tc.method)
+		}
+	}
+}
+
+func TestGRPCLabelsOnlyListedNames(t *testing.T) {
+	route := &Route{GRPCMethods: []string{"helloworld.Greeter/SayHello", "test.Echo"}}
+	tests := []struct {
+		path, service, method string
+	}{
+		{"/helloworld.Greeter/SayHello", "helloworld.Greeter", "SayHello"},
+		{"/helloworld.Greeter/SayGoodbye", "other", "other"},
+		{"/test.Echo/Ping", "test.Echo", "other"},
+		{"/random.Service123/Method456", "other", "other"},
+		{"/", "other", "other"},
+	}
+
+	for _, tc := range tests {
+		service, method := route.grpcLabels(tc.path)
+		if service != tc.service || method != tc.method {
+			t.Errorf("grpcLabels(%q) = %q, %q; want %q, %q", tc.path, service, method, tc.service, tc.method)
+		}
+	}
+}
+
+func TestProxyGRPCForwardsTrailers(t *testing.T) {
+	backendServer := newH2CServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 {
+			t.Errorf("expected HTTP/2 to the backend, got %s", r.Proto)
+		}
+		io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status")
+		w.Write([]byte{0, 0, 0, 0, 0})
+		w.Header().Set("Grpc-Status", "0")
+		// Unannounced trailers must survive as well.
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", "done")
+	}))
+	defer backendServer.Close()
+
+	front, client := newGRPCProxy(t, backendServer.URL, &Route{Name: "grpc", GRPCMethods: []string{"test.Echo/Ping"}})
+
+	before := testutil.ToFloat64(metrics.GRPCRequestsTotal.WithLabelValues("test.Echo", "Ping", "OK"))
+
+	resp, err := client.Do(newGRPCRequest(t, front.URL+"/test.Echo/Ping"))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if !bytes.Equal(body, []byte{0, 0, 0, 0, 0}) {
+		t.Errorf("unexpected body %v", body)
+	}
+	if got := resp.Trailer.Get("Grpc-Status"); got != "0" {
+		t.Errorf("expected grpc-status trailer 0, got %q", got)
+	}
+	if got := resp.Trailer.Get("Grpc-Message"); got != "done" {
+		t.Errorf("expected grpc-message trailer, got %q", got)
+	}
+
+	after := testutil.ToFloat64(metrics.GRPCRequestsTotal.WithLabelValues("test.Echo", "Ping", "OK"))
+	if after != before+1 {
+		t.Errorf("expected the call to be counted with code OK, got %v -> %v", before, after)
+	}
+}
+
+func TestProxyGRPCBackendUnavailable(t *testing.T) {
+	backendServer := newH2CServer(http.NotFoundHandler())
+	backendURL := backendServer.URL
+	backendServer.Close()
+
+	front, client := newGRPCProxy(t, backendURL, &Route{Name: "grpc"})
+
+	resp, err := client.Do(newGRPCRequest(t, front.URL+"/test.Echo/Ping"))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected HTTP 200 carrying a gRPC status, got %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Grpc-Status"); got != "14" {
+		t.Errorf("expected grpc-status 14 (UNAVAILABLE), got %q", got)
+	}
+}
+
+func TestProxyGRPCHonorsTimeout(t *testing.T) {
+	release := make(chan struct{})
+	backendServer := newH2CServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer backendServer.Close()
+	defer close(release)
+
+	front, client := newGRPCProxy(t, backendServer.URL, &Route{Name: "grpc"})
+
+	req := newGRPCRequest(t, front.URL+"/test.Echo/Slow")
+	req.Header.Set("Grpc-Timeout", "50m")
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("grpc-timeout was not enforced, call took %v", elapsed)
+	}
+	if got := resp.Header.Get("Grpc-Status"); got != "4" {
+		t.Errorf("expected grpc-status 4 (DEADLINE_EXCEEDED), got %q", got)
+	}
+}
+
+func TestProxyForwardsHTTP1Trailers(t *testing.T) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "X-Checksum")
+		io.WriteString(w, "payload")
+		w.Header().Set("X-Checksum", "abc123")
+	}))
+	defer backendServer.Close()
+
+	bal, _ := balancer.NewBalancer("roundrobin", []*balancer.Backend{
+		balancer.NewBackend(backendServer.URL, 1),
+	})
+	front := httptest.NewServer(NewProxyServer(bal))
+	defer front.Close()
+
+	resp, err := http.Get(front.URL)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if got := resp.Trailer.Get("X-Checksum"); got != "abc123" {
+		t.Errorf("expected trailer to be forwarded, got %q", got)
+	}
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/novaru/golem/internal/proxyproto"
+)
+
+// RequestIDHeader carries the request ID. An incoming value is kept,
+// otherwise one is generated when a header rule asks for ${request_id}.
+const RequestIDHeader = "X-Request-Id"
+
+// HeaderRules holds the header rewrites applied on a route.
+type HeaderRules struct {
+	Request  HeaderOps
+	Response HeaderOps
+}
+
+// HeaderOps lists header operations. Remove runs first, then Set, then Add.
+// Values may reference variables written as ${name}.
+type HeaderOps struct {
+	Set    map[string]string
+	Add    map[string]string
+	Remove []string
+}
+
+// Apply rewrites h according to the operations, expanding variables in values
+// with vars.
+func (o HeaderOps) Apply(h http.Header, vars *headerVars) {
+	for _, name := range o.Remove {
+		h.Del(name)
+	}
+	for name, value := range o.Set {
+		h.Set(name, vars.expand(value))
+	}
+	for name, value := range o.Add {
+		h.Add(name, vars.expand(value))
+	}
+}
+
+// headerVars resolves the variables available to header rules for a single
+// request. The request ID is generated lazily and then reused so that request
+// and response rules see the same value.
+type headerVars struct {
+	r         *http.Request
+	backend   string
+	route     string
+	requestID string
+}
+
+func newHeaderVars(r *http.Request, backend, route string) *headerVars {
+	return &headerVars{r: r, backend: backend, route: route}
+}
+
+func (v *headerVars) lookup(name string) string {
+	switch name {
+	case "client_ip":
+		return clientIP(v.r)
+	case "backend":
+		return v.backend
+	case "request_id":
+		return v.RequestID()
+	case "route":
+		return v.route
+	case "host":
+		return v.r.Host
+	case "method":
+		return v.r.Method
+	case "path":
+		// The escaped form keeps encoded line breaks, such as %0d%0a, out
+		// of the header.
+		return v.r.URL.EscapedPath()
+	}
+	return ""
+}
+
+// RequestID returns the client supplied request ID or a newly generated one.
+func (v *headerVars) RequestID() string {
+	if v.requestID == "" {
+		v.requestID = v.r.Header.Get(RequestIDHeader)
+	}
+	if v.requestID == "" {
+		v.requestID = newRequestID()
+	}
+	return v.requestID
+}
+
+// expand replaces every ${name} in s with the variable's value. Unterminated
+// references are left untouched. Values holding control characters expand to
+// nothing, so that a client cannot smuggle line breaks into a header.
+func (v *headerVars) expand(s string) string {
+	if !strings.Contains(s, "${") {
+		return s
+	}
+	var b strings.Builder
+	for {
+		start := strings.Index(s, "${")
+		if start < 0 {
+			break
+		}
+		end := strings.Index(s[start:], "}")
+		if end < 0 {
+			break
+		}
+		b.WriteString(s[:start])
+		if value := v.lookup(s[start+2 : start+end]); !strings.ContainsFunc(value, isControl) {
+			b.WriteString(value)
+		}
+		s = s[start+end+1:]
+	}
+	b.WriteString(s)
+	return b.String()
+}
+
+func isControl(r rune) bool {
+	return r < ' ' || r == 0x7f
+}
+
+// checkHeader reports the first header in h that cannot be sent: a name that
+// is not a token or a value holding a control character other than tab.
+func checkHeader(h http.Header) error {
+	for name, values := range h {
+		if name == "" || strings.ContainsFunc(name, func(r rune) bool {
+			return isControl(r) || r > '~' || strings.ContainsRune(" \"(),/:;<=>?@[\\]{}", r)
+		}) {
+			return fmt.Errorf("invalid header name %q", name)
+		}
+		for _, value := range values {
+			if strings.ContainsFunc(value, func(r rune) bool { return isControl(r) && r != '\t' }) {
+				return fmt.Errorf("invalid value for header %s", name)
+			}
+		}
+	}
+	return nil
+}
+
+// clientIP returns the IP address of the peer that sent r.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func newRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// clientHeader describes the client connection r arrived on as a PROXY
+// protocol header.
+func clientHeader(r *http.Request) *proxyproto.Header {
+	h := &proxyproto.Header{}
+	if src, err := netip.ParseAddrPort(r.RemoteAddr); err == nil {
+		h.Source = src
+	}
+	if local, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		if dst, err := netip.ParseAddrPort(local.String()); err == nil {
+			h.Destination = dst
+		}
+	}
+	return h
+}
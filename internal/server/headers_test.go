@@ -0,0 +1,172 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/novaru/golem/internal/balancer"
+)
+
+func TestHeaderVarsExpand(t *testing.T) {
+	req := httptest.NewRequest("GET", "http://example.com/api/users", nil)
+	req.RemoteAddr = "203.0.113.9:51234"
+	req.Header.Set(RequestIDHeader, "abc123")
+
+	vars := newHeaderVars(req, "http://b1:3001", "api")
+
+	tests := map[string]string{
+		"${client_ip}":                    "203.0.113.9",
+		"${backend}":                      "http://b1:3001",
+		"route=${route} id=${request_id}": "route=api id=abc123",
+		"${method} ${path}":               "GET /api/users",
+		"plain value":                     "plain value",
+		"${unterminated":                  "${unterminated",
+	}
+	for input, want := range tests {
+		if got := vars.expand(input); got != want {
+			t.Errorf("expand(%q) = %q, want %q", input, got, want)
+		}
+	}
+
+	req.Host = "example.com\r\nX-Injected: yes"
+	if got := vars.expand("host=${host}"); got != "host=" {
+		t.Errorf("expected a host with a line break to expand to nothing, got %q", got)
+	}
+}
+
+func TestHeaderVarsGeneratesStableRequestID(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	vars := newHeaderVars(req, "", "")
+
+	id := vars.RequestID()
+	if len(id) != 32 {
+		t.Fatalf("expected a 32 character request ID, got %q", id)
+	}
+	if vars.RequestID() != id {
+		t.Error("request ID should not change within a request")
+	}
+}
+
+func TestHeaderOpsApplyOrder(t *testing.T) {
+	h := http.Header{}
+	h.Set("X-Internal", "secret")
+	h.Set("X-Tag", "old")
+
+	ops := HeaderOps{
+		Remove: []string{"X-Internal", "X-Tag"},
+		Set:    map[string]string{"X-Tag": "new"},
+		Add:    map[string]string{"X-Tag": "extra"},
+	}
+	ops.Apply(h, newHeaderVars(httptest.NewRequest("GET", "/", nil), "", ""))
+
+	if h.Get("X-Internal") != "" {
+		t.Error("expected X-Internal to be removed")
+	}
+	if got := h.Values("X-Tag"); len(got) != 2 || got[0] != "new" || got[1] != "extra" {
+		t.Errorf("expected X-Tag [new extra], got %v", got)
+	}
+}
+
+func TestProxyRouteHeaderRules(t *testing.T) {
+	var received http.Header
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+		w.Header().Set("Server", "internal/1.0")
+		w.Header().Set("X-Powered-By", "php")
+		w.Write([]byte("ok"))
+	}))
+	defer backendServer.Close()
+
+	bal, _ := balancer.NewBalancer("roundrobin", []*balancer.Backend{
+		balancer.NewBackend(backendServer.URL, 1),
+	})
+	proxy := NewProxyServer(bal)
+	proxy.Routes = []*Route{{
+		Name:       "api",
+		PathPrefix: "/api",
+		Headers: &HeaderRules{
+			Request: HeaderOps{
+				Set:    map[string]string{"X-Client-IP": "${client_ip}", "X-Route": "${route}"},
+				Remove: []string{"X-Debug"},
+			},
+			Response: HeaderOps{
+				Set:    map[string]string{"Strict-Transport-Security": "max-age=31536000"},
+				Remove: []string{"Server", "X-Powered-By"},
+			},
+		},
+	}}
+
+	req := httptest.NewRequest("GET", "/api/items", nil)
+	req.RemoteAddr = "198.51.100.7:4000"
+	req.Header.Set("X-Debug", "1")
+	rr := httptest.NewRecorder()
+	proxy.ServeHTTP(rr, req)
+
+	if received.Get("X-Client-IP") != "198.51.100.7" {
+		t.Errorf("expected X-Client-IP to be set, got %q", received.Get("X-Client-IP"))
+	}
+	if received.Get("X-Route") != "api" {
+		t.Errorf("expected X-Route api, got %q", received.Get("X-Route"))
+	}
+	if received.Get("X-Debug") != "" {
+		t.Error("expected X-Debug to be removed from the request")
+	}
+	if rr.Header().Get("Server") != "" || rr.Header().Get("X-Powered-By") != "" {
+		t.Errorf("expected internal response headers to be stripped, got %v", rr.Header())
+	}
+	if rr.Header().Get("Strict-Transport-Security") == "" {
+		t.Error("expected HSTS header on the response")
+	}
+
+	// Requests outside the route are passed through untouched.
+	req = httptest.NewRequest("GET", "/other", nil)
+	rr = httptest.NewRecorder()
+	proxy.ServeHTTP(rr, req)
+	if rr.Header().Get("Server") != "internal/1.0" {
+		t.Errorf("expected Server header outside the route, got %q", rr.Header().Get("Server"))
+	}
+}
+
+func TestProxyHeaderRulesRejectLineBreaks(t *testing.T) {
+	var received http.Header
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+	}))
+	defer backendServer.Close()
+
+	backend := balancer.NewBackend(backendServer.URL, 1)
+	bal, _ := balancer.NewBalancer("roundrobin", []*balancer.Backend{backend})
+	proxy := NewProxyServer(bal)
+	proxy.Routes = []*Route{
+		{Name: "bad", PathPrefix: "/bad", Headers: &HeaderRules{
+			Request: HeaderOps{Set: map[string]string{"X-Bad": "a\nb"}},
+		}},
+		{Name: "api", PathPrefix: "/api", Headers: &HeaderRules{
+			Request: HeaderOps{Set: map[string]string{"X-Path": "${path}"}},
+		}},
+	}
+
+	req := httptest.NewRequest("GET", "/api/a%0d%0aX-Injected:%20yes", nil)
+	rr := httptest.NewRecorder()
+	proxy.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body)
+	}
+	if got := received.Get("X-Path"); got != "/api/a%0d%0aX-Injected:%20yes" {
+		t.Errorf("expected the escaped path in X-Path, got %q", got)
+	}
+	if received.Get("X-Injected") != "" {
+		t.Errorf("expected no injected headers, got %v", received)
+	}
+
+	// Misconfigured rules fail the request, not the backend.
+	rr = httptest.NewRecorder()
+	proxy.ServeHTTP(rr, httptest.NewRequest("GET", "/bad", nil))
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("expected 500 for an unsendable header, got %d", rr.Code)
+	}
+	if !backend.IsHealthy() {
+		t.Error("expected the backend to stay healthy")
+	}
+}
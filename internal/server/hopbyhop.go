@@ -0,0 +1,82 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"strings"
+)
+
+// viaPseudonym identifies golem in Via headers.
+const viaPseudonym = "golem"
+
+// hopHeaders are the hop-by-hop headers defined by RFC 9110 section 7.6.1
+// (plus the obsolete but still common Keep-Alive and Proxy-Connection). They
+// describe a single connection and must not be forwarded by a proxy.
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Connection",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// HostPolicy decides the Host header sent to backends.
+type HostPolicy string
+
+const (
+	// HostBackend sends the backend's own host, as taken from its URL.
+	HostBackend HostPolicy = "backend"
+	// HostPreserve forwards the host requested by the client.
+	HostPreserve HostPolicy = "preserve"
+)
+
+// removeHopByHopHeaders deletes the hop-by-hop headers from h, including any
+// header named in the Connection header, and any Proxy-* header. A
+// "TE: trailers" request header is kept, since it announces end-to-end
+// trailer support (which gRPC relies on) rather than a connection property.
+func removeHopByHopHeaders(h http.Header) {
+	for _, value := range h.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			if name = textproto.TrimString(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+
+	teTrailers := false
+	for _, value := range h.Values("Te") {
+		for _, coding := range strings.Split(value, ",") {
+			if strings.EqualFold(textproto.TrimString(coding), "trailers") {
+				teTrailers = true
+			}
+		}
+	}
+
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+	for name := range h {
+		if strings.HasPrefix(name, "Proxy-") {
+			delete(h, name)
+		}
+	}
+
+	if teTrailers {
+		h.Set("Te", "trailers")
+	}
+}
+
+// addVia appends golem's entry to the Via header for a message received with
+// the given protocol version.
+func addVia(h http.Header, protoMajor, protoMinor int) {
+	version := fmt.Sprintf("%d.%d", protoMajor, protoMinor)
+	if protoMajor >= 2 {
+		version = fmt.Sprintf("%d", protoMajor)
+	}
+	h.Add("Via", version+" "+viaPseudonym)
+}
@@ -0,0 +1,182 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRemoveHopByHopHeaders(t *testing.T) {
+	h := http.Header{}
+	h.Set("Connection", "keep-alive, X-Conn-Scoped")
+	h.Set("X-Conn-Scoped", "1")
+	h.Set("Keep-Alive", "timeout=5")
+	h.Set("Proxy-Authorization", "Basic Zm9vOmJhcg==")
+	h.Set("Proxy-Custom", "x")
+	h.Set("Upgrade", "h2c")
+	h.Set("Te", "gzip, trailers")
+	h.Set("Trailer", "X-Checksum")
+	h.Set("Transfer-Encoding", "chunked")
+	h.Set("X-End-To-End", "kept")
+
+	removeHopByHopHeaders(h)
+
+	for _, name := range []string{
+		"Connection", "X-Conn-Scoped", "Keep-Alive", "Proxy-Authorization",
+		"Proxy-Custom", "Upgrade", "Trailer", "Transfer-Encoding",
+	} {
+		if _, ok := h[name]; ok {
+			t.Errorf("expected %s to be removed", name)
+		}
+	}
+	if h.Get("X-End-To-End") != "kept" {
+		t.Error("end-to-end header should be kept")
+	}
+	if h.Get("Te") != "trailers" {
+		t.Errorf("expected TE to be reduced to trailers, got %q", h.Get("Te"))
+	}
+}
+
+func TestProxyStripsHopByHopRequestHeaders(t *testing.T) {
+	var received *http.Request
+	proxy, _ := newTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		received = r.Clone(r.Context())
+	})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Connection", "X-Hop")
+	req.Header.Set("X-Hop", "secret")
+	req.Header.Set("Keep-Alive", "timeout=5")
+	req.Header.Set("Proxy-Authorization", "Basic Zm9vOmJhcg==")
+	req.Header.Set("Upgrade", "foo/1")
+	req.Header.Set("X-Keep", "yes")
+	rr := httptest.NewRecorder()
+	proxy.ServeHTTP(rr, req)
+
+	for _, name := range []string{"X-Hop", "Keep-Alive", "Proxy-Authorization", "Upgrade"} {
+		if received.Header.Get(name) != "" {
+			t.Errorf("expected %s not to reach the backend", name)
+		}
+	}
+	if received.Header.Get("X-Keep") != "yes" {
+		t.Error("expected end-to-end header to reach the backend")
+	}
+	if received.Header.Get("Via") != "1.1 golem" {
+		t.Errorf("expected Via 1.1 golem, got %q", received.Header.Get("Via"))
+	}
+	if received.Header.Get("User-Agent") != "" {
+		t.Errorf("proxy must not invent a User-Agent, got %q", received.Header.Get("User-Agent"))
+	}
+}
+
+func TestProxyAppendsToExistingVia(t *testing.T) {
+	var via []string
+	proxy, _ := newTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		via = r.Header.Values("Via")
+	})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Via", "1.0 edge")
+	proxy.ServeHTTP(httptest.NewRecorder(), req)
+
+	if len(via) != 2 || via[0] != "1.0 edge" || via[1] != "1.1 golem" {
+		t.Errorf("expected Via [1.0 edge, 1.1 golem], got %v", via)
+	}
+}
+
+func TestProxyStripsHopByHopResponseHeaders(t *testing.T) {
+	proxy, _ := newTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Connection", "X-Backend-Hop")
+		w.Header().Set("X-Backend-Hop", "1")
+		w.Header().Set("Keep-Alive", "timeout=30")
+		w.Header().Set("Proxy-Authenticate", "Basic")
+		w.Header().Set("X-End-To-End", "kept")
+		w.Write([]byte("ok"))
+	})
+
+	rr := httptest.NewRecorder()
+	proxy.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+
+	for _, name := range []string{"Connection", "X-Backend-Hop", "Keep-Alive", "Proxy-Authenticate"} {
+		if rr.Header().Get(name) != "" {
+			t.Errorf("expected %s not to reach the client", name)
+		}
+	}
+	if rr.Header().Get("X-End-To-End") != "kept" {
+		t.Error("expected end-to-end response header to reach the client")
+	}
+	if rr.Header().Get("Via") != "1.1 golem" {
+		t.Errorf("expected Via on the response, got %q", rr.Header().Get("Via"))
+	}
+}
+
+func TestProxyHostPolicy(t *testing.T) {
+	var host string
+	proxy, backend := newTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		host = r.Host
+	})
+	backendURL, _ := url.Parse(backend.URL)
+
+	req := httptest.NewRequest("GET", "http://public.example.com/", nil)
+	proxy.ServeHTTP(httptest.NewRecorder(), req)
+	if host != backendURL.Host {
+		t.Errorf("backend policy: expected Host %s, got %s", backendURL.Host, host)
+	}
+
+	proxy.HostPolicy = HostPreserve
+	proxy.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://public.example.com/", nil))
+	if host != "public.example.com" {
+		t.Errorf("preserve policy: expected Host public.example.com, got %s", host)
+	}
+
+	proxy.Routes = []*Route{{Name: "internal", PathPrefix: "/internal", HostPolicy: HostBackend}}
+	proxy.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://public.example.com/internal", nil))
+	if host != backendURL.Host {
+		t.Errorf("route override: expected Host %s, got %s", backendURL.Host, host)
+	}
+}
+
+func TestProxyDoesNotFollowRedirects(t *testing.T) {
+	proxy, _ := newTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/old" {
+			http.Redirect(w, r, "/new", http.StatusMovedPermanently)
+			return
+		}
+		w.Write([]byte("new location"))
+	})
+
+	rr := httptest.NewRecorder()
+	proxy.ServeHTTP(rr, httptest.NewRequest("GET", "/old", nil))
+
+	if rr.Code != http.StatusMovedPermanently {
+		t.Errorf("expected the redirect to be passed to the client, got %d", rr.Code)
+	}
+	if rr.Header().Get("Location") != "/new" {
+		t.Errorf("expected Location /new, got %q", rr.Header().Get("Location"))
+	}
+}
+
+func TestProxyForwardsRequestBodyLength(t *testing.T) {
+	var contentLength int64
+	var transferEncoding []string
+	proxy, _ := newTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		contentLength = r.ContentLength
+		transferEncoding = r.TransferEncoding
+	})
+
+	req := httptest.NewRequest("POST", "/", nil)
+	req.Body = http.NoBody
+	req.ContentLength = 0
+	proxy.ServeHTTP(httptest.NewRecorder(), req)
+
+	if contentLength != 0 || len(transferEncoding) != 0 {
+		t.Errorf("expected empty body without chunking, got length=%d te=%v", contentLength, transferEncoding)
+	}
+
+	proxy.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/", strings.NewReader("hello")))
+	if contentLength != 5 || len(transferEncoding) != 0 {
+		t.Errorf("expected Content-Length 5 without chunking, got length=%d te=%v", contentLength, transferEncoding)
+	}
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/netip"
+	"time"
+
+	"github.com/novaru/golem/internal/proxyproto"
+	"github.com/novaru/golem/internal/sniff"
+	"github.com/novaru/golem/internal/tlsutil"
+)
+
+// ListenerOptions configures a frontend HTTP listener.
+type ListenerOptions struct {
+	// Name labels the listener in logs and metrics.
+	Name string
+	Addr string
+
+	// TLSConfig enables TLS. HTTP/2 and HTTP/1.1 are then offered to
+	// clients through ALPN as listed in its NextProtos.
+	TLSConfig *tls.Config
+
+	// H2C additionally accepts cleartext HTTP/2 with prior knowledge on
+	// plaintext listeners.
+	H2C bool
+
+	// ProxyProtocolFrom lists the peers whose connections start with a
+	// PROXY protocol header, read before any TLS handshake.
+	ProxyProtocolFrom []netip.Prefix
+
+	// Sniff, when set, detects the protocol of each connection from its
+	// first bytes instead of expecting HTTP, or TLS with TLSConfig.
+	Sniff *SniffOptions
+
+	// ReusePort, when above 1, is the number of sockets the caller opens on
+	// Addr with SO_REUSEPORT, each served with its own accept loop.
+	ReusePort int
+}
+
+// StreamServer serves raw connections, such as a TCP proxy.
+type StreamServer interface {
+	Serve(ln net.Listener) error
+}
+
+// SniffOptions dispatches the connections of a single port by protocol.
+// Plaintext HTTP is always served. TLS is terminated and served as HTTP when
+// the listener has a TLSConfig, and passed to TLS otherwise.
+type SniffOptions struct {
+	// TLS receives TLS connections without terminating them.
+	TLS StreamServer
+	// TCP receives connections that are neither HTTP nor TLS.
+	TCP StreamServer
+	// Timeout bounds the wait for a client's first bytes, after which the
+	// connection is taken as TCP. Default 1 second.
+	Timeout time.Duration
+}
+
+// TLS reports whether the listener terminates TLS.
+func (o ListenerOptions) TLS() bool {
+	return o.TLSConfig != nil
+}
+
+// NewHTTPServer creates an http.Server serving handler with the protocols
+// enabled by opts. Every request, including each stream of a multiplexed
+// HTTP/2 connection, goes through handler and is balanced on its own.
+func NewHTTPServer(handler http.Handler, opts ListenerOptions) *http.Server {
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	if opts.TLS() {
+		protocols.SetHTTP2(true)
+	}
+	if opts.H2C {
+		protocols.SetUnencryptedHTTP2(true)
+	}
+	return &http.Server{
+		Addr:      opts.Addr,
+		Handler:   handler,
+		Protocols: protocols,
+		TLSConfig: opts.TLSConfig,
+	}
+}
+
+// Serve serves srv on ln as described by opts, reading PROXY protocol headers
+// and terminating TLS if configured. ln is opened by the caller, possibly
+// inherited from a previous process.
+func Serve(srv *http.Server, ln net.Listener, opts ListenerOptions) error {
+	if len(opts.ProxyProtocolFrom) > 0 {
+		ln = &proxyproto.Listener{Listener: ln, Name: opts.Name, Trusted: opts.ProxyProtocolFrom}
+	}
+	if opts.Sniff != nil {
+		return serveSniffing(srv, ln, opts)
+	}
+	if opts.TLS() {
+		ln = tlsutil.NewListener(ln, opts.TLSConfig, opts.Name)
+	}
+	return srv.Serve(ln)
+}
+
+// serveSniffing serves every protocol enabled by opts on ln until one of
+// them fails.
+func serveSniffing(srv *http.Server, ln net.Listener, opts ListenerOptions) error {
+	mux := sniff.NewMux(ln, opts.Name)
+	if opts.Sniff.Timeout > 0 {
+		mux.Timeout = opts.Sniff.Timeout
+	}
+	defer mux.Close()
+	// Shutdown closes the protocol listeners srv serves, but not the port.
+	srv.RegisterOnShutdown(func() { mux.Close() })
+
+	errc := make(chan error, 4)
+	serve := func(s StreamServer, ln net.Listener) {
+		go func() { errc <- s.Serve(ln) }()
+	}
+	serve(srv, mux.Listener(sniff.HTTP))
+	switch {
+	case opts.TLS():
+		serve(srv, tlsutil.NewListener(mux.Listener(sniff.TLS), opts.TLSConfig, opts.Name))
+	case opts.Sniff.TLS != nil:
+		serve(opts.Sniff.TLS, mux.Listener(sniff.TLS))
+	}
+	if opts.Sniff.TCP != nil {
+		serve(opts.Sniff.TCP, mux.Listener(sniff.TCP))
+	}
+	go func() { errc <- mux.Serve() }()
+	return <-errc
+}
@@ -0,0 +1,247 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/novaru/golem/internal/balancer"
+	"github.com/novaru/golem/internal/handoff"
+	"github.com/novaru/golem/internal/reuseport"
+)
+
+func TestNewHTTPServerProtocols(t *testing.T) {
+	tests := []struct {
+		name                    string
+		opts                    ListenerOptions
+		http2, unencryptedHTTP2 bool
+	}{
+		{"plaintext", ListenerOptions{Addr: ":0"}, false, false},
+		{"h2c", ListenerOptions{Addr: ":0", H2C: true}, false, true},
+		{"tls", ListenerOptions{Addr: ":0", TLSConfig: &tls.Config{}}, true, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := NewHTTPServer(http.NotFoundHandler(), tc.opts)
+			if !srv.Protocols.HTTP1() {
+				t.Error("HTTP/1.1 must always be enabled")
+			}
+			if srv.Protocols.HTTP2() != tc.http2 {
+				t.Errorf("HTTP2 = %v, want %v", srv.Protocols.HTTP2(), tc.http2)
+			}
+			if srv.Protocols.UnencryptedHTTP2() != tc.unencryptedHTTP2 {
+				t.Errorf("UnencryptedHTTP2 = %v, want %v", srv.Protocols.UnencryptedHTTP2(), tc.unencryptedHTTP2)
+			}
+		})
+	}
+}
+
+func TestH2CFrontendBalancesPerRequest(t *testing.T) {
+	newNamedBackend := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, name)
+		}))
+	}
+	b1 := newNamedBackend("one")
+	defer b1.Close()
+	b2 := newNamedBackend("two")
+	defer b2.Close()
+
+	bal, _ := balancer.NewBalancer("roundrobin", []*balancer.Backend{
+		balancer.NewBackend(b1.URL, 1),
+		balancer.NewBackend(b2.URL, 1),
+	})
+
+	front := httptest.NewUnstartedServer(nil)
+	front.Config = NewHTTPServer(NewProxyServer(bal), ListenerOptions{H2C: true})
+	front.Start()
+	defer front.Close()
+
+	dials := 0
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	client := &http.Client{Transport: &http.Transport{
+		Protocols: protocols,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dials++
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}}
+
+	seen := map[string]int{}
+	for range 4 {
+		resp, err := client.Get(front.URL)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.ProtoMajor != 2 {
+			t.Fatalf("expected HTTP/2, got %s", resp.Proto)
+		}
+		seen[string(body)]++
+	}
+
+	if dials != 1 {
+		t.Errorf("expected all requests to share one connection, got %d dials", dials)
+	}
+	if seen["one"] != 2 || seen["two"] != 2 {
+		t.Errorf("expected streams to be balanced per request, got %v", seen)
+	}
+}
+
+// streamFunc adapts a function to StreamServer.
+type streamFunc func(ln net.Listener) error
+
+func (f streamFunc) Serve(ln net.Listener) error { return f(ln) }
+
+func TestSniffingListenerServesAllProtocols(t *testing.T) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "http")
+	}))
+	defer backendServer.Close()
+	bal, _ := balancer.NewBalancer("roundrobin", []*balancer.Backend{balancer.NewBackend(backendServer.URL, 1)})
+
+	// Raw TCP connections are answered with a greeting and closed.
+	tcp := streamFunc(func(ln net.Listener) error {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return err
+			}
+			io.WriteString(c, "tcp")
+			c.Close()
+		}
+	})
+
+	opts := ListenerOptions{
+		Name:      "sniff",
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{newClientCert(t, "golem", "localhost")}},
+		Sniff:     &SniffOptions{TCP: tcp},
+	}
+	srv := NewHTTPServer(NewProxyServer(bal), opts)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go serveSniffing(srv, ln, opts)
+	defer ln.Close()
+	addr := ln.Addr().String()
+
+	get := func(client *http.Client, url string) string {
+		resp, err := client.Get(url)
+		if err != nil {
+			t.Fatalf("GET %s: %v", url, err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+	if got := get(http.DefaultClient, "http://"+addr); got != "http" {
+		t.Errorf("plaintext HTTP got %q", got)
+	}
+	tlsClient := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	if got := get(tlsClient, "https://"+addr); got != "http" {
+		t.Errorf("HTTPS got %q", got)
+	}
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "SSH-2.0-client\r\n")
+	if reply, _ := io.ReadAll(conn); string(reply) != "tcp" {
+		t.Errorf("raw TCP got %q", reply)
+	}
+}
+
+func TestSniffingListenerShutdownClosesPort(t *testing.T) {
+	bal, _ := balancer.NewBalancer("roundrobin", []*balancer.Backend{balancer.NewBackend("http://127.0.0.1:1", 1)})
+	opts := ListenerOptions{Name: "sniff", Sniff: &SniffOptions{}}
+	srv := NewHTTPServer(NewProxyServer(bal), opts)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- serveSniffing(srv, ln, opts) }()
+
+	time.Sleep(50 * time.Millisecond)
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown failed: %v", err)
+	}
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("serveSniffing did not return after shutdown")
+	}
+	if conn, err := net.Dial("tcp", ln.Addr().String()); err == nil {
+		conn.Close()
+		t.Error("expected the port to be closed after shutdown")
+	}
+}
+
+// BenchmarkAccept compares the rate of new HTTP connections served through
+// one listener with SO_REUSEPORT listeners, one per CPU, opened as golem opens
+// them.
+func BenchmarkAccept(b *testing.B) {
+	n := max(runtime.GOMAXPROCS(0), 2)
+	for _, tc := range []struct {
+		name string
+		n    int
+	}{
+		{"single", 1},
+		{fmt.Sprintf("reuseport-%d", n), n},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			// Hold a free port while the listeners join it.
+			hold, err := reuseport.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				b.Fatal(err)
+			}
+			opts := ListenerOptions{Addr: hold.Addr().String(), ReusePort: tc.n}
+			sockets, err := handoff.New()
+			if err != nil {
+				b.Fatal(err)
+			}
+			lns, err := sockets.ListenReusePort("tcp", opts.Addr, tc.n)
+			hold.Close()
+			if err != nil {
+				b.Fatal(err)
+			}
+			srv := NewHTTPServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, "ok")
+			}), opts)
+			defer srv.Close()
+			for _, ln := range lns {
+				go Serve(srv, ln, opts)
+			}
+			url := "http://" + opts.Addr
+			// Every request opens a connection, so accepting is on the hot path.
+			client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					resp, err := client.Get(url)
+					if err != nil {
+						b.Error(err)
+						return
+					}
+					io.Copy(io.Discard, resp.Body)
+					resp.Body.Close()
+				}
+			})
+			b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "conns/s")
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/novaru/golem/internal/balancer"
+	"github.com/novaru/golem/internal/metrics"
+)
+
+// ShadowHeader marks requests that were copied to the shadow pool so the
+// shadow backends can tell them apart from live traffic.
+const ShadowHeader = "X-Golem-Shadow"
+
+const (
+	defaultMirrorTimeout      = 10 * time.Second
+	defaultMirrorMaxBodyBytes = 1 << 20
+)
+
+// Mirror copies a share of live requests to a shadow pool. Shadow requests are
+// fire-and-forget: their responses are drained and discarded and never reach
+// the client, and their failures never affect the primary request.
+type Mirror struct {
+	Balancer     balancer.Balancer
+	Percent      float64
+	Timeout      time.Duration
+	MaxBodyBytes int64
+}
+
+// NewMirror creates a Mirror that copies percent (0-100) of requests to the
+// backends chosen by bal.
+func NewMirror(bal balancer.Balancer, percent float64) *Mirror {
+	return &Mirror{
+		Balancer:     bal,
+		Percent:      percent,
+		Timeout:      defaultMirrorTimeout,
+		MaxBodyBytes: defaultMirrorMaxBodyBytes,
+	}
+}
+
+// sample decides whether the current request should be mirrored.
+func (m *Mirror) sample() bool {
+	if m.Percent <= 0 {
+		return false
+	}
+	return m.Percent >= 100 || rand.Float64()*100 < m.Percent
+}
+
+// teeBody buffers the request body so it can be sent to both the primary and
+// the shadow backend. r.Body is replaced with a reader that replays the
+// buffered bytes. If the body is larger than MaxBodyBytes or cannot be read,
+// r.Body still yields the full original body but false is returned and the
+// request must not be mirrored.
+func (m *Mirror) teeBody(r *http.Request) ([]byte, bool, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, true, nil
+	}
+
+	original := r.Body
+	buf, err := io.ReadAll(io.LimitReader(original, m.MaxBodyBytes+1))
+	if err != nil || int64(len(buf)) > m.MaxBodyBytes {
+		// The primary request still gets what was read, followed by the
+		// rest of the body.
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(buf), original), original}
+		return nil, false, err
+	}
+
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{bytes.NewReader(buf), original}
+	return buf, true, nil
+}
+
+// skipReason returns why r cannot be mirrored, or "" if it can.
+func (m *Mirror) skipReason(r *http.Request) string {
+	switch {
+	case upgradeType(r.Header) != "":
+		return "upgrade"
+	case isGRPC(r):
+		return "grpc"
+	case r.ContentLength < 0:
+		return "unknown_length"
+	case r.ContentLength > m.MaxBodyBytes:
+		return "body_too_large"
+	}
+	return ""
+}
+
+// Copy sends a copy of r to a shadow backend in the background. It must be
+// called before r.Body is consumed by the primary request.
+func (m *Mirror) Copy(r *http.Request) {
+	if !m.sample() {
+		return
+	}
+
+	// Buffering must not hold up the primary request, so bodies of unknown
+	// length are never read ahead, and neither are the streams of upgraded
+	// connections or gRPC calls.
+	if reason := m.skipReason(r); reason != "" {
+		metrics.ShadowRequestFailures.WithLabelValues("", r.Method, reason).Inc()
+		return
+	}
+
+	body, ok, err := m.teeBody(r)
+	if err != nil {
+		metrics.ShadowRequestFailures.WithLabelValues("", r.Method, "body_read_error").Inc()
+		log.Printf("[ERROR] Failed to buffer request body for mirroring: %v", err)
+		return
+	}
+	if !ok {
+		metrics.ShadowRequestFailures.WithLabelValues("", r.Method, "body_too_large").Inc()
+		return
+	}
+
+	// Everything taken from r is copied here, since r belongs to the primary
+	// request and may be reused once the handler returns.
+	header := r.Header.Clone()
+	removeHopByHopHeaders(header)
+	header.Set(ShadowHeader, "true")
+	method := r.Method
+	path := r.URL.Path
+	rawQuery := r.URL.RawQuery
+
+	go m.send(method, path, rawQuery, header, body)
+}
+
+// send performs a single shadow request and records its outcome.
+func (m *Mirror) send(method, path, rawQuery string, header http.Header, body []byte) {
+	backend, err := m.Balancer.NextBackend()
+	if err != nil {
+		metrics.ShadowRequestFailures.WithLabelValues("", method, "no_backend").Inc()
+		return
+	}
+
+	targetURL, err := url.Parse(backend.URL)
+	if err != nil {
+		metrics.ShadowRequestFailures.WithLabelValues(backend.URL, method, "invalid_url").Inc()
+		return
+	}
+	dest := *targetURL
+	dest.Path = path
+	dest.RawQuery = rawQuery
+
+	// The shadow request is detached from the client request so that it is not
+	// cancelled when the primary response completes.
+	ctx, cancel := context.WithTimeout(context.Background(), m.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, method, dest.String(), bytes.NewReader(body))
+	if err != nil {
+		metrics.ShadowRequestFailures.WithLabelValues(backend.URL, method, "invalid_request").Inc()
+		return
+	}
+	req.Header = header
+
+	// Shadow requests are counted apart from live connections, so they
+	// neither show up as primary load nor sway least-connection picks.
+	active := metrics.ShadowActiveRequests.WithLabelValues(backend.URL)
+	active.Inc()
+	defer active.Dec()
+
+	transport := backend.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	// Like primary requests, shadow requests do not follow redirects.
+	start := time.Now()
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		metrics.ShadowRequestFailures.WithLabelValues(backend.URL, method, "backend_unavailable").Inc()
+		log.Printf("[WARN] Shadow backend %s failed: %v", backend.URL, err)
+		return
+	}
+	// Drain the body so the latency covers the full response and the
+	// connection can be reused.
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	metrics.RecordShadowRequest(
+		backend.URL,
+		method,
+		fmt.Sprintf("%d", resp.StatusCode),
+		time.Since(start).Seconds(),
+	)
+}
@@ -0,0 +1,254 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/novaru/golem/internal/balancer"
+)
+
+type shadowCapture struct {
+	method string
+	path   string
+	body   string
+	marker string
+	hop    string
+}
+
+func newMirroredProxy(t *testing.T, primary, shadow http.HandlerFunc, percent float64) *ProxyServer {
+	t.Helper()
+
+	proxy, _ := newTestProxy(t, primary)
+	shadowProxy, _ := newTestProxy(t, shadow)
+	proxy.Mirror = NewMirror(shadowProxy.Balancer, percent)
+	return proxy
+}
+
+func TestMirrorCopiesRequestToShadow(t *testing.T) {
+	captured := make(chan shadowCapture, 1)
+
+	proxy := newMirroredProxy(t,
+		func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			if r.Header.Get(ShadowHeader) != "" {
+				t.Errorf("primary request must not carry the shadow marker")
+			}
+			w.Write([]byte("primary:" + string(body)))
+		},
+		func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			captured <- shadowCapture{
+				method: r.Method,
+				path:   r.URL.RequestURI(),
+				body:   string(body),
+				marker: r.Header.Get(ShadowHeader),
+				hop:    r.Header.Get("X-Hop"),
+			}
+			w.WriteHeader(http.StatusTeapot)
+		},
+		100,
+	)
+
+	req := httptest.NewRequest("POST", "/orders?id=7", strings.NewReader("payload"))
+	req.Header.Set("Connection", "X-Hop")
+	req.Header.Set("X-Hop", "1")
+	rr := httptest.NewRecorder()
+	proxy.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rr.Code)
+	}
+	if rr.Body.String() != "primary:payload" {
+		t.Errorf("Expected primary to receive the full body, got %q", rr.Body.String())
+	}
+
+	select {
+	case got := <-captured:
+		if got.method != "POST" || got.path != "/orders?id=7" {
+			t.Errorf("Unexpected shadow request %s %s", got.method, got.path)
+		}
+		if got.body != "payload" {
+			t.Errorf("Expected shadow body %q, got %q", "payload", got.body)
+		}
+		if got.marker != "true" {
+			t.Errorf("Expected shadow marker header, got %q", got.marker)
+		}
+		if got.hop != "" {
+			t.Errorf("Expected hop-by-hop headers to be stripped, got X-Hop %q", got.hop)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("shadow backend did not receive the mirrored request")
+	}
+}
+
+func TestMirrorDoesNotAffectClientResponse(t *testing.T) {
+	proxy := newMirroredProxy(t,
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("primary"))
+		},
+		func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(500 * time.Millisecond)
+			w.WriteHeader(http.StatusInternalServerError)
+		},
+		100,
+	)
+
+	start := time.Now()
+	req := httptest.NewRequest("GET", "/", nil)
+	rr := httptest.NewRecorder()
+	proxy.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK || rr.Body.String() != "primary" {
+		t.Errorf("Expected primary response, got %d %q", rr.Code, rr.Body.String())
+	}
+	if elapsed := time.Since(start); elapsed > 400*time.Millisecond {
+		t.Errorf("Client response waited for the shadow backend (%v)", elapsed)
+	}
+}
+
+func TestMirrorSkipsLargeBodies(t *testing.T) {
+	shadowHit := make(chan struct{}, 1)
+
+	proxy := newMirroredProxy(t,
+		func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			w.Write(body)
+		},
+		func(w http.ResponseWriter, r *http.Request) {
+			shadowHit <- struct{}{}
+		},
+		100,
+	)
+	proxy.Mirror.MaxBodyBytes = 4
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader("larger than four bytes"))
+	rr := httptest.NewRecorder()
+	proxy.ServeHTTP(rr, req)
+
+	if rr.Body.String() != "larger than four bytes" {
+		t.Errorf("Expected primary to receive the full body, got %q", rr.Body.String())
+	}
+
+	select {
+	case <-shadowHit:
+		t.Error("request with an oversized body should not be mirrored")
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestMirrorNotCountedAsConnections(t *testing.T) {
+	release := make(chan struct{})
+	received := make(chan struct{})
+	shadowServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(received)
+		<-release
+	}))
+	defer shadowServer.Close()
+	defer close(release)
+
+	shadow := balancer.NewBackend(shadowServer.URL, 1)
+	shadowBal, _ := balancer.NewBalancer("leastconn", []*balancer.Backend{shadow})
+	m := NewMirror(shadowBal, 100)
+	m.Copy(httptest.NewRequest("GET", "/", nil))
+
+	select {
+	case <-received:
+	case <-time.After(2 * time.Second):
+		t.Fatal("shadow backend did not receive the mirrored request")
+	}
+	if got := shadow.GetConnections(); got != 0 {
+		t.Errorf("expected shadow requests to leave connection counts alone, got %d", got)
+	}
+}
+
+// flakyReader returns data, then one error, then the rest of the data.
+type flakyReader struct {
+	chunks []string
+	failed bool
+}
+
+func (f *flakyReader) Read(p []byte) (int, error) {
+	if len(f.chunks) == 0 {
+		return 0, io.EOF
+	}
+	if len(f.chunks) == 1 && !f.failed {
+		f.failed = true
+		return 0, errors.New("connection reset")
+	}
+	n := copy(p, f.chunks[0])
+	f.chunks = f.chunks[1:]
+	return n, nil
+}
+
+func TestMirrorTeeBodyReadError(t *testing.T) {
+	m := NewMirror(nil, 100)
+	req := httptest.NewRequest("POST", "/", nil)
+	req.Body = io.NopCloser(&flakyReader{chunks: []string{"head ", "tail"}})
+
+	if _, ok, err := m.teeBody(req); ok || err == nil {
+		t.Fatalf("expected a read error, got ok=%v err=%v", ok, err)
+	}
+	body, _ := io.ReadAll(req.Body)
+	if string(body) != "head tail" {
+		t.Errorf("expected the primary request to keep the bytes read so far, got %q", body)
+	}
+}
+
+func TestMirrorDoesNotBufferStreamingBodies(t *testing.T) {
+	gotFirst := make(chan struct{})
+	shadowHit := make(chan struct{}, 1)
+
+	proxy := newMirroredProxy(t,
+		func(w http.ResponseWriter, r *http.Request) {
+			buf := make([]byte, 5)
+			if _, err := io.ReadFull(r.Body, buf); err == nil {
+				close(gotFirst)
+			}
+			io.Copy(io.Discard, r.Body)
+		},
+		func(w http.ResponseWriter, r *http.Request) {
+			shadowHit <- struct{}{}
+		},
+		100,
+	)
+
+	// The client keeps the body open until the backend has seen its start,
+	// as a client-streaming call waiting for a reply does.
+	pr, pw := io.Pipe()
+	req := httptest.NewRequest("POST", "/upload", pr)
+	req.ContentLength = -1
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		proxy.ServeHTTP(httptest.NewRecorder(), req)
+	}()
+	pw.Write([]byte("hello"))
+
+	select {
+	case <-gotFirst:
+	case <-time.After(2 * time.Second):
+		t.Error("the primary backend did not receive the start of a streaming body")
+	}
+	pw.Close()
+	<-done
+
+	select {
+	case <-shadowHit:
+		t.Error("a body of unknown length should not be mirrored")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestMirrorZeroPercent(t *testing.T) {
+	m := NewMirror(nil, 0)
+	for range 100 {
+		if m.sample() {
+			t.Fatal("0% mirror should never sample a request")
+		}
+	}
+}
@@ -1,16 +1,19 @@
 package server
 
 import (
-	"bufio"
+	"context"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"net/url"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/novaru/golem/internal/balancer"
 	"github.com/novaru/golem/internal/metrics"
+	"github.com/novaru/golem/internal/proxyproto"
 )
 
 // ProxyServer is a simple HTTP reverse proxy that uses a load balancer to distribute requests
@@ -19,11 +22,63 @@ import (
 // checks and connection counts.
 type ProxyServer struct {
 	Balancer balancer.Balancer
+
+	// Mirror, when set, copies a share of requests to a shadow pool.
+	Mirror *Mirror
+
+	// Routes are matched in order; the first route matching a request
+	// decides its balancer and header rules.
+	Routes []*Route
+
+	// Forwarding sets the X-Forwarded-* and Forwarded headers on proxied
+	// requests. A nil Forwarding leaves the client headers untouched.
+	Forwarding *Forwarding
+
+	// ClientCertHeaders, when set, forwards the identity of verified client
+	// certificates to backends.
+	ClientCertHeaders *ClientCertHeaders
+
+	// HostPolicy decides the Host header sent to backends. Routes may
+	// override it. Defaults to HostBackend.
+	HostPolicy HostPolicy
+
+	// Transport performs the backend round trips for backends without a
+	// transport of their own. Redirects returned by backends are passed to
+	// the client rather than followed.
+	Transport http.RoundTripper
+
+	// Upgrade bounds the lifetime of upgraded connections such as WebSockets.
+	Upgrade UpgradeOptions
+
+	// Timeout bounds each proxied exchange on routes that are not streaming.
+	// Defaults to 10 seconds.
+	Timeout time.Duration
+
+	// FlushInterval is the default interval for flushing response bodies to
+	// the client. Negative flushes after every write; zero only flushes when
+	// net/http's buffer fills. Streaming content types always flush
+	// immediately.
+	FlushInterval time.Duration
+
+	inFlight atomic.Int64
 }
 
 // NewProxyServer creates a new instance of ProxyServer with the provided load balancer.
+// By default the X-Forwarded-* headers are set and no upstream proxy is trusted.
 func NewProxyServer(bal balancer.Balancer) *ProxyServer {
-	return &ProxyServer{Balancer: bal}
+	return &ProxyServer{
+		Balancer:   bal,
+		Forwarding: NewForwarding(),
+		HostPolicy: HostBackend,
+		Transport:  http.DefaultTransport,
+	}
+}
+
+// InFlight returns the number of requests being proxied, including streams
+// and upgraded connections such as WebSockets, which http.Server.Shutdown
+// does not wait for.
+func (ps *ProxyServer) InFlight() int64 {
+	return ps.inFlight.Load()
 }
 
 // ServeHTTP implements the http.Handler interface for ProxyServer.
@@ -31,11 +86,46 @@ func NewProxyServer(bal balancer.Balancer) *ProxyServer {
 // and forwards the request to the selected backend server.
 func (ps *ProxyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	startTime := time.Now()
+	ps.inFlight.Add(1)
+	defer ps.inFlight.Add(-1)
 
-	backend, err := ps.Balancer.NextBackend()
-	if err != nil {
-		http.Error(w, "No healthy backend available", http.StatusServiceUnavailable)
-		return
+	bal := ps.Balancer
+	route := ps.matchRoute(r)
+	routeName := ""
+	if route != nil {
+		routeName = route.Name
+		if route.Balancer != nil {
+			bal = route.Balancer
+		}
+	}
+
+	if route != nil && route.ClientCert != nil {
+		if reason := checkClientCert(r, route.ClientCert); reason != "" {
+			metrics.ClientCertRejections.WithLabelValues(routeName, reason).Inc()
+			log.Printf("[WARN] Rejected request to route %s from %s: %s", routeName, r.RemoteAddr, reason)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+	}
+
+	grpc := route != nil && route.GRPC && isGRPC(r)
+
+	var backend *balancer.Backend
+	if route != nil && route.SSE != nil && route.SSE.Sticky {
+		backend = stickyBackend(r, bal)
+	}
+	if backend == nil {
+		var err error
+		backend, err = bal.NextBackend()
+		if err != nil {
+			if grpc {
+				writeGRPCError(w, grpcUnavailable, "no healthy backend available")
+				recordGRPCCall(route, r.URL.Path, grpcUnavailable, startTime)
+				return
+			}
+			http.Error(w, "No healthy backend available", http.StatusServiceUnavailable)
+			return
+		}
 	}
 
 	targetURL, err := url.Parse(backend.URL)
@@ -70,24 +160,92 @@ func (ps *ProxyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	dest.Path = r.URL.Path
 	dest.RawQuery = r.URL.RawQuery
 
+	if ps.Mirror != nil {
+		ps.Mirror.Copy(r)
+	}
+
+	// Upgraded connections live on after the handshake and are bounded by
+	// ps.Upgrade instead of the request timeout.
+	upType := upgradeType(r.Header)
+
+	timeout := ps.requestTimeout(route)
+	switch {
+	case upType != "":
+		timeout = 0
+	case grpc:
+		timeout = grpcTimeout(route, r.Header)
+	}
+
+	// Backends that speak the PROXY protocol learn the client address from
+	// the connection made for this request.
+	ctx := proxyproto.NewContext(r.Context(), clientHeader(r))
+	stopTimeout := func() bool { return false }
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, stopTimeout, cancel = withRequestTimeout(ctx, timeout)
+		defer cancel()
+	}
+
 	// Prepare request to backend
-	proxyReq, err := http.NewRequest(r.Method, dest.String(), r.Body)
+	proxyReq, err := http.NewRequestWithContext(ctx, r.Method, dest.String(), r.Body)
 	if err != nil {
 		http.Error(w, "Failed to create proxy request", http.StatusInternalServerError)
 		return
 	}
+	proxyReq.ContentLength = r.ContentLength
+	if r.ContentLength == 0 {
+		proxyReq.Body = http.NoBody
+	}
 	proxyReq.Header = r.Header.Clone()
+	proxyReq.Trailer = r.Trailer
+	removeHopByHopHeaders(proxyReq.Header)
+	if upType != "" {
+		proxyReq.Header.Set("Connection", "Upgrade")
+		proxyReq.Header.Set("Upgrade", upType)
+	}
+	if _, ok := proxyReq.Header["User-Agent"]; !ok {
+		// An empty value stops the transport from adding its own User-Agent.
+		proxyReq.Header.Set("User-Agent", "")
+	}
+	if ps.hostPolicy(route) == HostPreserve {
+		proxyReq.Host = r.Host
+	}
+	if ps.Forwarding != nil {
+		ps.Forwarding.Apply(proxyReq.Header, r)
+	}
+	if ps.ClientCertHeaders != nil {
+		ps.ClientCertHeaders.Apply(proxyReq.Header, r)
+	}
+	addVia(proxyReq.Header, r.ProtoMajor, r.ProtoMinor)
 
-	client := &http.Client{}
-	if dest.Path == "/stream" {
-		client.Timeout = 0
-	} else {
-		client.Timeout = 10 * time.Second
+	vars := newHeaderVars(r, backend.URL, routeName)
+	if route != nil && route.Headers != nil {
+		route.Headers.Request.Apply(proxyReq.Header, vars)
+	}
+	// A request the transport would refuse to send says nothing about the
+	// backend, so it is rejected here rather than failing the backend.
+	if err := checkHeader(proxyReq.Header); err != nil {
+		log.Printf("[ERROR] Failed to create proxy request to %s: %v", backend.URL, err)
+		http.Error(w, "Failed to create proxy request", http.StatusInternalServerError)
+		return
 	}
 
-	resp, err := client.Do(proxyReq)
+	resp, err := ps.transportFor(backend).RoundTrip(proxyReq)
 	if err != nil {
 		removeConnection()
+		if r.Context().Err() != nil {
+			// The client went away; this says nothing about the backend.
+			metrics.RequestFailures.WithLabelValues(backend.URL, r.Method, "client_canceled").Inc()
+			log.Printf("[INFO] Client canceled request to %s: %v", backend.URL, err)
+			if grpc {
+				recordGRPCCall(route, r.URL.Path, grpcCanceled, startTime)
+			}
+			return
+		}
+		if grpc {
+			ps.failGRPC(ctx, w, r, route, backend, err, startTime)
+			return
+		}
 		http.Error(w, "Backend unavailable", http.StatusBadGateway)
 		backend.SetHealth(false)
 
@@ -102,48 +260,137 @@ func (ps *ProxyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		metrics.RecordRequest(backend.URL, r.Method, "101", time.Since(startTime).Seconds())
+		ps.serveUpgrade(w, r, resp, backend)
+		return
+	}
+
 	// Forward response headers and status
+	removeHopByHopHeaders(resp.Header)
+	addVia(resp.Header, resp.ProtoMajor, resp.ProtoMinor)
 	for k, v := range resp.Header {
 		for _, vv := range v {
 			w.Header().Add(k, vv)
 		}
 	}
+	if route != nil && route.Headers != nil {
+		route.Headers.Response.Apply(w.Header(), vars)
+	}
+
+	eventStream := isEventStream(resp.Header)
+	if eventStream {
+		// Event streams outlive any request timeout, whatever the route.
+		stopTimeout()
+	}
+	var sse SSEOptions
+	if route != nil && route.SSE != nil {
+		sse = *route.SSE
+	}
+	if eventStream && sse.Sticky {
+		setStickyCookie(w, backend)
+	}
+	announcedTrailers := announceTrailers(w.Header(), resp.Trailer)
 	w.WriteHeader(resp.StatusCode)
 
-	flusher, supportsFlushing := w.(http.Flusher)
+	flushInterval := ps.flushInterval(route, resp)
+	if flushInterval < 0 {
+		// Let streaming clients see the headers before the first event.
+		http.NewResponseController(w).Flush()
+	}
 
-	duration := time.Since(startTime).Seconds()
+	duration := time.Since(startTime)
+	backend.ObserveResponseTime(duration)
+	if rec, ok := bal.(balancer.ResponseTimeRecorder); ok {
+		rec.RecordResponseTime(backend, duration)
+	}
 	metrics.RecordRequest(
 		backend.URL,
 		r.Method,
 		fmt.Sprintf("%d", resp.StatusCode),
-		duration,
+		duration.Seconds(),
 	)
 
-	// Handle streaming vs regular responses
-	// This simulates a scenario where the backend can return a streaming response
-	if supportsFlushing && resp.Header.Get("Content-Type") == "text/plain" {
-		// Streaming response - read line by line and flush immediately
-		scanner := bufio.NewScanner(resp.Body)
-		for scanner.Scan() {
-			line := scanner.Text()
-			_, err := fmt.Fprintln(w, line)
-			if err != nil {
-				log.Printf("[INFO] Client disconnected during streaming to %s", backend.URL)
-				removeConnection()
-				return
-			}
-			flusher.Flush()
-		}
-		if err := scanner.Err(); err != nil {
-			log.Printf("[INFO] Streaming ended or client disconnected: %v", err)
-			removeConnection()
-		}
+	if eventStream {
+		// An event stream is long-lived; count it as a stream rather than a
+		// request/response connection from here on.
+		removeConnection()
+		metrics.UpdateActiveConnections(backend.URL, float64(backend.GetConnections()))
+		backend.AddStreams()
+		metrics.SSEStreams.WithLabelValues(backend.URL).Set(float64(backend.GetStreams()))
+		defer func() {
+			backend.RemoveStreams()
+			metrics.SSEStreams.WithLabelValues(backend.URL).Set(float64(backend.GetStreams()))
+		}()
+		err = copyEventStream(w, resp.Body, sse.HeartbeatInterval, backend.URL)
 	} else {
-		_, err := io.Copy(w, resp.Body)
-		if err != nil {
-			log.Printf("[INFO] Client disconnected during response: %v", err)
-			removeConnection()
+		err = copyResponse(w, resp.Body, flushInterval)
+	}
+	if err != nil {
+		if errors.Is(err, errClientWrite) {
+			log.Printf("[INFO] Client disconnected during response from %s: %v", backend.URL, err)
+		} else {
+			log.Printf("[INFO] Response from %s ended early: %v", backend.URL, err)
+		}
+		removeConnection()
+		if grpc {
+			code := grpcUnavailable
+			if errors.Is(err, errClientWrite) {
+				code = grpcCanceled
+			} else if context.Cause(ctx) == context.DeadlineExceeded {
+				code = grpcDeadlineExceeded
+			}
+			// The backend's trailers never arrived; end the call with a
+			// status so the client does not see a bare protocol error.
+			w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(code))
+			recordGRPCCall(route, r.URL.Path, code, startTime)
 		}
+		return
+	}
+
+	copyTrailers(w.Header(), resp.Trailer, announcedTrailers)
+	if grpc {
+		recordGRPCCall(route, r.URL.Path, grpcStatus(resp), startTime)
+	}
+}
+
+// failGRPC reports a failed backend round trip to a gRPC client. A deadline
+// set by the client's grpc-timeout says nothing about the backend's health,
+// so only other failures mark the backend unhealthy.
+func (ps *ProxyServer) failGRPC(ctx context.Context, w http.ResponseWriter, r *http.Request, route *Route, backend *balancer.Backend, err error, start time.Time) {
+	if context.Cause(ctx) == context.DeadlineExceeded {
+		writeGRPCError(w, grpcDeadlineExceeded, "deadline exceeded")
+		metrics.RequestFailures.WithLabelValues(backend.URL, r.Method, "deadline_exceeded").Inc()
+		recordGRPCCall(route, r.URL.Path, grpcDeadlineExceeded, start)
+		log.Printf("[WARN] gRPC call %s to %s exceeded its deadline", r.URL.Path, backend.URL)
+		return
+	}
+
+	writeGRPCError(w, grpcUnavailable, "backend unavailable")
+	backend.SetHealth(false)
+	metrics.RequestFailures.WithLabelValues(backend.URL, r.Method, "backend_unavailable").Inc()
+	recordGRPCCall(route, r.URL.Path, grpcUnavailable, start)
+	log.Printf("[ERROR] Backend %s is unavailable: %v", backend.URL, err)
+}
+
+// hostPolicy returns the Host header policy for a request on route.
+func (ps *ProxyServer) hostPolicy(route *Route) HostPolicy {
+	if route != nil && route.HostPolicy != "" {
+		return route.HostPolicy
+	}
+	if ps.HostPolicy != "" {
+		return ps.HostPolicy
+	}
+	return HostBackend
+}
+
+// transportFor returns the RoundTripper used to reach backend.
+func (ps *ProxyServer) transportFor(backend *balancer.Backend) http.RoundTripper {
+	if backend.Transport != nil {
+		return backend.Transport
+	}
+	if ps.Transport != nil {
+		return ps.Transport
 	}
+	return http.DefaultTransport
 }
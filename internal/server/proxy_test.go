@@ -14,6 +14,19 @@ import (
 	"github.com/novaru/golem/internal/balancer"
 )
 
+// newTestProxy starts a backend serving handler and returns a proxy that
+// balances to it alone.
+func newTestProxy(t *testing.T, handler http.HandlerFunc) (*ProxyServer, *balancer.Backend) {
+	t.Helper()
+
+	backendServer := httptest.NewServer(handler)
+	t.Cleanup(backendServer.Close)
+
+	backend := balancer.NewBackend(backendServer.URL, 1)
+	bal, _ := balancer.NewBalancer("roundrobin", []*balancer.Backend{backend})
+	return NewProxyServer(bal), backend
+}
+
 func TestProxyServeHTTP(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -286,3 +299,64 @@ func TestProxyLeastConnBalancingWithStreaming(t *testing.T) {
 	time.Sleep(1 * time.Second)
 	responses = append(responses, rr1, rr2)
 }
+
+func TestProxyInFlightCountsUpgradedConnections(t *testing.T) {
+	proxy, _, front := newUpgradeProxy(t)
+
+	conn, _, resp := dialUpgrade(t, front.Listener.Addr().String())
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected 101, got %d", resp.StatusCode)
+	}
+	// The hijacked connection stays in flight after the upgrade response.
+	if n := proxy.InFlight(); n != 1 {
+		t.Errorf("expected 1 request in flight, got %d", n)
+	}
+
+	conn.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for proxy.InFlight() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("upgraded connection still in flight after close")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestProxyFeedsWeightedBalancerAcrossSwap(t *testing.T) {
+	fastServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer fastServer.Close()
+	slowServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+	}))
+	defer slowServer.Close()
+
+	fast := balancer.NewBackend(fastServer.URL, 1)
+	slow := balancer.NewBackend(slowServer.URL, 1)
+	pool, err := balancer.NewPool("web", "weighted", []*balancer.Backend{fast, slow}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := NewProxyServer(pool.Balancer)
+
+	// Take each backend out in turn so both have served requests.
+	for _, down := range []*balancer.Backend{fast, slow} {
+		down.SetHealth(false)
+		for range 3 {
+			proxy.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		}
+		down.SetHealth(true)
+	}
+
+	if _, err := pool.Update("weighted", []*balancer.Backend{fast, slow}); err != nil {
+		t.Fatal(err)
+	}
+	picks := 0
+	for range 1000 {
+		if b, _ := pool.Balancer.NextBackend(); b == slow {
+			picks++
+		}
+	}
+	if picks > 200 {
+		t.Errorf("expected the slow backend to keep a reduced share after the swap, got %d of 1000 picks", picks)
+	}
+}
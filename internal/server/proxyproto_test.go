@@ -0,0 +1,59 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/novaru/golem/internal/balancer"
+	"github.com/novaru/golem/internal/proxyproto"
+	"github.com/novaru/golem/internal/transport"
+)
+
+func TestProxyProtocolClientAddress(t *testing.T) {
+	loopback := []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}
+
+	// The backend reads PROXY headers itself and reports what it learned.
+	backendSrv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s|%s", r.RemoteAddr, r.Header.Get("X-Forwarded-For"))
+	}))
+	backendSrv.Listener = &proxyproto.Listener{Listener: backendSrv.Listener, Trusted: loopback}
+	backendSrv.Start()
+	defer backendSrv.Close()
+
+	backend := balancer.NewBackend(backendSrv.URL, 1)
+	backend.Transport = transport.New(backendSrv.URL, transport.Options{ProxyProtocol: proxyproto.V2})
+	bal, _ := balancer.NewBalancer("roundrobin", []*balancer.Backend{backend})
+
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := NewHTTPServer(NewProxyServer(bal), ListenerOptions{})
+	go srv.Serve(&proxyproto.Listener{Listener: inner, Name: "test", Trusted: loopback})
+	defer srv.Close()
+
+	for _, client := range []string{"203.0.113.7", "198.51.100.9"} {
+		conn, err := net.Dial("tcp", inner.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(conn, "PROXY TCP4 %s 10.0.0.1 51234 80\r\nGET / HTTP/1.1\r\nHost: golem\r\nConnection: close\r\n\r\n", client)
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		conn.Close()
+
+		// Each client gets its own backend connection announcing it.
+		if want := client + ":51234|" + client; string(body) != want {
+			t.Errorf("backend saw %q, want %q", body, want)
+		}
+	}
+}
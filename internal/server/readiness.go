@@ -0,0 +1,32 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+)
+
+// Readiness answers readiness checks of upstream load balancers. It reports
+// ready until draining starts, so that they stop sending new clients while
+// in-flight requests finish.
+type Readiness struct {
+	draining atomic.Bool
+}
+
+// SetDraining makes every later check fail.
+func (rd *Readiness) SetDraining() {
+	rd.draining.Store(true)
+}
+
+// Draining reports whether SetDraining was called.
+func (rd *Readiness) Draining() bool {
+	return rd.draining.Load()
+}
+
+func (rd *Readiness) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if rd.Draining() {
+		http.Error(w, "draining", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ready")
+}
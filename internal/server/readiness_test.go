@@ -0,0 +1,24 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReadinessFailsWhileDraining(t *testing.T) {
+	rd := &Readiness{}
+
+	rr := httptest.NewRecorder()
+	rd.ServeHTTP(rr, httptest.NewRequest("GET", "/ready", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected 200 before draining, got %d", rr.Code)
+	}
+
+	rd.SetDraining()
+	rr = httptest.NewRecorder()
+	rd.ServeHTTP(rr, httptest.NewRequest("GET", "/ready", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 while draining, got %d", rr.Code)
+	}
+}
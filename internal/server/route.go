@@ -0,0 +1,83 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/novaru/golem/internal/balancer"
+	"github.com/novaru/golem/internal/tlsutil"
+)
+
+// Route selects a subset of requests by host and path prefix and decides how
+// they are proxied.
+type Route struct {
+	Name       string
+	Host       string
+	PathPrefix string
+
+	// Balancer picks backends for the route. When nil the proxy's default
+	// balancer is used.
+	Balancer balancer.Balancer
+
+	// Headers holds the header rewrites applied to requests and responses on
+	// this route. It may be nil.
+	Headers *HeaderRules
+
+	// HostPolicy overrides the proxy's Host header policy when set.
+	HostPolicy HostPolicy
+
+	// Streaming marks routes serving long-lived responses. They are not
+	// subject to a request timeout.
+	Streaming bool
+	// Timeout overrides the proxy's request timeout on non-streaming routes.
+	Timeout time.Duration
+	// FlushInterval overrides the proxy's flush interval when non-zero.
+	FlushInterval time.Duration
+
+	// SSE configures heartbeats and reconnect stickiness for Server-Sent
+	// Events served on this route. It may be nil.
+	SSE *SSEOptions
+
+	// GRPC enables gRPC-aware proxying for gRPC calls on this route: backend
+	// failures are reported as gRPC statuses, grpc-timeout is honored as a
+	// deadline and calls are measured per service and method.
+	GRPC bool
+	// GRPCMethods lists the gRPC services and methods measured under their
+	// own names, as "package.Service" or "package.Service/Method". Other
+	// calls are measured as "other", so clients cannot create metric series
+	// at will.
+	GRPCMethods []string
+
+	// ClientCert, when set, requires a verified client certificate accepted
+	// by the policy. Other requests are rejected with 403 Forbidden. The
+	// listener must request client certificates for any to be present.
+	ClientCert *tlsutil.CertPolicy
+}
+
+// Matches reports whether r belongs to the route.
+func (rt *Route) Matches(r *http.Request) bool {
+	if rt.Host != "" && !strings.EqualFold(rt.Host, hostWithoutPort(r.Host)) {
+		return false
+	}
+	return strings.HasPrefix(r.URL.Path, rt.PathPrefix)
+}
+
+// matchRoute returns the first route matching r, or nil if none does.
+func (ps *ProxyServer) matchRoute(r *http.Request) *Route {
+	for _, rt := range ps.Routes {
+		if rt.Matches(r) {
+			return rt
+		}
+	}
+	return nil
+}
+
+// hostWithoutPort strips an optional port from a host header value.
+func hostWithoutPort(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRouteMatches(t *testing.T) {
+	tests := []struct {
+		name   string
+		route  Route
+		target string
+		want   bool
+	}{
+		{"empty route matches everything", Route{}, "http://a.example.com/x", true},
+		{"path prefix", Route{PathPrefix: "/api"}, "http://a.example.com/api/v1", true},
+		{"path prefix mismatch", Route{PathPrefix: "/api"}, "http://a.example.com/web", false},
+		{"host ignores port and case", Route{Host: "A.example.com"}, "http://a.example.com:8080/", true},
+		{"host mismatch", Route{Host: "b.example.com"}, "http://a.example.com/", false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tc.target, nil)
+			if got := tc.route.Matches(req); got != tc.want {
+				t.Errorf("Matches(%s) = %v, want %v", tc.target, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMatchRouteFirstWins(t *testing.T) {
+	ps := &ProxyServer{Routes: []*Route{
+		{Name: "api", PathPrefix: "/api"},
+		{Name: "all"},
+	}}
+
+	if rt := ps.matchRoute(httptest.NewRequest("GET", "/api/x", nil)); rt == nil || rt.Name != "api" {
+		t.Errorf("expected api route, got %+v", rt)
+	}
+	if rt := ps.matchRoute(httptest.NewRequest("GET", "/home", nil)); rt == nil || rt.Name != "all" {
+		t.Errorf("expected catch-all route, got %+v", rt)
+	}
+}
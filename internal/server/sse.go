@@ -0,0 +1,176 @@
+package server
+
+import (
+	"errors"
+	"hash/fnv"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/novaru/golem/internal/balancer"
+	"github.com/novaru/golem/internal/metrics"
+)
+
+// sseBackendCookie remembers which backend served an event stream so that a
+// reconnecting client can be sent back to it.
+const sseBackendCookie = "golem_sse_backend"
+
+// sseHeartbeat is a comment line; EventSource clients ignore it.
+const sseHeartbeat = ": heartbeat\n"
+
+// SSEOptions configures Server-Sent Events handling on a route.
+type SSEOptions struct {
+	// HeartbeatInterval sends a comment line to the client whenever the
+	// stream has been idle this long, keeping intermediaries from closing
+	// the connection. Zero disables heartbeats.
+	HeartbeatInterval time.Duration
+
+	// Sticky sends reconnecting clients, those presenting a Last-Event-ID,
+	// back to the backend that served their previous stream as long as it is
+	// healthy, so the backend can resume from that event.
+	Sticky bool
+}
+
+// isEventStream reports whether h describes a Server-Sent Events response.
+func isEventStream(h http.Header) bool {
+	mediaType, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	return mediaType == "text/event-stream"
+}
+
+// backendID returns an opaque identifier for a backend that is safe to hand
+// to clients without revealing the backend's address.
+func backendID(backendURL string) string {
+	h := fnv.New64a()
+	io.WriteString(h, backendURL)
+	return strconv.FormatUint(h.Sum64(), 36)
+}
+
+// stickyBackend returns the backend a reconnecting event stream client was
+// previously pinned to, or nil if there is none or it is no longer healthy.
+// Draining backends still take their pinned clients; backends set down do
+// not. The client's Last-Event-ID itself is forwarded unchanged as an
+// end-to-end header.
+func stickyBackend(r *http.Request, bal balancer.Balancer) *balancer.Backend {
+	if r.Header.Get("Last-Event-ID") == "" {
+		return nil
+	}
+	cookie, err := r.Cookie(sseBackendCookie)
+	if err != nil {
+		return nil
+	}
+	for _, b := range bal.Backends() {
+		if backendID(b.URL) == cookie.Value && b.IsHealthy() && b.State() != balancer.StateDown {
+			return b
+		}
+	}
+	return nil
+}
+
+// setStickyCookie pins the client's future reconnects to backend.
+func setStickyCookie(w http.ResponseWriter, backend *balancer.Backend) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sseBackendCookie,
+		Value:    backendID(backend.URL),
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// copyEventStream copies an event stream from src to w, flushing after every
+// write. When heartbeat is positive, a comment line is written whenever the
+// stream has been idle for that long. Heartbeats are only inserted at line
+// boundaries so they can never split a field of an event in progress.
+func copyEventStream(w http.ResponseWriter, src io.Reader, heartbeat time.Duration, backendURL string) error {
+	ew := &eventStreamWriter{
+		dst:         w,
+		flush:       http.NewResponseController(w).Flush,
+		lastWrite:   time.Now(),
+		atLineStart: true,
+	}
+
+	if heartbeat > 0 {
+		done := make(chan struct{})
+		stopped := make(chan struct{})
+		// The heartbeat must not write once the handler has returned.
+		defer func() {
+			close(done)
+			<-stopped
+		}()
+		go func() {
+			defer close(stopped)
+			ticker := time.NewTicker(heartbeat / 2)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					if ew.heartbeat(heartbeat) {
+						metrics.SSEHeartbeats.WithLabelValues(backendURL).Inc()
+					}
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, rerr := src.Read(buf)
+		if n > 0 {
+			if werr := ew.write(buf[:n]); werr != nil {
+				return errors.Join(errClientWrite, werr)
+			}
+		}
+		if rerr == io.EOF {
+			return nil
+		}
+		if rerr != nil {
+			return rerr
+		}
+	}
+}
+
+// eventStreamWriter serializes backend data and heartbeats onto the client
+// connection.
+type eventStreamWriter struct {
+	dst   io.Writer
+	flush func() error
+
+	mu          sync.Mutex
+	lastWrite   time.Time
+	atLineStart bool
+}
+
+func (e *eventStreamWriter) write(p []byte) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, err := e.dst.Write(p); err != nil {
+		return err
+	}
+	e.flush()
+	e.lastWrite = time.Now()
+	e.atLineStart = p[len(p)-1] == '\n'
+	return nil
+}
+
+// heartbeat writes a heartbeat comment if the stream has been idle for at
+// least interval and is at a line boundary. It reports whether one was sent.
+func (e *eventStreamWriter) heartbeat(interval time.Duration) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if !e.atLineStart || time.Since(e.lastWrite) < interval {
+		return false
+	}
+	if _, err := io.WriteString(e.dst, sseHeartbeat); err != nil {
+		return false
+	}
+	e.flush()
+	e.lastWrite = time.Now()
+	return true
+}
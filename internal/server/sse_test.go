@@ -0,0 +1,238 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/novaru/golem/internal/balancer"
+)
+
+func TestEventStreamWriterHeartbeatOnlyAtLineStart(t *testing.T) {
+	var out strings.Builder
+	ew := &eventStreamWriter{
+		dst:         &out,
+		flush:       func() error { return nil },
+		atLineStart: true,
+	}
+
+	ew.write([]byte("data: partial"))
+	ew.lastWrite = time.Now().Add(-time.Minute)
+	if ew.heartbeat(time.Second) {
+		t.Error("heartbeat must not be inserted in the middle of a line")
+	}
+
+	ew.write([]byte(" line\n\n"))
+	if ew.heartbeat(time.Second) {
+		t.Error("heartbeat must not be sent before the stream was idle")
+	}
+
+	ew.lastWrite = time.Now().Add(-time.Minute)
+	if !ew.heartbeat(time.Second) {
+		t.Error("expected a heartbeat on an idle stream")
+	}
+	if out.String() != "data: partial line\n\n"+sseHeartbeat {
+		t.Errorf("unexpected stream %q", out.String())
+	}
+}
+
+func TestProxySSEHeartbeat(t *testing.T) {
+	release := make(chan struct{})
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: hello\n\n")
+		w.(http.Flusher).Flush()
+		<-release
+	}))
+	defer backendServer.Close()
+	defer close(release)
+
+	bal, _ := balancer.NewBalancer("roundrobin", []*balancer.Backend{
+		balancer.NewBackend(backendServer.URL, 1),
+	})
+	proxy := NewProxyServer(bal)
+	proxy.Routes = []*Route{{
+		Name:      "events",
+		Streaming: true,
+		SSE:       &SSEOptions{HeartbeatInterval: 50 * time.Millisecond},
+	}}
+	front := httptest.NewServer(proxy)
+	defer front.Close()
+
+	resp, err := http.Get(front.URL + "/events")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	lines := make(chan string, 10)
+	go func() {
+		br := bufio.NewReader(resp.Body)
+		for {
+			line, err := br.ReadString('\n')
+			if err != nil {
+				return
+			}
+			lines <- line
+		}
+	}()
+
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case line := <-lines:
+			if line == sseHeartbeat {
+				return
+			}
+		case <-timeout:
+			t.Fatal("no heartbeat received on idle event stream")
+		}
+	}
+}
+
+func TestProxySSERouteHasNoTimeout(t *testing.T) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := range 3 {
+			fmt.Fprintf(w, "data: %d\n\n", i)
+			w.(http.Flusher).Flush()
+			time.Sleep(100 * time.Millisecond)
+		}
+	}))
+	defer backendServer.Close()
+
+	bal, _ := balancer.NewBalancer("roundrobin", []*balancer.Backend{
+		balancer.NewBackend(backendServer.URL, 1),
+	})
+	proxy := NewProxyServer(bal)
+	proxy.Timeout = 150 * time.Millisecond
+	proxy.Routes = []*Route{{Name: "events", PathPrefix: "/events", SSE: &SSEOptions{}}}
+
+	rr := httptest.NewRecorder()
+	proxy.ServeHTTP(rr, httptest.NewRequest("GET", "/events", nil))
+	if got := strings.Count(rr.Body.String(), "data: "); got != 3 {
+		t.Errorf("expected all 3 events past the request timeout, got %d: %q", got, rr.Body.String())
+	}
+}
+
+func TestProxyEventStreamOutlivesTimeoutWithoutSSERoute(t *testing.T) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := range 3 {
+			fmt.Fprintf(w, "data: %d\n\n", i)
+			w.(http.Flusher).Flush()
+			time.Sleep(100 * time.Millisecond)
+		}
+	}))
+	defer backendServer.Close()
+
+	bal, _ := balancer.NewBalancer("roundrobin", []*balancer.Backend{
+		balancer.NewBackend(backendServer.URL, 1),
+	})
+	proxy := NewProxyServer(bal)
+	proxy.Timeout = 150 * time.Millisecond
+
+	rr := httptest.NewRecorder()
+	proxy.ServeHTTP(rr, httptest.NewRequest("GET", "/events", nil))
+	if got := strings.Count(rr.Body.String(), "data: "); got != 3 {
+		t.Errorf("expected all 3 events past the request timeout, got %d: %q", got, rr.Body.String())
+	}
+}
+
+func TestProxySSEStickyReconnect(t *testing.T) {
+	var mu sync.Mutex
+	lastEventIDs := map[string]string{}
+
+	newEventBackend := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			lastEventIDs[name] = r.Header.Get("Last-Event-ID")
+			mu.Unlock()
+			w.Header().Set("Content-Type", "text/event-stream")
+			io.WriteString(w, "id: 1\ndata: "+name+"\n\n")
+		}))
+	}
+	s1 := newEventBackend("one")
+	defer s1.Close()
+	s2 := newEventBackend("two")
+	defer s2.Close()
+
+	bal, _ := balancer.NewBalancer("roundrobin", []*balancer.Backend{
+		balancer.NewBackend(s1.URL, 1),
+		balancer.NewBackend(s2.URL, 1),
+	})
+	proxy := NewProxyServer(bal)
+	proxy.Routes = []*Route{{Name: "events", SSE: &SSEOptions{Sticky: true}}}
+
+	rr := httptest.NewRecorder()
+	proxy.ServeHTTP(rr, httptest.NewRequest("GET", "/events", nil))
+	cookies := rr.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != sseBackendCookie {
+		t.Fatalf("expected sticky cookie, got %v", cookies)
+	}
+	first := strings.TrimSpace(strings.SplitN(rr.Body.String(), "data: ", 2)[1])
+
+	// Round robin would alternate; the sticky cookie must win on reconnect.
+	for range 4 {
+		req := httptest.NewRequest("GET", "/events", nil)
+		req.Header.Set("Last-Event-ID", "1")
+		req.AddCookie(cookies[0])
+		rr = httptest.NewRecorder()
+		proxy.ServeHTTP(rr, req)
+
+		if !strings.Contains(rr.Body.String(), "data: "+first) {
+			t.Fatalf("reconnect went to a different backend: %q", rr.Body.String())
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if lastEventIDs[first] != "1" {
+		t.Errorf("expected Last-Event-ID to reach the backend, got %q", lastEventIDs[first])
+	}
+}
+
+func TestProxySSECountedAsStream(t *testing.T) {
+	release := make(chan struct{})
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: hi\n\n")
+		w.(http.Flusher).Flush()
+		<-release
+	}))
+	defer backendServer.Close()
+
+	backend := balancer.NewBackend(backendServer.URL, 1)
+	bal, _ := balancer.NewBalancer("leastconn", []*balancer.Backend{backend})
+	proxy := NewProxyServer(bal)
+	proxy.Routes = []*Route{{Name: "events", Streaming: true}}
+
+	done := make(chan struct{})
+	go func() {
+		proxy.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/events", nil))
+		close(done)
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for backend.GetStreams() != 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if backend.GetStreams() != 1 {
+		t.Fatalf("expected 1 stream, got %d", backend.GetStreams())
+	}
+	if backend.GetConnections() != 0 {
+		t.Errorf("expected the stream not to count as a connection, got %d", backend.GetConnections())
+	}
+
+	close(release)
+	<-done
+	if backend.GetStreams() != 0 {
+		t.Errorf("expected stream count to drop after the stream ended, got %d", backend.GetStreams())
+	}
+}
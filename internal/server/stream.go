@@ -0,0 +1,189 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultTimeout bounds a whole proxied exchange, including the response
+// body, on routes that are not marked as streaming.
+const defaultTimeout = 10 * time.Second
+
+// flushImmediately as a flush interval flushes after every write.
+const flushImmediately = -1
+
+// requestTimeout returns the deadline for a request on route. Zero means no
+// deadline, which is the case for streaming and Server-Sent Events routes.
+// Event-stream responses on other routes lift the deadline once their headers
+// arrive; see withRequestTimeout.
+func (ps *ProxyServer) requestTimeout(route *Route) time.Duration {
+	if route != nil {
+		if route.Streaming || route.SSE != nil {
+			return 0
+		}
+		if route.Timeout > 0 {
+			return route.Timeout
+		}
+	}
+	if ps.Timeout > 0 {
+		return ps.Timeout
+	}
+	return defaultTimeout
+}
+
+// withRequestTimeout returns a copy of ctx that is canceled once timeout
+// elapses, with context.DeadlineExceeded as its cause. Unlike
+// context.WithTimeout, the deadline can be lifted by calling stop, so an
+// event stream is not cut off on a route that was not configured for one.
+func withRequestTimeout(ctx context.Context, timeout time.Duration) (_ context.Context, stop func() bool, cancel context.CancelFunc) {
+	ctx, cancelCause := context.WithCancelCause(ctx)
+	timer := time.AfterFunc(timeout, func() { cancelCause(context.DeadlineExceeded) })
+	return ctx, timer.Stop, func() {
+		timer.Stop()
+		cancelCause(context.Canceled)
+	}
+}
+
+// flushInterval returns how often the response body should be flushed to the
+// client. Server-Sent Events, gRPC and responses of unknown length (chunked
+// streams) are flushed after every write so that each event or message reaches
+// the client as soon as the backend sends it. Other responses use the route's
+// or the proxy's configured interval; zero leaves flushing to net/http.
+func (ps *ProxyServer) flushInterval(route *Route, resp *http.Response) time.Duration {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	switch {
+	case mediaType == "text/event-stream":
+		return flushImmediately
+	case strings.HasPrefix(mediaType, "application/grpc"):
+		return flushImmediately
+	case resp.ContentLength == -1:
+		return flushImmediately
+	}
+	if route != nil && route.FlushInterval != 0 {
+		return route.FlushInterval
+	}
+	return ps.FlushInterval
+}
+
+// announceTrailers declares the trailer names the backend announced in the
+// client response header h, so net/http can send them after the body. It
+// returns the number of names declared.
+func announceTrailers(h http.Header, trailer http.Header) int {
+	if len(trailer) == 0 {
+		return 0
+	}
+	names := make([]string, 0, len(trailer))
+	for name := range trailer {
+		names = append(names, name)
+	}
+	h.Add("Trailer", strings.Join(names, ", "))
+	return len(names)
+}
+
+// copyTrailers sets the backend's trailers on the client response header h
+// once the body has been copied. Trailers the backend did not announce up
+// front use the http.TrailerPrefix form.
+func copyTrailers(h http.Header, trailer http.Header, announced int) {
+	for name, values := range trailer {
+		if len(trailer) != announced {
+			name = http.TrailerPrefix + name
+		}
+		for _, v := range values {
+			h.Add(name, v)
+		}
+	}
+}
+
+// errClientWrite marks errors writing the response to the client.
+var errClientWrite = errors.New("write to client failed")
+
+// copyResponse copies src to w, flushing according to flushInterval. It
+// returns errClientWrite if writing to the client failed, which callers use to
+// tell a departed client from a failing backend.
+func copyResponse(w http.ResponseWriter, src io.Reader, flushInterval time.Duration) error {
+	var dst io.Writer = w
+	if flushInterval != 0 {
+		mlw := &maxLatencyWriter{
+			dst:     w,
+			flush:   http.NewResponseController(w).Flush,
+			latency: flushInterval,
+		}
+		defer mlw.stop()
+		dst = mlw
+	}
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, rerr := src.Read(buf)
+		if n > 0 {
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				return errors.Join(errClientWrite, werr)
+			}
+		}
+		if rerr == io.EOF {
+			return nil
+		}
+		if rerr != nil {
+			return rerr
+		}
+	}
+}
+
+// maxLatencyWriter flushes writes to dst after at most latency. A negative
+// latency flushes after every write.
+type maxLatencyWriter struct {
+	dst     io.Writer
+	flush   func() error
+	latency time.Duration
+
+	mu           sync.Mutex // protects t, flushPending, and dst.Flush
+	t            *time.Timer
+	flushPending bool
+}
+
+func (m *maxLatencyWriter) Write(p []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n, err := m.dst.Write(p)
+	if m.latency < 0 {
+		m.flush()
+		return n, err
+	}
+	if m.flushPending {
+		return n, err
+	}
+	if m.t == nil {
+		m.t = time.AfterFunc(m.latency, m.delayedFlush)
+	} else {
+		m.t.Reset(m.latency)
+	}
+	m.flushPending = true
+	return n, err
+}
+
+func (m *maxLatencyWriter) delayedFlush() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.flushPending {
+		// stop was called or a write completed the flush already.
+		return
+	}
+	m.flush()
+	m.flushPending = false
+}
+
+func (m *maxLatencyWriter) stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.flushPending = false
+	if m.t != nil {
+		m.t.Stop()
+	}
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/novaru/golem/internal/balancer"
+)
+
+func TestFlushIntervalSelection(t *testing.T) {
+	ps := &ProxyServer{FlushInterval: 50 * time.Millisecond}
+	route := &Route{FlushInterval: 200 * time.Millisecond}
+
+	tests := []struct {
+		name          string
+		contentType   string
+		contentLength int64
+		route         *Route
+		want          time.Duration
+	}{
+		{"event stream", "text/event-stream; charset=utf-8", 100, nil, flushImmediately},
+		{"grpc", "application/grpc+proto", 100, nil, flushImmediately},
+		{"unknown length", "application/json", -1, nil, flushImmediately},
+		{"route interval", "application/json", 100, route, 200 * time.Millisecond},
+		{"proxy default", "text/html", 100, nil, 50 * time.Millisecond},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := &http.Response{
+				Header:        http.Header{"Content-Type": {tc.contentType}},
+				ContentLength: tc.contentLength,
+			}
+			if got := ps.flushInterval(tc.route, resp); got != tc.want {
+				t.Errorf("flushInterval = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestProxyStreamsEventsImmediately(t *testing.T) {
+	release := make(chan struct{})
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: first\n\n")
+		w.(http.Flusher).Flush()
+		<-release
+		io.WriteString(w, "data: second\n\n")
+	}))
+	defer backendServer.Close()
+	defer close(release)
+
+	bal, _ := balancer.NewBalancer("roundrobin", []*balancer.Backend{
+		balancer.NewBackend(backendServer.URL, 1),
+	})
+	front := httptest.NewServer(NewProxyServer(bal))
+	defer front.Close()
+
+	resp, err := http.Get(front.URL + "/events")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	got := make(chan string, 1)
+	go func() {
+		line, _ := bufio.NewReader(resp.Body).ReadString('\n')
+		got <- line
+	}()
+
+	select {
+	case line := <-got:
+		if line != "data: first\n" {
+			t.Errorf("unexpected first line %q", line)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("first event was not flushed to the client while the stream was open")
+	}
+}
+
+func TestProxyStreamPreservesBytes(t *testing.T) {
+	longLine := strings.Repeat("x", 100*1024)
+	payload := "crlf line\r\n" + longLine + "\nno trailing newline"
+
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, payload)
+		w.(http.Flusher).Flush()
+	}))
+	defer backendServer.Close()
+
+	bal, _ := balancer.NewBalancer("roundrobin", []*balancer.Backend{
+		balancer.NewBackend(backendServer.URL, 1),
+	})
+	proxy := NewProxyServer(bal)
+
+	rr := httptest.NewRecorder()
+	proxy.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+
+	if rr.Body.String() != payload {
+		t.Errorf("streamed body was altered: got %d bytes, want %d", rr.Body.Len(), len(payload))
+	}
+}
+
+func TestProxyStreamingRouteHasNoTimeout(t *testing.T) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		for range 3 {
+			io.WriteString(w, "tick\n")
+			w.(http.Flusher).Flush()
+			time.Sleep(100 * time.Millisecond)
+		}
+	}))
+	defer backendServer.Close()
+
+	bal, _ := balancer.NewBalancer("roundrobin", []*balancer.Backend{
+		balancer.NewBackend(backendServer.URL, 1),
+	})
+	proxy := NewProxyServer(bal)
+	proxy.Timeout = 150 * time.Millisecond
+	proxy.Routes = []*Route{{Name: "feed", PathPrefix: "/feed", Streaming: true}}
+
+	rr := httptest.NewRecorder()
+	proxy.ServeHTTP(rr, httptest.NewRequest("GET", "/feed", nil))
+	if got := strings.Count(rr.Body.String(), "tick"); got != 3 {
+		t.Errorf("streaming route: expected 3 ticks, got %d", got)
+	}
+
+	rr = httptest.NewRecorder()
+	proxy.ServeHTTP(rr, httptest.NewRequest("GET", "/other", nil))
+	if got := strings.Count(rr.Body.String(), "tick"); got >= 3 {
+		t.Errorf("non-streaming route: expected the timeout to cut the stream, got %d ticks", got)
+	}
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/novaru/golem/internal/balancer"
+	"github.com/novaru/golem/internal/iocount"
+	"github.com/novaru/golem/internal/metrics"
+)
+
+// UpgradeOptions bounds the lifetime of upgraded (e.g. WebSocket) connections.
+// A zero value disables the corresponding limit.
+type UpgradeOptions struct {
+	// IdleTimeout closes the connection when no bytes flowed in either
+	// direction for this long.
+	IdleTimeout time.Duration
+	// MaxDuration closes the connection once it has been open this long.
+	MaxDuration time.Duration
+}
+
+// upgradeType returns the protocol requested in the Upgrade header, or "" if
+// the header does not ask for a protocol upgrade.
+func upgradeType(h http.Header) string {
+	if !headerHasToken(h, "Connection", "upgrade") {
+		return ""
+	}
+	return h.Get("Upgrade")
+}
+
+// headerHasToken reports whether any value of the comma-separated header name
+// contains token, compared case-insensitively.
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, v := range strings.Split(value, ",") {
+			if strings.EqualFold(textproto.TrimString(v), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// serveUpgrade completes a 101 Switching Protocols exchange: it hijacks the
+// client connection, relays the backend's handshake response and then copies
+// bytes in both directions until either side closes or a timeout fires.
+// Errors before the hijack are reported to the client with an error status.
+func (ps *ProxyServer) serveUpgrade(w http.ResponseWriter, r *http.Request, resp *http.Response, backend *balancer.Backend) {
+	if err := ps.relayUpgrade(w, r, resp, backend); err != nil {
+		metrics.RequestFailures.WithLabelValues(backend.URL, r.Method, "upgrade_failed").Inc()
+		log.Printf("[ERROR] Upgrade to %s failed: %v", backend.URL, err)
+	}
+}
+
+func (ps *ProxyServer) relayUpgrade(w http.ResponseWriter, r *http.Request, resp *http.Response, backend *balancer.Backend) error {
+	reqUpType := upgradeType(r.Header)
+	resUpType := upgradeType(resp.Header)
+	if !strings.EqualFold(reqUpType, resUpType) {
+		http.Error(w, "Backend switched to an unexpected protocol", http.StatusBadGateway)
+		return fmt.Errorf("backend tried to switch protocol %q when %q was requested", resUpType, reqUpType)
+	}
+
+	backConn, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		http.Error(w, "Backend unavailable", http.StatusBadGateway)
+		return errors.New("101 switching protocols response with non-writable body")
+	}
+	defer backConn.Close()
+
+	clientConn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, "Protocol upgrade not supported", http.StatusInternalServerError)
+		return fmt.Errorf("hijack failed: %w", err)
+	}
+	defer clientConn.Close()
+
+	removeHopByHopHeaders(resp.Header)
+	resp.Header.Set("Connection", "Upgrade")
+	resp.Header.Set("Upgrade", resUpType)
+	addVia(resp.Header, resp.ProtoMajor, resp.ProtoMinor)
+
+	// net/http no longer owns the connection once it is hijacked, so the
+	// handshake response is written by hand.
+	if _, err := fmt.Fprintf(brw, "HTTP/1.1 %s\r\n", resp.Status); err != nil {
+		return err
+	}
+	if err := resp.Header.Write(brw); err != nil {
+		return err
+	}
+	if _, err := brw.WriteString("\r\n"); err != nil {
+		return err
+	}
+	if err := brw.Flush(); err != nil {
+		return err
+	}
+
+	metrics.UpgradedConnections.WithLabelValues(backend.URL).Inc()
+	defer metrics.UpgradedConnections.WithLabelValues(backend.URL).Dec()
+
+	log.Printf("[INFO] Upgraded connection to %s (%s)", backend.URL, resUpType)
+
+	var closeOnce sync.Once
+	closeBoth := func() {
+		closeOnce.Do(func() {
+			clientConn.Close()
+			backConn.Close()
+		})
+	}
+
+	var idle *time.Timer
+	if ps.Upgrade.IdleTimeout > 0 {
+		idle = time.AfterFunc(ps.Upgrade.IdleTimeout, closeBoth)
+		defer idle.Stop()
+	}
+	if ps.Upgrade.MaxDuration > 0 {
+		limit := time.AfterFunc(ps.Upgrade.MaxDuration, closeBoth)
+		defer limit.Stop()
+	}
+	touch := func() {
+		if idle != nil {
+			idle.Reset(ps.Upgrade.IdleTimeout)
+		}
+	}
+
+	toBackend := &iocount.Writer{
+		W:       backConn,
+		Counter: metrics.UpgradedBytes.WithLabelValues(backend.URL, "to_backend"),
+		Touch:   touch,
+	}
+	toClient := &iocount.Writer{
+		W:       clientConn,
+		Counter: metrics.UpgradedBytes.WithLabelValues(backend.URL, "to_client"),
+		Touch:   touch,
+	}
+
+	errc := make(chan error, 2)
+	go func() {
+		// brw may already hold bytes the client sent after the handshake.
+		_, err := io.Copy(toBackend, brw.Reader)
+		errc <- err
+	}()
+	go func() {
+		_, err := io.Copy(toClient, backConn)
+		errc <- err
+	}()
+
+	// Either side finishing ends the exchange; closing both connections
+	// unblocks the other copy.
+	<-errc
+	closeBoth()
+	<-errc
+	return nil
+}
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/novaru/golem/internal/balancer"
+)
+
+// echoUpgradeHandler completes an "echo" protocol upgrade and then echoes
+// every byte it receives.
+func echoUpgradeHandler(t *testing.T) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if upgradeType(r.Header) != "echo" {
+			http.Error(w, "upgrade required", http.StatusUpgradeRequired)
+			return
+		}
+		conn, brw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Errorf("backend hijack failed: %v", err)
+			return
+		}
+		defer conn.Close()
+		brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		brw.Flush()
+		io.Copy(conn, brw)
+	}
+}
+
+// dialUpgrade performs an upgrade handshake against addr and returns the
+// connection positioned after the response headers.
+func dialUpgrade(t *testing.T, addr string) (net.Conn, *bufio.Reader, *http.Response) {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	io.WriteString(conn, "GET /ws HTTP/1.1\r\nHost: golem\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("failed to read handshake response: %v", err)
+	}
+	return conn, br, resp
+}
+
+func newUpgradeProxy(t *testing.T) (*ProxyServer, *balancer.Backend, *httptest.Server) {
+	t.Helper()
+
+	proxy, backend := newTestProxy(t, echoUpgradeHandler(t))
+	front := httptest.NewServer(proxy)
+	t.Cleanup(front.Close)
+	return proxy, backend, front
+}
+
+func TestProxyUpgradeEcho(t *testing.T) {
+	_, backend, front := newUpgradeProxy(t)
+
+	conn, br, resp := dialUpgrade(t, front.Listener.Addr().String())
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected 101, got %d", resp.StatusCode)
+	}
+	if !strings.EqualFold(resp.Header.Get("Upgrade"), "echo") {
+		t.Errorf("expected Upgrade: echo, got %q", resp.Header.Get("Upgrade"))
+	}
+
+	io.WriteString(conn, "ping\n")
+	line, err := br.ReadString('\n')
+	if err != nil || line != "ping\n" {
+		t.Fatalf("expected echo of ping, got %q (%v)", line, err)
+	}
+
+	if backend.GetConnections() != 1 {
+		t.Errorf("expected upgraded connection to be counted, got %d", backend.GetConnections())
+	}
+
+	conn.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for backend.GetConnections() != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if backend.GetConnections() != 0 {
+		t.Errorf("expected connection count to drop after close, got %d", backend.GetConnections())
+	}
+}
+
+func TestProxyUpgradeIdleTimeout(t *testing.T) {
+	proxy, _, front := newUpgradeProxy(t)
+	proxy.Upgrade.IdleTimeout = 100 * time.Millisecond
+
+	conn, br, resp := dialUpgrade(t, front.Listener.Addr().String())
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected 101, got %d", resp.StatusCode)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := br.ReadByte(); err == nil {
+		t.Fatal("expected idle connection to be closed by the proxy")
+	} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Fatal("idle timeout did not close the connection")
+	}
+}
+
+func TestProxyUpgradeMaxDuration(t *testing.T) {
+	proxy, _, front := newUpgradeProxy(t)
+	proxy.Upgrade.MaxDuration = 200 * time.Millisecond
+
+	conn, br, _ := dialUpgrade(t, front.Listener.Addr().String())
+
+	// Keep the connection busy; it must still be closed at the deadline.
+	start := time.Now()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		if _, err := io.WriteString(conn, "x"); err != nil {
+			break
+		}
+		if _, err := br.ReadByte(); err != nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("connection outlived its max duration (%v)", elapsed)
+	}
+}
+
+func TestProxyUpgradeRejectedByBackend(t *testing.T) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer backendServer.Close()
+
+	bal, _ := balancer.NewBalancer("roundrobin", []*balancer.Backend{
+		balancer.NewBackend(backendServer.URL, 1),
+	})
+	front := httptest.NewServer(NewProxyServer(bal))
+	defer front.Close()
+
+	_, _, resp := dialUpgrade(t, front.Listener.Addr().String())
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected backend status 403 to be relayed, got %d", resp.StatusCode)
+	}
+}
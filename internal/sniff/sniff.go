@@ -0,0 +1,211 @@
+// Package sniff tells HTTP, TLS and other TCP connections apart by their
+// first bytes, so that one port can serve all of them.
+package sniff
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/novaru/golem/internal/metrics"
+)
+
+// Protocol is a detected connection protocol.
+type Protocol string
+
+// Detected protocols.
+const (
+	HTTP Protocol = "http"
+	TLS  Protocol = "tls"
+	TCP  Protocol = "tcp"
+)
+
+// defaultTimeout bounds the wait for a client's first bytes.
+const defaultTimeout = time.Second
+
+// httpPrefixes start HTTP/1.x requests and the HTTP/2 connection preface.
+var httpPrefixes = [][]byte{
+	[]byte("GET "), []byte("HEAD "), []byte("POST "), []byte("PUT "),
+	[]byte("DELETE "), []byte("OPTIONS "), []byte("PATCH "),
+	[]byte("CONNECT "), []byte("TRACE "), []byte("PRI * HTTP/2"),
+}
+
+// detect classifies a connection from its first bytes. It reports false
+// while more bytes are needed to decide.
+func detect(b []byte) (Protocol, bool) {
+	if len(b) == 0 {
+		return "", false
+	}
+	// A TLS record: handshake content type, then major version 3.
+	if b[0] == 0x16 {
+		if len(b) < 2 {
+			return "", false
+		}
+		if b[1] == 0x03 {
+			return TLS, true
+		}
+		return TCP, true
+	}
+	possible := false
+	for _, prefix := range httpPrefixes {
+		if bytes.HasPrefix(b, prefix) {
+			return HTTP, true
+		}
+		if bytes.HasPrefix(prefix, b) {
+			possible = true
+		}
+	}
+	if possible {
+		return "", false
+	}
+	return TCP, true
+}
+
+// Mux accepts connections on a listener and hands each one to the listener
+// of its detected protocol. Connections of protocols without a listener are
+// closed. Clients that send nothing within Timeout, as in protocols where the
+// server speaks first, are taken as TCP.
+type Mux struct {
+	ln   net.Listener
+	name string
+	// Timeout bounds the wait for the first bytes. Default 1 second.
+	Timeout time.Duration
+
+	listeners map[Protocol]*listener
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewMux creates a Mux for ln. name labels its metrics.
+func NewMux(ln net.Listener, name string) *Mux {
+	return &Mux{
+		ln:        ln,
+		name:      name,
+		Timeout:   defaultTimeout,
+		listeners: make(map[Protocol]*listener),
+		done:      make(chan struct{}),
+	}
+}
+
+// Listener returns the listener receiving connections of protocol p. It must
+// be called before Serve.
+func (m *Mux) Listener(p Protocol) net.Listener {
+	if l, ok := m.listeners[p]; ok {
+		return l
+	}
+	l := &listener{mux: m, conns: make(chan net.Conn), closed: make(chan struct{})}
+	m.listeners[p] = l
+	return l
+}
+
+// Serve accepts connections until the underlying listener fails or the Mux
+// is closed. Detection runs concurrently, so slow clients do not hold up
+// others.
+func (m *Mux) Serve() error {
+	for {
+		c, err := m.ln.Accept()
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			m.Close()
+			return err
+		}
+		go m.dispatch(c)
+	}
+}
+
+func (m *Mux) dispatch(c net.Conn) {
+	conn := &Conn{Conn: c, reader: bufio.NewReader(c)}
+	p := m.detect(conn)
+	metrics.SniffedConnections.WithLabelValues(m.name, string(p)).Inc()
+
+	l, ok := m.listeners[p]
+	if !ok {
+		c.Close()
+		return
+	}
+	select {
+	case l.conns <- conn:
+	case <-l.closed:
+		c.Close()
+	case <-m.done:
+		c.Close()
+	}
+}
+
+// detect reads from conn until its protocol is known or the timeout passes.
+func (m *Mux) detect(conn *Conn) Protocol {
+	conn.SetReadDeadline(time.Now().Add(m.Timeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	for n := 1; ; n++ {
+		b, err := conn.reader.Peek(n)
+		if p, ok := detect(b); ok {
+			return p
+		}
+		if err != nil {
+			return TCP
+		}
+	}
+}
+
+// Close closes the underlying listener and every protocol listener.
+func (m *Mux) Close() error {
+	m.closeOnce.Do(func() { close(m.done) })
+	return m.ln.Close()
+}
+
+// listener delivers the connections of one protocol.
+type listener struct {
+	mux       *Mux
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	case <-l.mux.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops delivering connections to l. The Mux keeps serving the other
+// protocols.
+func (l *listener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *listener) Addr() net.Addr {
+	return l.mux.ln.Addr()
+}
+
+// Conn replays the bytes read during detection before the rest of the
+// connection.
+type Conn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// CloseWrite shuts down the write half of the underlying connection, if it
+// supports it.
+func (c *Conn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package sniff
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		data    string
+		want    Protocol
+		decided bool
+	}{
+		{"", "", false},
+		{"GET / HTTP/1.1\r\n", HTTP, true},
+		{"OPTIONS * HTTP/1.1\r\n", HTTP, true},
+		{"PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n", HTTP, true},
+		{"PO", "", false},
+		{"\x16", "", false},
+		{"\x16\x03\x01\x02\x00", TLS, true},
+		{"\x16\x01", TCP, true},
+		{"SSH-2.0-OpenSSH_9.6\r\n", TCP, true},
+		{"GETX", TCP, true},
+		{"\x00\x00\x00\x08\x04\xd2\x16\x2f", TCP, true},
+	}
+	for _, tt := range tests {
+		got, decided := detect([]byte(tt.data))
+		if got != tt.want || decided != tt.decided {
+			t.Errorf("detect(%q) = %q, %v; want %q, %v", tt.data, got, decided, tt.want, tt.decided)
+		}
+	}
+}
+
+// newMux serves a Mux on loopback with listeners for protocols, returning
+// its address.
+func newMux(t *testing.T, protocols ...Protocol) (string, map[Protocol]net.Listener) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := NewMux(ln, "test")
+	mux.Timeout = 100 * time.Millisecond
+	listeners := make(map[Protocol]net.Listener)
+	for _, p := range protocols {
+		listeners[p] = mux.Listener(p)
+	}
+	go mux.Serve()
+	t.Cleanup(func() { mux.Close() })
+	return ln.Addr().String(), listeners
+}
+
+func accept(t *testing.T, ln net.Listener) net.Conn {
+	t.Helper()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err == nil {
+			accepted <- c
+		}
+	}()
+	select {
+	case c := <-accepted:
+		t.Cleanup(func() { c.Close() })
+		return c
+	case <-time.After(2 * time.Second):
+		t.Fatal("no connection dispatched")
+		return nil
+	}
+}
+
+func dial(t *testing.T, addr, data string) net.Conn {
+	t.Helper()
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	io.WriteString(c, data)
+	return c
+}
+
+func TestMuxDispatchesByProtocol(t *testing.T) {
+	addr, listeners := newMux(t, HTTP, TLS, TCP)
+
+	tests := []struct {
+		data string
+		p    Protocol
+	}{
+		{"GET / HTTP/1.1\r\n\r\n", HTTP},
+		{"\x16\x03\x01\x00\x05hello", TLS},
+		{"SSH-2.0-client\r\n", TCP},
+	}
+	for _, tt := range tests {
+		dial(t, addr, tt.data)
+		c := accept(t, listeners[tt.p])
+
+		// The bytes read during detection are replayed.
+		buf := make([]byte, len(tt.data))
+		c.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := io.ReadFull(c, buf); err != nil || string(buf) != tt.data {
+			t.Errorf("%s: read %q, %v; want %q", tt.p, buf, err, tt.data)
+		}
+	}
+}
+
+func TestMuxSilentClientIsTCP(t *testing.T) {
+	addr, listeners := newMux(t, HTTP, TCP)
+
+	// Protocols where the server speaks first send nothing.
+	client := dial(t, addr, "")
+	c := accept(t, listeners[TCP])
+	io.WriteString(c, "220 ready\r\n")
+
+	buf := make([]byte, 11)
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadFull(client, buf); err != nil || string(buf) != "220 ready\r\n" {
+		t.Errorf("expected the server greeting, got %q, %v", buf, err)
+	}
+}
+
+func TestMuxClosesUnhandledProtocols(t *testing.T) {
+	addr, _ := newMux(t, HTTP)
+
+	client := dial(t, addr, "\x16\x03\x01\x00\x05hello")
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected TLS connection to be closed, got %v", err)
+	}
+}
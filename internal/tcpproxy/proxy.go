@@ -0,0 +1,245 @@
+// Package tcpproxy balances raw TCP connections across backends, for
+// services that do not speak HTTP.
+package tcpproxy
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/novaru/golem/internal/balancer"
+	"github.com/novaru/golem/internal/iocount"
+	"github.com/novaru/golem/internal/metrics"
+	"github.com/novaru/golem/internal/proxyproto"
+)
+
+// defaultDialTimeout bounds connecting to a backend.
+const defaultDialTimeout = 10 * time.Second
+
+// Proxy accepts client connections and relays each one to a backend chosen
+// by Balancer. Backends are addressed as "tcp://host:port".
+type Proxy struct {
+	// Name labels the listener in logs.
+	Name     string
+	Balancer balancer.Balancer
+
+	// Routes passes TLS through without terminating it, choosing the
+	// balancer by the server name in the client's ClientHello. Clients
+	// matching no route use Balancer, or are rejected if it is nil.
+	Routes []SNIRoute
+	// HelloTimeout bounds reading the ClientHello. Default 5 seconds.
+	HelloTimeout time.Duration
+
+	// IdleTimeout closes a connection when no bytes flowed in either
+	// direction for this long. Zero disables it.
+	IdleTimeout time.Duration
+	// DialTimeout bounds connecting to a backend. Default 10 seconds.
+	DialTimeout time.Duration
+	// ProxyProtocol sends a PROXY protocol header of this version
+	// (proxyproto.V1 or V2) to the backend, describing the client
+	// connection. Default 0 (off).
+	ProxyProtocol int
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	closed    bool
+	active    atomic.Int64
+}
+
+// Serve accepts connections on ln until it is closed, proxying each one in
+// its own goroutine.
+func (p *Proxy) Serve(ln net.Listener) error {
+	if !p.track(ln) {
+		ln.Close()
+		return net.ErrClosed
+	}
+	defer p.untrack(ln)
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+		go p.ServeConn(conn)
+	}
+}
+
+// track registers ln for Close, reporting false if the proxy is closed.
+func (p *Proxy) track(ln net.Listener) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return false
+	}
+	if p.listeners == nil {
+		p.listeners = make(map[net.Listener]struct{})
+	}
+	p.listeners[ln] = struct{}{}
+	return true
+}
+
+func (p *Proxy) untrack(ln net.Listener) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.listeners, ln)
+}
+
+// Close stops accepting connections by closing every listener being served.
+// Connections already accepted keep being relayed; Active counts them.
+func (p *Proxy) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	var err error
+	for ln := range p.listeners {
+		if cerr := ln.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Active returns the number of client connections being proxied.
+func (p *Proxy) Active() int64 {
+	return p.active.Load()
+}
+
+// ServeConn proxies a single client connection and closes it when done.
+func (p *Proxy) ServeConn(client net.Conn) {
+	p.active.Add(1)
+	defer p.active.Add(-1)
+	defer client.Close()
+
+	bal, proxyProtocol := p.Balancer, p.ProxyProtocol
+	var peeked []byte
+	if len(p.Routes) > 0 {
+		timeout := p.HelloTimeout
+		if timeout <= 0 {
+			timeout = defaultHelloTimeout
+		}
+		serverName, hello, err := peekServerName(client, timeout)
+		if err != nil {
+			metrics.TCPConnections.WithLabelValues("", "bad_client_hello").Inc()
+			log.Printf("[WARN] Invalid ClientHello on tcp listener %s from %s: %v", p.Name, client.RemoteAddr(), err)
+			return
+		}
+		route := p.route(serverName)
+		if bal, proxyProtocol = route.Balancer, route.ProxyProtocol; bal == nil {
+			metrics.TCPConnections.WithLabelValues("", "unknown_sni").Inc()
+			log.Printf("[WARN] No route for server name %q on tcp listener %s", serverName, p.Name)
+			return
+		}
+		peeked = hello
+	}
+
+	backend, err := bal.NextBackend()
+	if err != nil {
+		metrics.TCPConnections.WithLabelValues("", "no_backend").Inc()
+		log.Printf("[ERROR] No healthy backend for tcp listener %s", p.Name)
+		return
+	}
+
+	dialTimeout := p.DialTimeout
+	if dialTimeout <= 0 {
+		dialTimeout = defaultDialTimeout
+	}
+	addr := strings.TrimPrefix(backend.URL, balancer.TCPScheme)
+	server, err := net.DialTimeout("tcp", addr, dialTimeout)
+	if err != nil {
+		backend.SetHealth(false)
+		metrics.TCPConnections.WithLabelValues(backend.URL, "backend_unavailable").Inc()
+		log.Printf("[ERROR] Backend %s is unavailable: %v", backend.URL, err)
+		return
+	}
+	defer server.Close()
+	if proxyProtocol != 0 {
+		header := proxyproto.HeaderFor(client.RemoteAddr(), client.LocalAddr())
+		if _, err := server.Write(header.Format(proxyProtocol)); err != nil {
+			metrics.TCPConnections.WithLabelValues(backend.URL, "backend_unavailable").Inc()
+			log.Printf("[ERROR] Failed to send PROXY header to backend %s: %v", backend.URL, err)
+			return
+		}
+	}
+	metrics.TCPConnections.WithLabelValues(backend.URL, "success").Inc()
+
+	backend.AddConnections()
+	metrics.UpdateActiveConnections(backend.URL, float64(backend.GetConnections()))
+	defer func() {
+		backend.RemoveConnections()
+		metrics.UpdateActiveConnections(backend.URL, float64(backend.GetConnections()))
+	}()
+
+	p.relay(client, server, backend.URL, peeked)
+}
+
+// relay copies bytes in both directions, starting with the peeked bytes
+// already read from the client. When one side finishes sending, its write
+// half is closed on the other side, so protocols that half-close keep
+// receiving until the peer is done too.
+func (p *Proxy) relay(client, server net.Conn, backendURL string, peeked []byte) {
+	var closeOnce sync.Once
+	closeBoth := func() {
+		closeOnce.Do(func() {
+			client.Close()
+			server.Close()
+		})
+	}
+
+	touch := func() {}
+	if p.IdleTimeout > 0 {
+		idle := time.AfterFunc(p.IdleTimeout, closeBoth)
+		defer idle.Stop()
+		touch = func() { idle.Reset(p.IdleTimeout) }
+	}
+
+	toBackend := &iocount.Writer{
+		W:       server,
+		Counter: metrics.TCPBytes.WithLabelValues(backendURL, "to_backend"),
+		Touch:   touch,
+	}
+	toClient := &iocount.Writer{
+		W:       client,
+		Counter: metrics.TCPBytes.WithLabelValues(backendURL, "to_client"),
+		Touch:   touch,
+	}
+
+	errc := make(chan error, 2)
+	go func() {
+		_, err := toBackend.Write(peeked)
+		if err == nil {
+			_, err = io.Copy(toBackend, client)
+		}
+		closeWrite(server)
+		errc <- err
+	}()
+	go func() {
+		_, err := io.Copy(toClient, server)
+		closeWrite(client)
+		errc <- err
+	}()
+
+	// A failed copy ends the exchange at once; a clean EOF only ends it once
+	// the other direction is done as well.
+	if err := <-errc; err != nil {
+		closeBoth()
+	}
+	<-errc
+	closeBoth()
+}
+
+// closeWrite shuts down the write half of conn, signalling EOF to the peer,
+// if the connection supports it.
+func closeWrite(conn net.Conn) {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	}
+}
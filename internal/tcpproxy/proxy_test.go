@@ -0,0 +1,222 @@
+package tcpproxy
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/novaru/golem/internal/balancer"
+	"github.com/novaru/golem/internal/metrics"
+	"github.com/novaru/golem/internal/proxyproto"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// startBackend serves each connection with handle on a local port and
+// returns its tcp:// URL.
+func startBackend(t *testing.T, handle func(net.Conn)) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				handle(conn)
+			}()
+		}
+	}()
+	return balancer.TCPScheme + ln.Addr().String()
+}
+
+// startProxy serves p on a local port and returns its address.
+func startProxy(t *testing.T, p *Proxy) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go p.Serve(ln)
+	return ln.Addr().String()
+}
+
+// replyAfterEOF reads the whole request, then answers with its name and
+// the request.
+func replyAfterEOF(name string) func(net.Conn) {
+	return func(conn net.Conn) {
+		req, _ := io.ReadAll(conn)
+		conn.Write([]byte(name + ":" + string(req)))
+	}
+}
+
+func roundTrip(t *testing.T, addr, msg string) string {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.WriteString(conn, msg); err != nil {
+		t.Fatal(err)
+	}
+	// Half-close: the backend only answers once it has seen EOF.
+	conn.(*net.TCPConn).CloseWrite()
+	resp, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	return string(resp)
+}
+
+func TestProxyBalancesWithHalfClose(t *testing.T) {
+	urlA := startBackend(t, replyAfterEOF("a"))
+	urlB := startBackend(t, replyAfterEOF("b"))
+	bal, _ := balancer.NewBalancer("roundrobin", []*balancer.Backend{
+		balancer.NewBackend(urlA, 1),
+		balancer.NewBackend(urlB, 1),
+	})
+	addr := startProxy(t, &Proxy{Name: "test", Balancer: bal})
+
+	toBackend := metrics.TCPBytes.WithLabelValues(urlA, "to_backend")
+	toClient := metrics.TCPBytes.WithLabelValues(urlA, "to_client")
+	sentBefore, receivedBefore := testutil.ToFloat64(toBackend), testutil.ToFloat64(toClient)
+
+	got := map[string]bool{}
+	for range 2 {
+		got[roundTrip(t, addr, "ping")] = true
+	}
+	if !got["a:ping"] || !got["b:ping"] {
+		t.Errorf("expected one reply from each backend, got %v", got)
+	}
+
+	if d := testutil.ToFloat64(toBackend) - sentBefore; d != 4 {
+		t.Errorf("expected 4 bytes to backend a, got %v", d)
+	}
+	if d := testutil.ToFloat64(toClient) - receivedBefore; d != 6 {
+		t.Errorf("expected 6 bytes from backend a, got %v", d)
+	}
+}
+
+func TestProxyIdleTimeout(t *testing.T) {
+	url := startBackend(t, func(conn net.Conn) {
+		io.Copy(io.Discard, conn)
+	})
+	bal, _ := balancer.NewBalancer("roundrobin", []*balancer.Backend{balancer.NewBackend(url, 1)})
+	addr := startProxy(t, &Proxy{Balancer: bal, IdleTimeout: 50 * time.Millisecond})
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	start := time.Now()
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected the idle connection to be closed, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("idle timeout fired late: %v", elapsed)
+	}
+}
+
+func TestProxyMarksUnreachableBackendUnhealthy(t *testing.T) {
+	ln, _ := net.Listen("tcp", "127.0.0.1:0")
+	url := balancer.TCPScheme + ln.Addr().String()
+	ln.Close()
+
+	backend := balancer.NewBackend(url, 1)
+	bal, _ := balancer.NewBalancer("roundrobin", []*balancer.Backend{backend})
+	addr := startProxy(t, &Proxy{Balancer: bal})
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	io.ReadAll(conn)
+	conn.Close()
+
+	if backend.IsHealthy() {
+		t.Error("expected unreachable backend to be marked unhealthy")
+	}
+	if backend.GetConnections() != 0 {
+		t.Errorf("expected no connections left counted, got %d", backend.GetConnections())
+	}
+}
+
+func TestProxySendsProxyProtocolHeader(t *testing.T) {
+	headers := make(chan string, 1)
+	url := startBackend(t, func(conn net.Conn) {
+		h, err := proxyproto.Read(bufio.NewReader(conn))
+		if err != nil {
+			headers <- err.Error()
+			return
+		}
+		headers <- h.Source.Addr().String()
+	})
+	bal, _ := balancer.NewBalancer("roundrobin", []*balancer.Backend{balancer.NewBackend(url, 1)})
+	addr := startProxy(t, &Proxy{Balancer: bal, ProxyProtocol: proxyproto.V1})
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	select {
+	case got := <-headers:
+		if got != "127.0.0.1" {
+			t.Errorf("expected the client address in the header, got %q", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("backend received no header")
+	}
+}
+
+func TestProxyCloseKeepsActiveConnections(t *testing.T) {
+	url := startBackend(t, replyAfterEOF("b1"))
+	bal, _ := balancer.NewBalancer("roundrobin", []*balancer.Backend{balancer.NewBackend(url, 1)})
+	p := &Proxy{Name: "test", Balancer: bal}
+	addr := startProxy(t, p)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	io.WriteString(conn, "hello")
+	for p.Active() != 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	p.Close()
+	if c, err := net.Dial("tcp", addr); err == nil {
+		c.Close()
+		t.Error("expected new connections to be refused after Close")
+	}
+
+	// The accepted connection is still relayed to the end.
+	conn.(*net.TCPConn).CloseWrite()
+	if resp, _ := io.ReadAll(conn); string(resp) != "b1:hello" {
+		t.Errorf("expected b1:hello, got %q", resp)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for p.Active() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("connection still active after it ended")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
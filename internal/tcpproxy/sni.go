@@ -0,0 +1,88 @@
+package tcpproxy
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/novaru/golem/internal/balancer"
+)
+
+// defaultHelloTimeout bounds reading a client's ClientHello.
+const defaultHelloTimeout = 5 * time.Second
+
+// SNIRoute sends TLS connections for matching server names to Balancer.
+type SNIRoute struct {
+	// ServerName is a host name such as "api.example.com", or a wildcard
+	// such as "*.example.com" matching exactly one label.
+	ServerName string
+	Balancer   balancer.Balancer
+	// ProxyProtocol is the PROXY protocol version sent to the route's
+	// backends, as for Proxy.ProxyProtocol.
+	ProxyProtocol int
+}
+
+// matches reports whether the route applies to serverName.
+func (r SNIRoute) matches(serverName string) bool {
+	if suffix, ok := strings.CutPrefix(r.ServerName, "*."); ok {
+		_, rest, found := strings.Cut(serverName, ".")
+		return found && strings.EqualFold(rest, suffix)
+	}
+	return strings.EqualFold(r.ServerName, serverName)
+}
+
+// route returns the route for serverName. Exact names take precedence over
+// wildcards; otherwise the first matching route wins. It falls back to
+// p.Balancer, which may be nil.
+func (p *Proxy) route(serverName string) SNIRoute {
+	for _, r := range p.Routes {
+		if !strings.HasPrefix(r.ServerName, "*.") && r.matches(serverName) {
+			return r
+		}
+	}
+	for _, r := range p.Routes {
+		if r.matches(serverName) {
+			return r
+		}
+	}
+	return SNIRoute{Balancer: p.Balancer, ProxyProtocol: p.ProxyProtocol}
+}
+
+// errHelloRead aborts the handshake once the ClientHello has been parsed.
+var errHelloRead = errors.New("client hello read")
+
+// peekServerName reads the ClientHello from conn and returns the server name
+// the client asked for, which is empty if it sent no SNI, along with every
+// byte read so it can be replayed to the backend. Nothing is written to conn.
+func peekServerName(conn net.Conn, timeout time.Duration) (string, []byte, error) {
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	var peeked bytes.Buffer
+	var serverName string
+	var sawHello bool
+	config := &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverName, sawHello = hello.ServerName, true
+			return nil, errHelloRead
+		},
+	}
+	err := tls.Server(readOnlyConn{Conn: conn, r: io.TeeReader(conn, &peeked)}, config).Handshake()
+	if !sawHello {
+		return "", peeked.Bytes(), err
+	}
+	return serverName, peeked.Bytes(), nil
+}
+
+// readOnlyConn lets crypto/tls parse a ClientHello without answering it.
+type readOnlyConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c readOnlyConn) Read(p []byte) (int, error) { return c.r.Read(p) }
+func (c readOnlyConn) Write([]byte) (int, error)  { return 0, io.ErrClosedPipe }
@@ -0,0 +1,120 @@
+package tcpproxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/novaru/golem/internal/balancer"
+)
+
+// startTLSBackend terminates TLS with a self-signed certificate for name and
+// answers every connection with name. It returns the backend's balancer.
+func startTLSBackend(t *testing.T, name string) balancer.Balancer {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+
+	url := startBackend(t, func(conn net.Conn) {
+		tc := tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{cert}})
+		io.WriteString(tc, name)
+		tc.Close()
+	})
+	bal, _ := balancer.NewBalancer("roundrobin", []*balancer.Backend{balancer.NewBackend(url, 1)})
+	return bal
+}
+
+// dialSNI connects to the proxy with TLS for serverName and returns the
+// common name of the certificate presented and the backend's reply.
+func dialSNI(t *testing.T, addr, serverName string) (string, string, error) {
+	t.Helper()
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 2 * time.Second}, "tcp", addr, &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		return "", "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	reply, err := io.ReadAll(conn)
+	return conn.ConnectionState().PeerCertificates[0].Subject.CommonName, string(reply), err
+}
+
+func TestProxyRoutesBySNI(t *testing.T) {
+	addr := startProxy(t, &Proxy{
+		Name:     "passthrough",
+		Balancer: startTLSBackend(t, "default.example.com"),
+		Routes: []SNIRoute{
+			{ServerName: "*.example.com", Balancer: startTLSBackend(t, "wild.example.com")},
+			{ServerName: "api.example.com", Balancer: startTLSBackend(t, "api.example.com")},
+		},
+	})
+
+	tests := []struct {
+		serverName string
+		want       string
+	}{
+		{"api.example.com", "api.example.com"},
+		{"API.example.com", "api.example.com"},
+		{"www.example.com", "wild.example.com"},
+		{"a.b.example.com", "default.example.com"},
+		{"other.org", "default.example.com"},
+	}
+	for _, tt := range tests {
+		cn, reply, err := dialSNI(t, addr, tt.serverName)
+		if err != nil {
+			t.Errorf("%s: %v", tt.serverName, err)
+			continue
+		}
+		// The certificate comes from the backend: TLS was not terminated.
+		if cn != tt.want || reply != tt.want {
+			t.Errorf("%s: expected backend %s, got certificate %s and reply %q", tt.serverName, tt.want, cn, reply)
+		}
+	}
+}
+
+func TestProxyRejectsUnroutedConnections(t *testing.T) {
+	addr := startProxy(t, &Proxy{
+		Name:   "passthrough",
+		Routes: []SNIRoute{{ServerName: "api.example.com", Balancer: startTLSBackend(t, "api.example.com")}},
+	})
+
+	if _, _, err := dialSNI(t, addr, "other.org"); err == nil {
+		t.Error("expected connection for unknown server name to be rejected")
+	}
+
+	// Plaintext is not a ClientHello.
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: api.example.com\r\n\r\n")
+	if reply, _ := io.ReadAll(conn); len(reply) != 0 {
+		t.Errorf("expected plaintext connection to be closed, got %q", reply)
+	}
+}
@@ -0,0 +1,214 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/novaru/golem/internal/metrics"
+)
+
+// CertPair names a PEM certificate chain and its private key on disk.
+type CertPair struct {
+	CertFile string
+	KeyFile  string
+}
+
+// CertStore selects the certificate presented to a client by the server name
+// it asked for through SNI. Its certificates can be reloaded from disk while
+// it is in use; handshakes already under way keep the certificate they got.
+type CertStore struct {
+	pairs []CertPair
+	state atomic.Pointer[certState]
+
+	mu       sync.Mutex // serializes reloads and protects modTimes
+	modTimes map[string]time.Time
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// certState is an immutable set of certificates indexed by name.
+type certState struct {
+	certs  []*tls.Certificate
+	byName map[string]*tls.Certificate
+	// fallback is served when no certificate matches the requested name or
+	// the client sent no SNI.
+	fallback *tls.Certificate
+}
+
+// NewCertStore loads pairs into a new CertStore. The first pair is the
+// default certificate.
+func NewCertStore(pairs []CertPair) (*CertStore, error) {
+	s := &CertStore{
+		pairs: pairs,
+		stop:  make(chan struct{}),
+	}
+	s.modTimes = s.statFiles()
+	state, err := loadCertState(pairs)
+	if err != nil {
+		return nil, err
+	}
+	s.install(state)
+	return s, nil
+}
+
+// Reload reads every certificate and key again and swaps them in for new
+// handshakes. If any pair fails to load, the previous certificates stay in
+// use and the error is returned.
+func (s *CertStore) Reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.modTimes = s.statFiles()
+	return s.reload()
+}
+
+func (s *CertStore) reload() error {
+	state, err := loadCertState(s.pairs)
+	if err != nil {
+		metrics.TLSCertReloads.WithLabelValues("error").Inc()
+		return err
+	}
+	s.install(state)
+	metrics.TLSCertReloads.WithLabelValues("success").Inc()
+	return nil
+}
+
+func (s *CertStore) install(state *certState) {
+	s.state.Store(state)
+	for i, cert := range state.certs {
+		metrics.SetTLSCertNotAfter(s.pairs[i].CertFile, cert.Leaf.Subject.CommonName, float64(cert.Leaf.NotAfter.Unix()))
+	}
+}
+
+// Watch polls the certificate and key files every interval and reloads the
+// store when any of them changed. A rotation that replaces the certificate
+// and key one after the other may fail to load in between; the previous pair
+// is kept until both files match again.
+func (s *CertStore) Watch(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.reloadIfChanged()
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends a Watch.
+func (s *CertStore) Stop() {
+	s.stopOnce.Do(func() { close(s.stop) })
+}
+
+func (s *CertStore) reloadIfChanged() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	modTimes := s.statFiles()
+	changed := false
+	for file, modTime := range modTimes {
+		if !modTime.Equal(s.modTimes[file]) {
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+	s.modTimes = modTimes
+
+	if err := s.reload(); err != nil {
+		log.Printf("[ERROR] Failed to reload TLS certificates, keeping the previous ones: %v", err)
+		return
+	}
+	log.Printf("[INFO] Reloaded TLS certificates")
+}
+
+// statFiles returns the modification time of every certificate and key
+// file. Files that cannot be read are reported with a zero time.
+func (s *CertStore) statFiles() map[string]time.Time {
+	modTimes := make(map[string]time.Time, 2*len(s.pairs))
+	for _, pair := range s.pairs {
+		for _, file := range []string{pair.CertFile, pair.KeyFile} {
+			var modTime time.Time
+			if fi, err := os.Stat(file); err == nil {
+				modTime = fi.ModTime()
+			}
+			modTimes[file] = modTime
+		}
+	}
+	return modTimes
+}
+
+func loadCertState(pairs []CertPair) (*certState, error) {
+	if len(pairs) == 0 {
+		return nil, errors.New("no certificates configured")
+	}
+	state := &certState{byName: make(map[string]*tls.Certificate)}
+	for _, pair := range pairs {
+		cert, err := tls.LoadX509KeyPair(pair.CertFile, pair.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load %s: %w", pair.CertFile, err)
+		}
+		state.certs = append(state.certs, &cert)
+		if state.fallback == nil {
+			state.fallback = &cert
+		}
+		for _, name := range certNames(&cert) {
+			// Earlier pairs win when two certificates share a name.
+			if _, ok := state.byName[name]; !ok {
+				state.byName[name] = &cert
+			}
+		}
+	}
+	return state, nil
+}
+
+// certNames returns the lower-cased DNS names a certificate is valid for,
+// falling back to its common name when it has no subject alternative names.
+func certNames(cert *tls.Certificate) []string {
+	if len(cert.Leaf.DNSNames) == 0 {
+		if cn := cert.Leaf.Subject.CommonName; cn != "" {
+			return []string{strings.ToLower(cn)}
+		}
+		return nil
+	}
+	names := make([]string, len(cert.Leaf.DNSNames))
+	for i, name := range cert.Leaf.DNSNames {
+		names[i] = strings.ToLower(name)
+	}
+	return names
+}
+
+// GetCertificate implements tls.Config.GetCertificate. An exact name match
+// is preferred, then a wildcard certificate covering the name's first label,
+// then the default certificate.
+func (s *CertStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return s.state.Load().lookup(hello.ServerName), nil
+}
+
+func (st *certState) lookup(serverName string) *tls.Certificate {
+	name := strings.ToLower(strings.TrimSuffix(serverName, "."))
+	if name == "" {
+		return st.fallback
+	}
+	if cert, ok := st.byName[name]; ok {
+		return cert
+	}
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		if cert, ok := st.byName["*"+name[i:]]; ok {
+			return cert
+		}
+	}
+	return st.fallback
+}
@@ -0,0 +1,226 @@
+package tlsutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/novaru/golem/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// writeTestCert writes a self-signed certificate for names, valid for
+// validFor, into dir and returns its pair.
+func writeTestCert(t *testing.T, dir string, validFor time.Duration, names ...string) CertPair {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(validFor),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pair := CertPair{
+		CertFile: filepath.Join(dir, serial.String()+".crt"),
+		KeyFile:  filepath.Join(dir, serial.String()+".key"),
+	}
+	writePEM(t, pair.CertFile, "CERTIFICATE", der)
+	writePEM(t, pair.KeyFile, "EC PRIVATE KEY", keyDER)
+	return pair
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCertStoreSelectsBySNI(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewCertStore([]CertPair{
+		writeTestCert(t, dir, time.Hour, "default.example"),
+		writeTestCert(t, dir, time.Hour, "api.example.com"),
+		writeTestCert(t, dir, time.Hour, "*.example.com"),
+	})
+	if err != nil {
+		t.Fatalf("NewCertStore: %v", err)
+	}
+
+	tests := []struct {
+		serverName string
+		want       string
+	}{
+		{"api.example.com", "api.example.com"},
+		{"API.Example.com.", "api.example.com"},
+		{"www.example.com", "*.example.com"},
+		{"a.b.example.com", "default.example"},
+		{"example.com", "default.example"},
+		{"other.test", "default.example"},
+		{"", "default.example"},
+	}
+
+	for _, tc := range tests {
+		cert, err := store.GetCertificate(&tls.ClientHelloInfo{ServerName: tc.serverName})
+		if err != nil {
+			t.Fatalf("GetCertificate(%q): %v", tc.serverName, err)
+		}
+		if got := cert.Leaf.Subject.CommonName; got != tc.want {
+			t.Errorf("GetCertificate(%q) = %s, want %s", tc.serverName, got, tc.want)
+		}
+	}
+}
+
+func TestNewCertStoreRejectsInvalidPairs(t *testing.T) {
+	dir := t.TempDir()
+	a := writeTestCert(t, dir, time.Hour, "a.example")
+	b := writeTestCert(t, dir, time.Hour, "b.example")
+
+	if _, err := NewCertStore(nil); err == nil {
+		t.Error("expected error for empty certificate list")
+	}
+	if _, err := NewCertStore([]CertPair{{CertFile: a.CertFile, KeyFile: b.KeyFile}}); err == nil {
+		t.Error("expected error for mismatched key")
+	}
+	if _, err := NewCertStore([]CertPair{{CertFile: filepath.Join(dir, "missing.crt"), KeyFile: a.KeyFile}}); err == nil {
+		t.Error("expected error for missing file")
+	}
+}
+
+func TestParseVersionAndCipherSuites(t *testing.T) {
+	if v, err := ParseVersion("1.3"); err != nil || v != tls.VersionTLS13 {
+		t.Errorf("ParseVersion(1.3) = %v, %v", v, err)
+	}
+	if _, err := ParseVersion("1.4"); err == nil {
+		t.Error("expected error for unknown version")
+	}
+
+	ids, err := ParseCipherSuites([]string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"})
+	if err != nil || len(ids) != 1 || ids[0] != tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 {
+		t.Errorf("ParseCipherSuites = %v, %v", ids, err)
+	}
+	if _, err := ParseCipherSuites([]string{"TLS_RSA_WITH_RC4_128_SHA"}); err == nil {
+		t.Error("expected insecure cipher suite to be rejected")
+	}
+}
+
+// copyPair overwrites dst's files with src's and bumps their modification
+// time so a watcher notices the change.
+func copyPair(t *testing.T, dst, src CertPair) {
+	t.Helper()
+	for _, f := range [][2]string{{src.CertFile, dst.CertFile}, {src.KeyFile, dst.KeyFile}} {
+		data, err := os.ReadFile(f[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(f[1], data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		future := time.Now().Add(time.Minute)
+		if err := os.Chtimes(f[1], future, future); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func servedName(t *testing.T, store *CertStore) string {
+	t.Helper()
+	cert, err := store.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert.Leaf.Subject.CommonName
+}
+
+func TestCertStoreReload(t *testing.T) {
+	dir := t.TempDir()
+	live := writeTestCert(t, dir, time.Hour, "old.example")
+	store, err := NewCertStore([]CertPair{live})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expiry := metrics.TLSCertNotAfter.WithLabelValues(live.CertFile, "old.example")
+	if got := testutil.ToFloat64(expiry); got < float64(time.Now().Unix()) {
+		t.Errorf("expected expiry gauge to be set in the future, got %v", got)
+	}
+
+	copyPair(t, live, writeTestCert(t, dir, 48*time.Hour, "new.example"))
+	if err := store.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if got := servedName(t, store); got != "new.example" {
+		t.Errorf("expected reloaded certificate, got %s", got)
+	}
+	newExpiry := testutil.ToFloat64(metrics.TLSCertNotAfter.WithLabelValues(live.CertFile, "new.example"))
+	if want := float64(time.Now().Add(47 * time.Hour).Unix()); newExpiry < want {
+		t.Errorf("expected expiry of the new certificate, got %v", newExpiry)
+	}
+}
+
+func TestCertStoreReloadKeepsPreviousOnError(t *testing.T) {
+	dir := t.TempDir()
+	live := writeTestCert(t, dir, time.Hour, "good.example")
+	store, err := NewCertStore([]CertPair{live})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(live.CertFile, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Reload(); err == nil {
+		t.Error("expected reload of an invalid certificate to fail")
+	}
+	if got := servedName(t, store); got != "good.example" {
+		t.Errorf("expected the previous certificate to stay in use, got %s", got)
+	}
+}
+
+func TestCertStoreWatch(t *testing.T) {
+	dir := t.TempDir()
+	live := writeTestCert(t, dir, time.Hour, "before.example")
+	store, err := NewCertStore([]CertPair{live})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Watch(10 * time.Millisecond)
+	defer store.Stop()
+
+	copyPair(t, live, writeTestCert(t, dir, time.Hour, "after.example"))
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if servedName(t, store) == "after.example" {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("watcher did not pick up the rotated certificate, still serving %s", servedName(t, store))
+}
@@ -0,0 +1,57 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// ClientOptions configures TLS connections golem makes to backends.
+type ClientOptions struct {
+	// CAFile is a PEM bundle replacing the system trust store.
+	CAFile string
+	// CertFile and KeyFile are the client certificate for mutual TLS.
+	CertFile string
+	KeyFile  string
+	// ServerName overrides the name used for SNI and verification.
+	ServerName string
+	// InsecureSkipVerify disables verification of backend certificates.
+	InsecureSkipVerify bool
+}
+
+// ClientConfig builds a TLS configuration for connecting to backends.
+func ClientConfig(opts ClientOptions) (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         opts.ServerName,
+		InsecureSkipVerify: opts.InsecureSkipVerify,
+	}
+	if opts.CAFile != "" {
+		roots, err := LoadCertPool(opts.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = roots
+	}
+	if opts.CertFile != "" || opts.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate %s: %w", opts.CertFile, err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// LoadCertPool reads a PEM bundle of CA certificates.
+func LoadCertPool(file string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", file)
+	}
+	return pool, nil
+}
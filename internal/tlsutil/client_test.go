@@ -0,0 +1,93 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+// newMTLSServer starts an HTTPS server presenting serverPair and requiring a
+// client certificate signed by clientPair.
+func newMTLSServer(t *testing.T, serverPair, clientPair CertPair) *httptest.Server {
+	t.Helper()
+	cert, err := tls.LoadX509KeyPair(serverPair.CertFile, serverPair.KeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	srv.TLS = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    rootsFor(t, clientPair),
+	}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestClientConfigMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	serverPair := writeTestCert(t, dir, time.Hour, "backend.internal")
+	clientPair := writeTestCert(t, dir, time.Hour, "golem")
+	srv := newMTLSServer(t, serverPair, clientPair)
+
+	tests := []struct {
+		name    string
+		opts    ClientOptions
+		wantErr bool
+	}{
+		{"ca, client cert and server name", ClientOptions{
+			CAFile: serverPair.CertFile, CertFile: clientPair.CertFile, KeyFile: clientPair.KeyFile,
+			ServerName: "backend.internal",
+		}, false},
+		{"missing client cert", ClientOptions{
+			CAFile: serverPair.CertFile, ServerName: "backend.internal",
+		}, true},
+		{"system trust store", ClientOptions{
+			CertFile: clientPair.CertFile, KeyFile: clientPair.KeyFile, ServerName: "backend.internal",
+		}, true},
+		{"name mismatch", ClientOptions{
+			CAFile: serverPair.CertFile, CertFile: clientPair.CertFile, KeyFile: clientPair.KeyFile,
+		}, true},
+		{"insecure skip verify", ClientOptions{
+			CertFile: clientPair.CertFile, KeyFile: clientPair.KeyFile, InsecureSkipVerify: true,
+		}, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			config, err := ClientConfig(tc.opts)
+			if err != nil {
+				t.Fatalf("ClientConfig: %v", err)
+			}
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+			resp, err := client.Get(srv.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if (err != nil) != tc.wantErr {
+				t.Errorf("request error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestClientConfigRejectsBadFiles(t *testing.T) {
+	dir := t.TempDir()
+	empty := dir + "/empty.pem"
+	if err := os.WriteFile(empty, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ClientConfig(ClientOptions{CAFile: empty}); err == nil {
+		t.Error("expected error for CA bundle without certificates")
+	}
+	if _, err := ClientConfig(ClientOptions{CertFile: dir + "/missing.crt", KeyFile: dir + "/missing.key"}); err == nil {
+		t.Error("expected error for missing client certificate")
+	}
+}
@@ -0,0 +1,123 @@
+package tlsutil
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// Client certificate rejection reasons, used as metric labels.
+const (
+	ReasonCertMissing   = "client_cert_missing"
+	ReasonCertUntrusted = "client_cert_untrusted"
+	ReasonCertDenied    = "client_cert_denied"
+)
+
+// ErrCertDenied is returned when a verified client certificate is not
+// allowed by a CertPolicy.
+var ErrCertDenied = errors.New("client certificate denied by policy")
+
+// CertMatcher matches client certificates. Every non-empty field must match.
+type CertMatcher struct {
+	// Subject matches the full distinguished name, as formatted by
+	// pkix.Name.String (e.g. "CN=api,O=Example"), or the common name alone.
+	Subject string
+	// SAN matches any DNS name, email address, URI or IP address in the
+	// subject alternative names. A leading "*." matches one DNS label.
+	SAN string
+	// Fingerprint is the hex SHA-256 digest of the certificate. Colons and
+	// case are ignored.
+	Fingerprint string
+}
+
+// Matches reports whether cert satisfies every field of m.
+func (m CertMatcher) Matches(cert *x509.Certificate) bool {
+	if m.Subject != "" && m.Subject != cert.Subject.String() && m.Subject != cert.Subject.CommonName {
+		return false
+	}
+	if m.SAN != "" && !matchSAN(m.SAN, cert) {
+		return false
+	}
+	if m.Fingerprint != "" && NormalizeFingerprint(m.Fingerprint) != Fingerprint(cert) {
+		return false
+	}
+	return true
+}
+
+func matchSAN(pattern string, cert *x509.Certificate) bool {
+	for _, name := range SANs(cert) {
+		if strings.EqualFold(pattern, name) {
+			return true
+		}
+		if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+			if _, rest, found := strings.Cut(name, "."); found && strings.EqualFold(rest, suffix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CertPolicy decides which verified client certificates are accepted. Deny
+// rules take precedence. With no Allow rules every certificate not denied is
+// accepted.
+type CertPolicy struct {
+	Allow []CertMatcher
+	Deny  []CertMatcher
+}
+
+// Check returns ErrCertDenied if the policy rejects cert.
+func (p *CertPolicy) Check(cert *x509.Certificate) error {
+	for _, m := range p.Deny {
+		if m.Matches(cert) {
+			return ErrCertDenied
+		}
+	}
+	if len(p.Allow) == 0 {
+		return nil
+	}
+	for _, m := range p.Allow {
+		if m.Matches(cert) {
+			return nil
+		}
+	}
+	return ErrCertDenied
+}
+
+// verifyConnection is a tls.Config.VerifyConnection hook applying the policy
+// to the client's verified certificate, if it sent one.
+func (p *CertPolicy) verifyConnection(cs tls.ConnectionState) error {
+	if len(cs.VerifiedChains) == 0 {
+		return nil
+	}
+	return p.Check(cs.VerifiedChains[0][0])
+}
+
+// Fingerprint returns the hex SHA-256 digest of cert.
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// NormalizeFingerprint lower-cases a fingerprint and strips the colons some
+// tools print between bytes.
+func NormalizeFingerprint(fp string) string {
+	return strings.ToLower(strings.ReplaceAll(fp, ":", ""))
+}
+
+// SANs returns the subject alternative names of cert as strings.
+func SANs(cert *x509.Certificate) []string {
+	names := make([]string, 0, len(cert.DNSNames)+len(cert.EmailAddresses)+len(cert.URIs)+len(cert.IPAddresses))
+	names = append(names, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, u := range cert.URIs {
+		names = append(names, u.String())
+	}
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	return names
+}
@@ -0,0 +1,143 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/novaru/golem/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func loadLeaf(t *testing.T, pair CertPair) *x509.Certificate {
+	t.Helper()
+	cert, err := tls.LoadX509KeyPair(pair.CertFile, pair.KeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert.Leaf
+}
+
+func TestCertMatcher(t *testing.T) {
+	dir := t.TempDir()
+	cert := loadLeaf(t, writeTestCert(t, dir, time.Hour, "api.internal", "svc.example.com"))
+	fp := Fingerprint(cert)
+	colonFP := strings.ToUpper(fp[:2] + ":" + fp[2:])
+
+	tests := []struct {
+		name    string
+		matcher CertMatcher
+		want    bool
+	}{
+		{"common name", CertMatcher{Subject: "api.internal"}, true},
+		{"distinguished name", CertMatcher{Subject: "CN=api.internal"}, true},
+		{"other subject", CertMatcher{Subject: "CN=other"}, false},
+		{"exact SAN", CertMatcher{SAN: "svc.example.com"}, true},
+		{"wildcard SAN", CertMatcher{SAN: "*.example.com"}, true},
+		{"wildcard spans one label", CertMatcher{SAN: "*.com"}, false},
+		{"fingerprint", CertMatcher{Fingerprint: fp}, true},
+		{"fingerprint with colons", CertMatcher{Fingerprint: colonFP}, true},
+		{"all fields must match", CertMatcher{Subject: "api.internal", SAN: "nope.example"}, false},
+	}
+
+	for _, tc := range tests {
+		if got := tc.matcher.Matches(cert); got != tc.want {
+			t.Errorf("%s: Matches = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestCertPolicyDenyWins(t *testing.T) {
+	dir := t.TempDir()
+	cert := loadLeaf(t, writeTestCert(t, dir, time.Hour, "api.internal"))
+
+	allowOnly := &CertPolicy{Allow: []CertMatcher{{Subject: "api.internal"}}}
+	if err := allowOnly.Check(cert); err != nil {
+		t.Errorf("expected allowed certificate, got %v", err)
+	}
+	denied := &CertPolicy{
+		Allow: []CertMatcher{{Subject: "api.internal"}},
+		Deny:  []CertMatcher{{Fingerprint: Fingerprint(cert)}},
+	}
+	if err := denied.Check(cert); err != ErrCertDenied {
+		t.Errorf("expected deny rule to win, got %v", err)
+	}
+	notListed := &CertPolicy{Allow: []CertMatcher{{Subject: "other"}}}
+	if err := notListed.Check(cert); err != ErrCertDenied {
+		t.Errorf("expected certificate outside the allow list to be denied, got %v", err)
+	}
+	if err := (&CertPolicy{}).Check(cert); err != nil {
+		t.Errorf("expected empty policy to accept, got %v", err)
+	}
+}
+
+func TestListenerClientCertHandshake(t *testing.T) {
+	dir := t.TempDir()
+	serverPair := writeTestCert(t, dir, time.Hour, "golem.test")
+	goodPair := writeTestCert(t, dir, time.Hour, "good-client")
+	badPair := writeTestCert(t, dir, time.Hour, "bad-client")
+
+	store, err := NewCertStore([]CertPair{serverPair})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(loadLeaf(t, goodPair))
+	clientCAs.AddCert(loadLeaf(t, badPair))
+
+	config := ServerConfig(store, ServerOptions{
+		ClientCAs:    clientCAs,
+		ClientPolicy: &CertPolicy{Deny: []CertMatcher{{Subject: "bad-client"}}},
+	})
+	addr := serveTLS(t, config, "mtls", http.NotFoundHandler())
+
+	dial := func(pair *CertPair) error {
+		cfg := &tls.Config{RootCAs: rootsFor(t, serverPair), ServerName: "golem.test"}
+		if pair != nil {
+			cert, err := tls.LoadX509KeyPair(pair.CertFile, pair.KeyFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg.Certificates = []tls.Certificate{cert}
+		}
+		conn, err := tls.Dial("tcp", addr, cfg)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		// With TLS 1.3 the server verifies the client certificate after the
+		// client considers the handshake done; a read surfaces the outcome.
+		conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		_, err = conn.Read(make([]byte, 1))
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return nil
+		}
+		return err
+	}
+
+	if err := dial(&goodPair); err != nil {
+		t.Errorf("expected allowed client certificate to connect, got %v", err)
+	}
+	if err := dial(&badPair); err == nil {
+		t.Error("expected denied client certificate to fail the handshake")
+	}
+	if err := dial(nil); err == nil {
+		t.Error("expected missing client certificate to fail the handshake")
+	}
+
+	denied := metrics.TLSHandshakeFailures.WithLabelValues("mtls", ReasonCertDenied)
+	missing := metrics.TLSHandshakeFailures.WithLabelValues("mtls", ReasonCertMissing)
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if testutil.ToFloat64(denied) == 1 && testutil.ToFloat64(missing) == 1 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("expected one denied and one missing failure, got %v and %v",
+		testutil.ToFloat64(denied), testutil.ToFloat64(missing))
+}
@@ -0,0 +1,99 @@
+// Package tlsutil builds the TLS configurations golem uses to terminate client
+// connections.
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+)
+
+// ServerOptions is the TLS policy of a listener.
+type ServerOptions struct {
+	// MinVersion is the lowest TLS version accepted. Default TLS 1.2.
+	MinVersion uint16
+	// CipherSuites restricts the cipher suites offered for TLS 1.2 and
+	// below. TLS 1.3 suites are not configurable. Empty uses Go's defaults.
+	CipherSuites []uint16
+	// DisableHTTP2 only offers http/1.1 through ALPN.
+	DisableHTTP2 bool
+
+	// ClientCAs enables client certificate authentication: certificates
+	// must be signed by one of these CAs or the handshake fails.
+	ClientCAs *x509.CertPool
+	// ClientCertOptional lets clients without a certificate complete the
+	// handshake, leaving it to routes to require one.
+	ClientCertOptional bool
+	// ClientPolicy restricts the accepted client certificates further.
+	// Certificates it rejects fail the handshake.
+	ClientPolicy *CertPolicy
+}
+
+// ServerConfig returns a TLS configuration serving certificates from store.
+func ServerConfig(store *CertStore, opts ServerOptions) *tls.Config {
+	minVersion := opts.MinVersion
+	if minVersion == 0 {
+		minVersion = tls.VersionTLS12
+	}
+	nextProtos := []string{"h2", "http/1.1"}
+	if opts.DisableHTTP2 {
+		nextProtos = []string{"http/1.1"}
+	}
+	config := &tls.Config{
+		GetCertificate: store.GetCertificate,
+		MinVersion:     minVersion,
+		CipherSuites:   opts.CipherSuites,
+		NextProtos:     nextProtos,
+	}
+	if opts.ClientCAs != nil {
+		config.ClientCAs = opts.ClientCAs
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+		if opts.ClientCertOptional {
+			config.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+	if opts.ClientPolicy != nil {
+		config.VerifyConnection = opts.ClientPolicy.verifyConnection
+	}
+	return config
+}
+
+// ParseVersion converts a version such as "1.2" to its crypto/tls constant.
+// An empty string yields 0, meaning the default.
+func ParseVersion(v string) (uint16, error) {
+	switch v {
+	case "":
+		return 0, nil
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unsupported TLS version: %s", v)
+}
+
+// ParseCipherSuites converts IANA cipher suite names, such as
+// "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", to their IDs. Suites Go considers
+// insecure are rejected.
+func ParseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	known := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unsupported cipher suite: %s", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
@@ -0,0 +1,137 @@
+package tlsutil
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/novaru/golem/internal/metrics"
+)
+
+// defaultHandshakeTimeout bounds a client's TLS handshake.
+const defaultHandshakeTimeout = 10 * time.Second
+
+// Listener terminates TLS on the connections of an inner listener. Unlike
+// tls.NewListener it completes each handshake before returning the
+// connection from Accept, so failed handshakes can be counted by reason.
+// Handshakes run concurrently; a slow client does not hold up others.
+type Listener struct {
+	net.Listener
+	config *tls.Config
+	name   string
+
+	// HandshakeTimeout bounds each handshake. Default 10 seconds.
+	HandshakeTimeout time.Duration
+
+	conns     chan net.Conn
+	errs      chan error
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewListener wraps inner so that every accepted connection is a *tls.Conn
+// whose handshake has completed. name labels the listener's metrics.
+func NewListener(inner net.Listener, config *tls.Config, name string) *Listener {
+	l := &Listener{
+		Listener:         inner,
+		config:           config,
+		name:             name,
+		HandshakeTimeout: defaultHandshakeTimeout,
+		conns:            make(chan net.Conn),
+		errs:             make(chan error),
+		done:             make(chan struct{}),
+	}
+	go l.acceptLoop()
+	return l
+}
+
+func (l *Listener) acceptLoop() {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			select {
+			case l.errs <- err:
+			case <-l.done:
+				return
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		go l.handshake(c)
+	}
+}
+
+func (l *Listener) handshake(c net.Conn) {
+	tc := tls.Server(c, l.config)
+	ctx, cancel := context.WithTimeout(context.Background(), l.HandshakeTimeout)
+	err := tc.HandshakeContext(ctx)
+	cancel()
+	if err != nil {
+		metrics.TLSHandshakeFailures.WithLabelValues(l.name, handshakeFailureReason(err)).Inc()
+		c.Close()
+		return
+	}
+	select {
+	case l.conns <- tc:
+	case <-l.done:
+		tc.Close()
+	}
+}
+
+// Accept returns the next connection that completed its TLS handshake.
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case err := <-l.errs:
+		return nil, err
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops accepting connections. Handshakes in progress are abandoned.
+func (l *Listener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return l.Listener.Close()
+}
+
+// handshakeFailureReason classifies a server handshake error for metrics.
+func handshakeFailureReason(err error) string {
+	var netErr net.Error
+	var recordErr tls.RecordHeaderError
+	msg := err.Error()
+	switch {
+	case errors.Is(err, ErrCertDenied):
+		return ReasonCertDenied
+	case strings.Contains(msg, "didn't provide a certificate"):
+		return ReasonCertMissing
+	case strings.Contains(msg, "failed to verify certificate"):
+		return ReasonCertUntrusted
+	case errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, io.EOF) || errors.Is(err, syscall.ECONNRESET):
+		return "client_closed"
+	case errors.As(err, &recordErr):
+		return "not_tls"
+	case strings.Contains(msg, "unsupported versions"), strings.Contains(msg, "protocol version"):
+		return "protocol_version"
+	case strings.Contains(msg, "no cipher suite"):
+		return "no_shared_cipher"
+	case strings.Contains(msg, "no application protocol"):
+		return "no_application_protocol"
+	case strings.Contains(msg, "certificate"):
+		return "bad_certificate"
+	case strings.Contains(msg, "remote error"):
+		return "client_alert"
+	}
+	return "other"
+}
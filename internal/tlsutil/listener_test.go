@@ -0,0 +1,119 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/novaru/golem/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// serveTLS serves handler over TLS with config on a local port and returns
+// its address.
+func serveTLS(t *testing.T, config *tls.Config, name string, handler http.Handler) string {
+	t.Helper()
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln := NewListener(inner, config, name)
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(true)
+	srv := &http.Server{Handler: handler, TLSConfig: config, Protocols: protocols}
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+	return ln.Addr().String()
+}
+
+// rootsFor returns a pool trusting the self-signed certificate in pair.
+func rootsFor(t *testing.T, pair CertPair) *x509.CertPool {
+	t.Helper()
+	cert, err := tls.LoadX509KeyPair(pair.CertFile, pair.KeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(cert.Leaf)
+	return roots
+}
+
+func TestListenerServesHTTP2WithSNI(t *testing.T) {
+	dir := t.TempDir()
+	fallback := writeTestCert(t, dir, time.Hour, "default.example")
+	api := writeTestCert(t, dir, time.Hour, "api.example.com")
+	store, err := NewCertStore([]CertPair{fallback, api})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr := serveTLS(t, ServerConfig(store, ServerOptions{}), "sni", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Proto)
+	}))
+
+	protocols := new(http.Protocols)
+	protocols.SetHTTP2(true)
+	client := &http.Client{Transport: &http.Transport{
+		Protocols: protocols,
+		TLSClientConfig: &tls.Config{
+			RootCAs:    rootsFor(t, api),
+			ServerName: "api.example.com",
+		},
+	}}
+
+	resp, err := client.Get("https://" + addr)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if string(body) != "HTTP/2.0" {
+		t.Errorf("expected HTTP/2 negotiated through ALPN, got %s", body)
+	}
+	if got := resp.TLS.PeerCertificates[0].Subject.CommonName; got != "api.example.com" {
+		t.Errorf("expected the SNI certificate, got %s", got)
+	}
+}
+
+func TestListenerCountsHandshakeFailures(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewCertStore([]CertPair{writeTestCert(t, dir, time.Hour, "default.example")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := ServerConfig(store, ServerOptions{MinVersion: tls.VersionTLS13})
+	addr := serveTLS(t, config, "failures", http.NotFoundHandler())
+
+	versionFailures := metrics.TLSHandshakeFailures.WithLabelValues("failures", "protocol_version")
+	notTLSFailures := metrics.TLSHandshakeFailures.WithLabelValues("failures", "not_tls")
+
+	conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true, MaxVersion: tls.VersionTLS12})
+	if err == nil {
+		conn.Close()
+		t.Fatal("expected a TLS 1.2 handshake to be rejected")
+	}
+
+	plain, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(plain, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	io.Copy(io.Discard, plain)
+	plain.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if testutil.ToFloat64(versionFailures) == 1 && testutil.ToFloat64(notTLSFailures) == 1 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("expected one protocol_version and one not_tls failure, got %v and %v",
+		testutil.ToFloat64(versionFailures), testutil.ToFloat64(notTLSFailures))
+}
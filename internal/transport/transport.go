@@ -0,0 +1,201 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/novaru/golem/internal/metrics"
+	"github.com/novaru/golem/internal/proxyproto"
+)
+
+// Backend protocols.
+const (
+	// ProtocolAuto speaks HTTP/1.1 to http:// backends and negotiates HTTP/2
+	// or HTTP/1.1 through ALPN with https:// backends.
+	ProtocolAuto = ""
+	// ProtocolHTTP1 always speaks HTTP/1.1.
+	ProtocolHTTP1 = "http1"
+	// ProtocolH2 always speaks HTTP/2 over TLS.
+	ProtocolH2 = "h2"
+	// ProtocolH2C speaks cleartext HTTP/2 with prior knowledge.
+	ProtocolH2C = "h2c"
+)
+
+// Options tunes the connection pool and timeouts of a backend transport.
+// Zero values fall back to the defaults listed on each field.
+type Options struct {
+	// Protocol selects the HTTP version spoken to the backend. Default
+	// ProtocolAuto. With HTTP/2 many requests share one connection, but each
+	// request is still balanced on its own.
+	Protocol string
+	// MaxIdleConns caps idle connections kept to the backend. Default 100.
+	MaxIdleConns int
+	// MaxConnsPerHost caps all connections to the backend. Default 0 (no limit).
+	MaxConnsPerHost int
+	// IdleConnTimeout closes idle connections after this long. Default 90s.
+	IdleConnTimeout time.Duration
+	// KeepAlive is the TCP keep-alive period. Default 30s.
+	KeepAlive time.Duration
+	// DialTimeout bounds establishing a TCP connection. Default 30s.
+	DialTimeout time.Duration
+	// TLSHandshakeTimeout bounds the TLS handshake. Default 10s.
+	TLSHandshakeTimeout time.Duration
+	// ResponseHeaderTimeout bounds the wait for response headers after the
+	// request was written. Default 0 (no limit beyond the request timeout).
+	ResponseHeaderTimeout time.Duration
+	// ExpectContinueTimeout bounds the wait for a 100 Continue response when
+	// the request has "Expect: 100-continue". Default 1s.
+	ExpectContinueTimeout time.Duration
+	// TLSClientConfig configures TLS to https backends. Default nil, which
+	// verifies against the system trust store.
+	TLSClientConfig *tls.Config
+	// ProxyProtocol sends a PROXY protocol header of this version
+	// (proxyproto.V1 or V2) on every new connection, describing the client
+	// whose request opened it. Connections are then not reused, since they
+	// belong to one client. Default 0 (off).
+	ProxyProtocol int
+}
+
+const (
+	defaultMaxIdleConns          = 100
+	defaultIdleConnTimeout       = 90 * time.Second
+	defaultKeepAlive             = 30 * time.Second
+	defaultDialTimeout           = 30 * time.Second
+	defaultTLSHandshakeTimeout   = 10 * time.Second
+	defaultExpectContinueTimeout = 1 * time.Second
+)
+
+// Transport is an http.RoundTripper dedicated to a single backend. It keeps
+// its own connection pool and reports dials, open connections and connection
+// reuse to Prometheus under the backend's label.
+type Transport struct {
+	*http.Transport
+	backend       string
+	proxyProtocol int
+}
+
+// New creates a Transport for the backend at backendURL.
+func New(backendURL string, opts Options) *Transport {
+	opts.setDefaults()
+
+	t := &Transport{backend: backendURL}
+	dialer := &net.Dialer{
+		Timeout:   opts.DialTimeout,
+		KeepAlive: opts.KeepAlive,
+	}
+
+	t.Transport = &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return t.dial(ctx, dialer, network, addr)
+		},
+		Protocols: protocols(opts.Protocol),
+		// All connections go to the same host, so the per-host idle limit
+		// is the pool size.
+		MaxIdleConns:          opts.MaxIdleConns,
+		MaxIdleConnsPerHost:   opts.MaxIdleConns,
+		MaxConnsPerHost:       opts.MaxConnsPerHost,
+		IdleConnTimeout:       opts.IdleConnTimeout,
+		TLSHandshakeTimeout:   opts.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: opts.ResponseHeaderTimeout,
+		ExpectContinueTimeout: opts.ExpectContinueTimeout,
+		TLSClientConfig:       opts.TLSClientConfig.Clone(),
+		DisableKeepAlives:     opts.ProxyProtocol != 0,
+	}
+	t.proxyProtocol = opts.ProxyProtocol
+	return t
+}
+
+// protocols returns the protocol set for a backend protocol name.
+func protocols(protocol string) *http.Protocols {
+	p := new(http.Protocols)
+	switch protocol {
+	case ProtocolHTTP1:
+		p.SetHTTP1(true)
+	case ProtocolH2:
+		p.SetHTTP2(true)
+	case ProtocolH2C:
+		p.SetUnencryptedHTTP2(true)
+	default:
+		p.SetHTTP1(true)
+		p.SetHTTP2(true)
+	}
+	return p
+}
+
+func (o *Options) setDefaults() {
+	if o.MaxIdleConns <= 0 {
+		o.MaxIdleConns = defaultMaxIdleConns
+	}
+	if o.IdleConnTimeout <= 0 {
+		o.IdleConnTimeout = defaultIdleConnTimeout
+	}
+	if o.KeepAlive == 0 {
+		o.KeepAlive = defaultKeepAlive
+	}
+	if o.DialTimeout <= 0 {
+		o.DialTimeout = defaultDialTimeout
+	}
+	if o.TLSHandshakeTimeout <= 0 {
+		o.TLSHandshakeTimeout = defaultTLSHandshakeTimeout
+	}
+	if o.ExpectContinueTimeout <= 0 {
+		o.ExpectContinueTimeout = defaultExpectContinueTimeout
+	}
+}
+
+// RoundTrip implements http.RoundTripper, recording whether the request got a
+// new or a pooled connection.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			metrics.TransportConnsUsed.WithLabelValues(t.backend, strconv.FormatBool(info.Reused)).Inc()
+		},
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	return t.Transport.RoundTrip(req)
+}
+
+// dial opens a new connection and tracks it until it is closed.
+func (t *Transport) dial(ctx context.Context, dialer *net.Dialer, network, addr string) (net.Conn, error) {
+	conn, err := dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		metrics.TransportDials.WithLabelValues(t.backend, "error").Inc()
+		return nil, err
+	}
+	if t.proxyProtocol != 0 {
+		// Connections made without a client, such as health checks, are
+		// announced as the proxy's own.
+		header, ok := proxyproto.FromContext(ctx)
+		if !ok {
+			header = &proxyproto.Header{}
+		}
+		if _, err := conn.Write(header.Format(t.proxyProtocol)); err != nil {
+			conn.Close()
+			metrics.TransportDials.WithLabelValues(t.backend, "error").Inc()
+			return nil, err
+		}
+	}
+	metrics.TransportDials.WithLabelValues(t.backend, "success").Inc()
+	metrics.TransportOpenConns.WithLabelValues(t.backend).Inc()
+	return &trackedConn{Conn: conn, backend: t.backend}, nil
+}
+
+// trackedConn decrements the open connection gauge once, when closed.
+type trackedConn struct {
+	net.Conn
+	backend string
+	once    sync.Once
+}
+
+func (c *trackedConn) Close() error {
+	c.once.Do(func() {
+		metrics.TransportOpenConns.WithLabelValues(c.backend).Dec()
+	})
+	return c.Conn.Close()
+}
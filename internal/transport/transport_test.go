@@ -0,0 +1,147 @@
+package transport
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/novaru/golem/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestNewAppliesDefaults(t *testing.T) {
+	tr := New("http://backend", Options{})
+
+	if tr.MaxIdleConns != defaultMaxIdleConns || tr.MaxIdleConnsPerHost != defaultMaxIdleConns {
+		t.Errorf("expected idle pool size %d, got %d/%d", defaultMaxIdleConns, tr.MaxIdleConns, tr.MaxIdleConnsPerHost)
+	}
+	if tr.IdleConnTimeout != defaultIdleConnTimeout {
+		t.Errorf("expected idle timeout %v, got %v", defaultIdleConnTimeout, tr.IdleConnTimeout)
+	}
+	if tr.ExpectContinueTimeout != defaultExpectContinueTimeout {
+		t.Errorf("expected expect-continue timeout %v, got %v", defaultExpectContinueTimeout, tr.ExpectContinueTimeout)
+	}
+}
+
+func TestNewUsesOptions(t *testing.T) {
+	tr := New("http://backend", Options{
+		MaxIdleConns:          5,
+		MaxConnsPerHost:       10,
+		IdleConnTimeout:       time.Minute,
+		ResponseHeaderTimeout: 2 * time.Second,
+	})
+
+	if tr.MaxIdleConnsPerHost != 5 || tr.MaxConnsPerHost != 10 {
+		t.Errorf("unexpected connection limits: idle=%d max=%d", tr.MaxIdleConnsPerHost, tr.MaxConnsPerHost)
+	}
+	if tr.IdleConnTimeout != time.Minute || tr.ResponseHeaderTimeout != 2*time.Second {
+		t.Errorf("unexpected timeouts: idle=%v header=%v", tr.IdleConnTimeout, tr.ResponseHeaderTimeout)
+	}
+}
+
+func TestTransportReusesConnections(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer backend.Close()
+
+	tr := New(backend.URL, Options{})
+	defer tr.CloseIdleConnections()
+
+	for range 3 {
+		req, _ := http.NewRequest("GET", backend.URL, nil)
+		resp, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("round trip failed: %v", err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	if got := testutil.ToFloat64(metrics.TransportDials.WithLabelValues(backend.URL, "success")); got != 1 {
+		t.Errorf("expected 1 dial, got %v", got)
+	}
+	if got := testutil.ToFloat64(metrics.TransportConnsUsed.WithLabelValues(backend.URL, "true")); got != 2 {
+		t.Errorf("expected 2 reused connections, got %v", got)
+	}
+	if got := testutil.ToFloat64(metrics.TransportOpenConns.WithLabelValues(backend.URL)); got != 1 {
+		t.Errorf("expected 1 open connection, got %v", got)
+	}
+
+	tr.CloseIdleConnections()
+	if got := testutil.ToFloat64(metrics.TransportOpenConns.WithLabelValues(backend.URL)); got != 0 {
+		t.Errorf("expected no open connections after closing idle ones, got %v", got)
+	}
+}
+
+func TestTransportCountsDialErrors(t *testing.T) {
+	const unreachable = "http://127.0.0.1:1"
+	tr := New(unreachable, Options{DialTimeout: time.Second})
+
+	req, _ := http.NewRequest("GET", unreachable, nil)
+	if _, err := tr.RoundTrip(req); err == nil {
+		t.Fatal("expected dial to fail")
+	}
+	if got := testutil.ToFloat64(metrics.TransportDials.WithLabelValues(unreachable, "error")); got != 1 {
+		t.Errorf("expected 1 failed dial, got %v", got)
+	}
+}
+
+func TestTransportH2C(t *testing.T) {
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Proto)
+	}))
+	backend.Config.Protocols = new(http.Protocols)
+	backend.Config.Protocols.SetHTTP1(true)
+	backend.Config.Protocols.SetUnencryptedHTTP2(true)
+	backend.Start()
+	defer backend.Close()
+
+	for protocol, want := range map[string]string{
+		ProtocolAuto:  "HTTP/1.1",
+		ProtocolHTTP1: "HTTP/1.1",
+		ProtocolH2C:   "HTTP/2.0",
+	} {
+		tr := New(backend.URL, Options{Protocol: protocol})
+		req, _ := http.NewRequest("GET", backend.URL, nil)
+		resp, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("%q: round trip failed: %v", protocol, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		tr.CloseIdleConnections()
+
+		if string(body) != want {
+			t.Errorf("%q: backend saw %s, want %s", protocol, body, want)
+		}
+	}
+}
+
+func TestTransportH2OverTLS(t *testing.T) {
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Proto)
+	}))
+	backend.EnableHTTP2 = true
+	backend.StartTLS()
+	defer backend.Close()
+
+	tr := New(backend.URL, Options{
+		Protocol:        ProtocolH2,
+		TLSClientConfig: backend.Client().Transport.(*http.Transport).TLSClientConfig,
+	})
+
+	req, _ := http.NewRequest("GET", backend.URL, nil)
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("round trip failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if string(body) != "HTTP/2.0" {
+		t.Errorf("expected HTTP/2 over TLS, backend saw %s", body)
+	}
+}
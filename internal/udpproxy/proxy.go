@@ -0,0 +1,316 @@
+// Package udpproxy balances UDP datagrams across backends, for services such
+// as DNS and syslog.
+package udpproxy
+
+import (
+	"errors"
+	"hash/fnv"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/novaru/golem/internal/balancer"
+	"github.com/novaru/golem/internal/metrics"
+)
+
+const (
+	// defaultSessionTimeout ends idle client sessions.
+	defaultSessionTimeout = 30 * time.Second
+	// maxDatagramSize is the largest UDP payload.
+	maxDatagramSize = 64 * 1024
+)
+
+// Proxy relays datagrams between clients and backends chosen by Balancer.
+// Every client address and port gets a session bound to one backend through
+// a socket of its own, so replies find their way back to the client that
+// sent the request. Backends are addressed as "udp://host:port".
+type Proxy struct {
+	// Name labels the listener in logs.
+	Name     string
+	Balancer balancer.Balancer
+
+	// Hash pins clients to a backend by hashing their IP address instead of
+	// asking Balancer, so a client keeps its backend across sessions while
+	// that backend stays healthy. Backend weights are ignored.
+	Hash bool
+	// SessionTimeout ends a session when no datagram flowed in either
+	// direction for this long. Default 30 seconds.
+	SessionTimeout time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]*session
+	conns    map[net.PacketConn]struct{}
+	closed   bool
+}
+
+// session is the binding of a client address to a backend.
+type session struct {
+	key    string
+	client net.Addr
+	// pc is the socket the client's datagrams arrive on and replies leave
+	// from.
+	pc      net.PacketConn
+	backend *balancer.Backend
+	conn    net.Conn
+	// lastSeen is the time of the last datagram, in Unix nanoseconds.
+	lastSeen atomic.Int64
+}
+
+func (s *session) touch() {
+	s.lastSeen.Store(time.Now().UnixNano())
+}
+
+func (s *session) idleFor() time.Duration {
+	return time.Since(time.Unix(0, s.lastSeen.Load()))
+}
+
+// Serve reads datagrams from pc until it is closed, forwarding each one to
+// the backend of its client's session. The sessions of pc are closed on
+// return; those of other sockets served by p are left alone.
+func (p *Proxy) Serve(pc net.PacketConn) error {
+	if !p.track(pc) {
+		pc.Close()
+		return net.ErrClosed
+	}
+	defer p.closeSessions(pc)
+	defer p.untrack(pc)
+
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+		s := p.session(pc, addr)
+		if s == nil {
+			continue
+		}
+		if _, err := s.conn.Write(buf[:n]); err != nil {
+			p.backendFailed(s, err)
+			continue
+		}
+		count(s.backend.URL, "to_backend", n)
+	}
+}
+
+// track registers pc for Close, reporting false if the proxy is closed.
+func (p *Proxy) track(pc net.PacketConn) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return false
+	}
+	if p.conns == nil {
+		p.conns = make(map[net.PacketConn]struct{})
+	}
+	p.conns[pc] = struct{}{}
+	return true
+}
+
+func (p *Proxy) untrack(pc net.PacketConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.conns, pc)
+}
+
+// Close closes every socket being served, which ends their sessions.
+func (p *Proxy) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	var err error
+	for pc := range p.conns {
+		if cerr := pc.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// session returns the session of the client at addr, starting one if
+// needed. It returns nil when no backend can take the client.
+func (p *Proxy) session(pc net.PacketConn, addr net.Addr) *session {
+	key := addr.String()
+	if s := p.lookup(key); s != nil {
+		return s
+	}
+
+	// Dialing may resolve the backend's host name, so it happens without
+	// holding up the lookups of other clients.
+	backend, err := p.pick(addr)
+	if err != nil {
+		metrics.UDPSessions.WithLabelValues("", "no_backend").Inc()
+		log.Printf("[ERROR] No healthy backend for udp listener %s", p.Name)
+		return nil
+	}
+	conn, err := net.Dial("udp", strings.TrimPrefix(backend.URL, balancer.UDPScheme))
+	if err != nil {
+		backend.SetHealth(false)
+		metrics.UDPSessions.WithLabelValues(backend.URL, "backend_unavailable").Inc()
+		log.Printf("[ERROR] Backend %s is unavailable: %v", backend.URL, err)
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// Another socket may have started a session for the client meanwhile.
+	if s, ok := p.sessions[key]; ok {
+		conn.Close()
+		s.touch()
+		return s
+	}
+	metrics.UDPSessions.WithLabelValues(backend.URL, "success").Inc()
+
+	s := &session{key: key, client: addr, pc: pc, backend: backend, conn: conn}
+	s.touch()
+	if p.sessions == nil {
+		p.sessions = make(map[string]*session)
+	}
+	p.sessions[key] = s
+	backend.AddConnections()
+	metrics.UpdateActiveConnections(backend.URL, float64(backend.GetConnections()))
+
+	go p.relayReplies(s)
+	return s
+}
+
+// lookup returns the session of the client with the given key, if any.
+func (p *Proxy) lookup(key string) *session {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// Touching under the lock keeps the session from expiring between
+	// lookup and use.
+	if s, ok := p.sessions[key]; ok {
+		s.touch()
+		return s
+	}
+	return nil
+}
+
+// pick chooses the backend of a new session.
+func (p *Proxy) pick(addr net.Addr) (*balancer.Backend, error) {
+	if !p.Hash {
+		return p.Balancer.NextBackend()
+	}
+	ip := addr.String()
+	if ua, ok := addr.(*net.UDPAddr); ok {
+		ip = ua.IP.String()
+	}
+	// Rendezvous hashing: when a backend fails only its own clients move.
+	var best *balancer.Backend
+	var bestScore uint64
+	for _, b := range p.Balancer.Backends() {
+		if !b.Available() {
+			continue
+		}
+		h := fnv.New64a()
+		h.Write([]byte(ip))
+		h.Write([]byte(b.URL))
+		if score := h.Sum64(); best == nil || score > bestScore {
+			best, bestScore = b, score
+		}
+	}
+	if best == nil {
+		return nil, errors.New("no healthy backend available")
+	}
+	return best, nil
+}
+
+// relayReplies sends the backend's datagrams back to the client until the
+// session idles out or its socket fails.
+func (p *Proxy) relayReplies(s *session) {
+	defer p.closeSession(s)
+
+	timeout := p.SessionTimeout
+	if timeout <= 0 {
+		timeout = defaultSessionTimeout
+	}
+	buf := make([]byte, maxDatagramSize)
+	for {
+		s.conn.SetReadDeadline(time.Now().Add(timeout - s.idleFor()))
+		n, err := s.conn.Read(buf)
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				if p.expire(s, timeout) {
+					return
+				}
+				continue
+			}
+			if !errors.Is(err, net.ErrClosed) {
+				p.backendFailed(s, err)
+			}
+			return
+		}
+		s.touch()
+		if _, err := s.pc.WriteTo(buf[:n], s.client); err != nil {
+			return
+		}
+		count(s.backend.URL, "to_client", n)
+	}
+}
+
+// expire removes s if it has been idle for timeout, reporting whether it did.
+func (p *Proxy) expire(s *session, timeout time.Duration) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if s.idleFor() < timeout {
+		return false
+	}
+	p.forget(s)
+	return true
+}
+
+// backendFailed marks the session's backend unhealthy and ends the session.
+// A refused datagram means the backend sent back ICMP port unreachable.
+func (p *Proxy) backendFailed(s *session, err error) {
+	if errors.Is(err, net.ErrClosed) {
+		return
+	}
+	s.backend.SetHealth(false)
+	log.Printf("[ERROR] Backend %s is unavailable: %v", s.backend.URL, err)
+	s.conn.Close()
+}
+
+// closeSession releases s once its reply loop has ended.
+func (p *Proxy) closeSession(s *session) {
+	p.mu.Lock()
+	p.forget(s)
+	p.mu.Unlock()
+
+	s.conn.Close()
+	s.backend.RemoveConnections()
+	metrics.UpdateActiveConnections(s.backend.URL, float64(s.backend.GetConnections()))
+}
+
+// forget removes s from the session table, unless a newer session of the
+// same client replaced it. p.mu must be held.
+func (p *Proxy) forget(s *session) {
+	if p.sessions[s.key] == s {
+		delete(p.sessions, s.key)
+	}
+}
+
+// closeSessions ends the sessions of clients served on pc.
+func (p *Proxy) closeSessions(pc net.PacketConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, s := range p.sessions {
+		if s.pc == pc {
+			s.conn.Close()
+		}
+	}
+}
+
+// count records a relayed datagram of n bytes.
+func count(backendURL, direction string, n int) {
+	metrics.UDPPackets.WithLabelValues(backendURL, direction).Inc()
+	metrics.UDPBytes.WithLabelValues(backendURL, direction).Add(float64(n))
+}
@@ -0,0 +1,219 @@
+package udpproxy
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/novaru/golem/internal/balancer"
+	"github.com/novaru/golem/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// startBackend answers every datagram with name, ":" and the datagram, and
+// returns its udp:// URL.
+func startBackend(t *testing.T, name string) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	go func() {
+		buf := make([]byte, maxDatagramSize)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			pc.WriteTo(append([]byte(name+":"), buf[:n]...), addr)
+		}
+	}()
+	return balancer.UDPScheme + pc.LocalAddr().String()
+}
+
+// startProxy serves p on a local port and returns its address.
+func startProxy(t *testing.T, p *Proxy) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	go p.Serve(pc)
+	return pc.LocalAddr().String()
+}
+
+func dial(t *testing.T, addr string) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func exchange(t *testing.T, conn net.Conn, msg string) string {
+	t.Helper()
+	if _, err := conn.Write([]byte(msg)); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("no reply to %q: %v", msg, err)
+	}
+	return string(buf[:n])
+}
+
+func newBalancer(urls ...string) balancer.Balancer {
+	backends := make([]*balancer.Backend, 0, len(urls))
+	for _, u := range urls {
+		backends = append(backends, balancer.NewBackend(u, 1))
+	}
+	bal, _ := balancer.NewBalancer("roundrobin", backends)
+	return bal
+}
+
+func TestProxySessionsKeepTheirBackend(t *testing.T) {
+	urlA := startBackend(t, "a")
+	urlB := startBackend(t, "b")
+	addr := startProxy(t, &Proxy{Name: "test", Balancer: newBalancer(urlA, urlB)})
+
+	packets := metrics.UDPPackets.WithLabelValues(urlA, "to_backend")
+	bytes := metrics.UDPBytes.WithLabelValues(urlA, "to_client")
+	packetsBefore, bytesBefore := testutil.ToFloat64(packets), testutil.ToFloat64(bytes)
+
+	client1, client2 := dial(t, addr), dial(t, addr)
+	first1 := exchange(t, client1, "one")
+	first2 := exchange(t, client2, "two")
+	if first1[0] == first2[0] {
+		t.Fatalf("expected new sessions to be balanced, got %q and %q", first1, first2)
+	}
+	for range 3 {
+		if got := exchange(t, client1, "one"); got != first1 {
+			t.Errorf("client 1 moved backends: %q then %q", first1, got)
+		}
+		if got := exchange(t, client2, "two"); got != first2 {
+			t.Errorf("client 2 moved backends: %q then %q", first2, got)
+		}
+	}
+
+	if d := testutil.ToFloat64(packets) - packetsBefore; d != 4 {
+		t.Errorf("expected 4 datagrams to backend a, got %v", d)
+	}
+	if d := testutil.ToFloat64(bytes) - bytesBefore; d != 4*5 {
+		t.Errorf("expected 20 reply bytes from backend a, got %v", d)
+	}
+}
+
+func TestProxyHashPinsClientIP(t *testing.T) {
+	urls := []string{startBackend(t, "a"), startBackend(t, "b"), startBackend(t, "c")}
+	addr := startProxy(t, &Proxy{Balancer: newBalancer(urls...), Hash: true})
+
+	// Every socket has its own source port, so each starts a new session.
+	want := exchange(t, dial(t, addr), "x")
+	for range 5 {
+		if got := exchange(t, dial(t, addr), "x"); got != want {
+			t.Errorf("expected hashed sessions to reach the same backend, got %q and %q", want, got)
+		}
+	}
+}
+
+func TestProxySessionTimeout(t *testing.T) {
+	url := startBackend(t, "a")
+	bal := newBalancer(url)
+	p := &Proxy{Balancer: bal, SessionTimeout: 50 * time.Millisecond}
+	addr := startProxy(t, p)
+
+	exchange(t, dial(t, addr), "x")
+	backend := bal.Backends()[0]
+	if backend.GetConnections() != 1 {
+		t.Fatalf("expected one active session, got %d", backend.GetConnections())
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for backend.GetConnections() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("idle session was not closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.sessions) != 0 {
+		t.Errorf("expected no sessions left, got %d", len(p.sessions))
+	}
+}
+
+func TestProxyMarksRefusingBackendUnhealthy(t *testing.T) {
+	pc, _ := net.ListenPacket("udp", "127.0.0.1:0")
+	url := balancer.UDPScheme + pc.LocalAddr().String()
+	pc.Close()
+
+	bal := newBalancer(url)
+	addr := startProxy(t, &Proxy{Balancer: bal})
+	conn := dial(t, addr)
+
+	backend := bal.Backends()[0]
+	deadline := time.Now().Add(2 * time.Second)
+	for backend.IsHealthy() {
+		if time.Now().After(deadline) {
+			t.Fatal("expected backend refusing datagrams to be marked unhealthy")
+		}
+		conn.Write([]byte("x"))
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestProxyCloseStopsServing(t *testing.T) {
+	p := &Proxy{Name: "test", Balancer: newBalancer(startBackend(t, "b1"))}
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- p.Serve(pc) }()
+
+	conn := dial(t, pc.LocalAddr().String())
+	if got := exchange(t, conn, "ping"); got != "b1:ping" {
+		t.Fatalf("expected b1:ping, got %q", got)
+	}
+
+	p.Close()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Serve did not return after Close")
+	}
+}
+
+func TestProxyServeEndsOnlyItsOwnSessions(t *testing.T) {
+	p := &Proxy{Name: "test", Balancer: newBalancer(startBackend(t, "b1"))}
+	first, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- p.Serve(first) }()
+	second := startProxy(t, p)
+
+	exchange(t, dial(t, first.LocalAddr().String()), "ping")
+	client := dial(t, second)
+	exchange(t, client, "ping")
+	p.mu.Lock()
+	s := p.sessions[client.LocalAddr().String()]
+	p.mu.Unlock()
+
+	first.Close()
+	<-done
+	time.Sleep(50 * time.Millisecond)
+	p.mu.Lock()
+	kept := p.sessions[client.LocalAddr().String()] == s
+	p.mu.Unlock()
+	if s == nil || !kept {
+		t.Error("expected the session on the other socket to survive")
+	}
+}